@@ -0,0 +1,31 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import "golang.org/x/sys/windows/registry"
+
+const adminRegKey = `Software\WireGuard`
+
+// AdminBool returns true only if the administrator has explicitly set the
+// named DWORD under HKLM\Software\WireGuard to a non-zero value.
+func AdminBool(name string) bool {
+	return AdminInteger(name) != 0
+}
+
+// AdminInteger returns the named DWORD under HKLM\Software\WireGuard, or
+// zero if the administrator has not set it.
+func AdminInteger(name string) uint64 {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, adminRegKey, registry.QUERY_VALUE)
+	if err != nil {
+		return 0
+	}
+	defer key.Close()
+	val, _, err := key.GetIntegerValue(name)
+	if err != nil {
+		return 0
+	}
+	return val
+}
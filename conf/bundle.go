@@ -0,0 +1,148 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// BundleFileExtension is the extension of files made by EncryptBundle.
+const BundleFileExtension = ".wgbundle"
+
+// A bundle starts with a header, which is authenticated along with the
+// encrypted zip file that follows it:
+//
+//	magic      [8]byte  "WGBUNDLE"
+//	version    uint8    1
+//	time       uint32   Argon2id passes
+//	memory     uint32   Argon2id memory in KiB
+//	threads    uint8    Argon2id parallelism
+//	salt       [16]byte
+//	nonce      [24]byte XChaCha20-Poly1305 nonce
+//
+// Integers are big-endian. The key is derived from the passphrase with
+// Argon2id using the parameters in the header.
+const (
+	bundleMagic      = "WGBUNDLE"
+	bundleVersion    = 1
+	bundleSaltSize   = 16
+	bundleTagSize    = 16
+	bundleHeaderSize = len(bundleMagic) + 1 + 4 + 4 + 1 + bundleSaltSize + chacha20poly1305.NonceSizeX
+
+	// Limits on the parameters accepted from a header, so that a crafted
+	// bundle can't make decrypting it take forever or run out of memory.
+	bundleMaxTime    = 16
+	bundleMaxMemory  = 256 * 1024
+	bundleMaxThreads = 16
+)
+
+// ErrBundlePassphrase is returned when a bundle can't be decrypted, which is
+// either because the passphrase is wrong or because the bundle was altered.
+var ErrBundlePassphrase = errors.New("The passphrase is incorrect, or the bundle is damaged")
+
+type bundleKDFParameters struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+var defaultBundleKDFParameters = bundleKDFParameters{time: 3, memory: 64 * 1024, threads: 4}
+
+// ZipConfigs writes each configuration as NAME.conf into a zip file.
+func ZipConfigs(configs []*Config) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, config := range configs {
+		w, err := writer.Create(config.Name + ".conf")
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.Write([]byte(config.ToWgQuick()))
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// IsBundle tells whether data starts like a bundle of any version.
+func IsBundle(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(bundleMagic))
+}
+
+// EncryptBundle encrypts a zip file of configurations, as made by ZipConfigs,
+// with a key derived from passphrase.
+func EncryptBundle(zipFile []byte, passphrase string) ([]byte, error) {
+	return encryptBundle(zipFile, passphrase, defaultBundleKDFParameters)
+}
+
+func encryptBundle(zipFile []byte, passphrase string, params bundleKDFParameters) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("A passphrase is required")
+	}
+	header := make([]byte, bundleHeaderSize, bundleHeaderSize+len(zipFile)+bundleTagSize)
+	copy(header, bundleMagic)
+	i := len(bundleMagic)
+	header[i] = bundleVersion
+	binary.BigEndian.PutUint32(header[i+1:], params.time)
+	binary.BigEndian.PutUint32(header[i+5:], params.memory)
+	header[i+9] = params.threads
+	saltAndNonce := header[i+10:]
+	_, err := rand.Read(saltAndNonce)
+	if err != nil {
+		return nil, err
+	}
+	salt, nonce := saltAndNonce[:bundleSaltSize], saltAndNonce[bundleSaltSize:]
+	aead, err := chacha20poly1305.NewX(argon2.IDKey([]byte(passphrase), salt, params.time, params.memory, params.threads, chacha20poly1305.KeySize))
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, nonce, zipFile, header), nil
+}
+
+// DecryptBundle returns the zip file of configurations in a bundle.
+func DecryptBundle(bundle []byte, passphrase string) ([]byte, error) {
+	if !IsBundle(bundle) {
+		return nil, errors.New("File is not a WireGuard bundle")
+	}
+	i := len(bundleMagic)
+	if len(bundle) <= i || bundle[i] != bundleVersion {
+		return nil, errors.New("Bundle version is not supported")
+	}
+	if len(bundle) < bundleHeaderSize+bundleTagSize {
+		return nil, ErrBundlePassphrase
+	}
+	header := bundle[:bundleHeaderSize]
+	params := bundleKDFParameters{
+		time:    binary.BigEndian.Uint32(header[i+1:]),
+		memory:  binary.BigEndian.Uint32(header[i+5:]),
+		threads: header[i+9],
+	}
+	if params.time == 0 || params.time > bundleMaxTime || params.memory == 0 || params.memory > bundleMaxMemory || params.threads == 0 || params.threads > bundleMaxThreads {
+		return nil, errors.New("Bundle key derivation parameters are not supported")
+	}
+	salt, nonce := header[i+10:i+10+bundleSaltSize], header[i+10+bundleSaltSize:]
+	aead, err := chacha20poly1305.NewX(argon2.IDKey([]byte(passphrase), salt, params.time, params.memory, params.threads, chacha20poly1305.KeySize))
+	if err != nil {
+		return nil, err
+	}
+	zipFile, err := aead.Open(nil, nonce, bundle[bundleHeaderSize:], header)
+	if err != nil {
+		return nil, ErrBundlePassphrase
+	}
+	return zipFile, nil
+}
@@ -0,0 +1,134 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+var testBundleKDFParameters = bundleKDFParameters{time: 1, memory: 64, threads: 1}
+
+func testBundle(t *testing.T) ([]*Config, []byte) {
+	c, err := FromWgQuick(testInput, "bundled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := *c
+	other.Name = "other"
+	configs := []*Config{c, &other}
+	zipFile, err := ZipConfigs(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return configs, zipFile
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	_, zipFile := testBundle(t)
+	bundle, err := EncryptBundle(zipFile, "correct horse battery staple")
+	if !noError(t, err) {
+		return
+	}
+	if !IsBundle(bundle) {
+		t.Error("Encrypted bundle is not recognized as one")
+	}
+	if bytes.Contains(bundle, zipFile[:30]) {
+		t.Error("Bundle contains the zip file in the clear")
+	}
+	decrypted, err := DecryptBundle(bundle, "correct horse battery staple")
+	if noError(t, err) {
+		equal(t, zipFile, decrypted)
+	}
+	if _, err = EncryptBundle(zipFile, ""); err == nil {
+		t.Error("Empty passphrase should be refused")
+	}
+
+	cheap, err := encryptBundle(zipFile, "correct horse battery staple", testBundleKDFParameters)
+	if !noError(t, err) {
+		return
+	}
+	if _, err = DecryptBundle(cheap, "Correct horse battery staple"); err != ErrBundlePassphrase {
+		t.Errorf("Wrong passphrase should give ErrBundlePassphrase, not %v", err)
+	}
+	again, err := encryptBundle(zipFile, "correct horse battery staple", testBundleKDFParameters)
+	if noError(t, err) && bytes.Equal(again[:bundleHeaderSize], cheap[:bundleHeaderSize]) {
+		t.Error("Salt and nonce should differ between bundles")
+	}
+}
+
+func TestBundleIntegrity(t *testing.T) {
+	_, zipFile := testBundle(t)
+	bundle, err := encryptBundle(zipFile, "passphrase", testBundleKDFParameters)
+	if !noError(t, err) {
+		return
+	}
+	_, err = DecryptBundle(bundle, "passphrase")
+	if !noError(t, err) {
+		return
+	}
+
+	// Altering any byte after the version, whether in the header or in the
+	// encrypted part, must be detected.
+	for _, i := range []int{len(bundleMagic) + 4, len(bundleMagic) + 10, bundleHeaderSize - 1, bundleHeaderSize, len(bundle) - 1} {
+		altered := append([]byte(nil), bundle...)
+		altered[i] ^= 0x01
+		if _, err = DecryptBundle(altered, "passphrase"); err == nil {
+			t.Errorf("Altering byte %d was not detected", i)
+		}
+	}
+	if _, err = DecryptBundle(bundle[:len(bundle)-1], "passphrase"); err == nil {
+		t.Error("Truncation was not detected")
+	}
+	if _, err = DecryptBundle(bundle[:bundleHeaderSize], "passphrase"); err == nil {
+		t.Error("Missing ciphertext was not detected")
+	}
+
+	future := append([]byte(nil), bundle...)
+	future[len(bundleMagic)] = bundleVersion + 1
+	if _, err = DecryptBundle(future, "passphrase"); err == nil || err == ErrBundlePassphrase {
+		t.Errorf("Unknown version should be reported as such, not as %v", err)
+	}
+	if _, err = DecryptBundle(zipFile, "passphrase"); err == nil {
+		t.Error("A plain zip file is not a bundle")
+	}
+
+	expensive := append([]byte(nil), bundle...)
+	binary.BigEndian.PutUint32(expensive[len(bundleMagic)+5:], bundleMaxMemory+1)
+	if _, err = DecryptBundle(expensive, "passphrase"); err == nil || err == ErrBundlePassphrase {
+		t.Errorf("Excessive key derivation parameters should be refused, not give %v", err)
+	}
+	expensive = append([]byte(nil), bundle...)
+	expensive[len(bundleMagic)+9] = bundleMaxThreads + 1
+	if _, err = DecryptBundle(expensive, "passphrase"); err == nil || err == ErrBundlePassphrase {
+		t.Errorf("Excessive parallelism should be refused, not give %v", err)
+	}
+}
+
+func TestImportBundle(t *testing.T) {
+	configs, zipFile := testBundle(t)
+	bundle, err := encryptBundle(zipFile, "passphrase", testBundleKDFParameters)
+	if !noError(t, err) {
+		return
+	}
+	store := NewMemoryStore()
+	results, err := Import(store, []ImportFile{{Path: "tunnels" + BundleFileExtension, Contents: bundle, Passphrase: "wrong"}}, ConflictSkip, "")
+	if noError(t, err) && lenTest(t, results, 1) {
+		equal(t, ImportWrongPassphrase, results[0].Status)
+	}
+	results, err = Import(store, []ImportFile{{Path: "tunnels" + BundleFileExtension, Contents: bundle, Passphrase: "passphrase"}}, ConflictSkip, "")
+	if !noError(t, err) || !lenTest(t, results, len(configs)) {
+		return
+	}
+	for i := range results {
+		equal(t, ImportSucceeded, results[i].Status)
+	}
+	loaded, err := store.Load("other")
+	if noError(t, err) {
+		equal(t, configs[1].Interface.PrivateKey, loaded.Interface.PrivateKey)
+	}
+}
@@ -0,0 +1,254 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"net"
+	"sort"
+)
+
+// uint128 is an IPv6 address, or an IPv4 address in its low 32 bits, as a
+// number, so that networks can be treated as ranges of numbers.
+type uint128 struct {
+	hi, lo uint64
+}
+
+func (u uint128) less(v uint128) bool {
+	return u.hi < v.hi || u.hi == v.hi && u.lo < v.lo
+}
+
+func (u uint128) or(v uint128) uint128 {
+	return uint128{u.hi | v.hi, u.lo | v.lo}
+}
+
+func (u uint128) andNot(v uint128) uint128 {
+	return uint128{u.hi &^ v.hi, u.lo &^ v.lo}
+}
+
+func (u uint128) addOne() uint128 {
+	lo := u.lo + 1
+	if lo == 0 {
+		return uint128{u.hi + 1, lo}
+	}
+	return uint128{u.hi, lo}
+}
+
+func (u uint128) subOne() uint128 {
+	if u.lo == 0 {
+		return uint128{u.hi - 1, u.lo - 1}
+	}
+	return uint128{u.hi, u.lo - 1}
+}
+
+func (u uint128) trailingZeros() int {
+	if u.lo != 0 {
+		return bits.TrailingZeros64(u.lo)
+	}
+	return 64 + bits.TrailingZeros64(u.hi)
+}
+
+// hostMask returns a number with the low n bits set.
+func hostMask(n int) uint128 {
+	switch {
+	case n <= 0:
+		return uint128{}
+	case n < 64:
+		return uint128{0, 1<<uint(n) - 1}
+	case n < 128:
+		return uint128{1<<uint(n-64) - 1, ^uint64(0)}
+	}
+	return uint128{^uint64(0), ^uint64(0)}
+}
+
+// ipRange is the addresses from first to last inclusive, of one family.
+type ipRange struct {
+	first, last uint128
+}
+
+// ipRangeSet holds sorted, disjoint and non-adjacent ranges of each family.
+type ipRangeSet struct {
+	v4, v6 []ipRange
+}
+
+// addressRange returns the addresses of the network r, or false if its IP is
+// not valid.
+func (r *IPCidr) addressRange() (ipRange, bool) {
+	var address uint128
+	if ip := r.IP.To4(); ip != nil {
+		address.lo = uint64(binary.BigEndian.Uint32(ip))
+	} else if ip := r.IP.To16(); ip != nil {
+		address = uint128{binary.BigEndian.Uint64(ip[:8]), binary.BigEndian.Uint64(ip[8:])}
+	} else {
+		return ipRange{}, false
+	}
+	host := hostMask(int(r.Bits()) - int(r.Cidr))
+	return ipRange{address.andNot(host), address.or(host)}, true
+}
+
+func newIPRangeSet(lists ...[]IPCidr) *ipRangeSet {
+	set := &ipRangeSet{}
+	for _, cidrs := range lists {
+		for i := range cidrs {
+			network, ok := cidrs[i].addressRange()
+			if !ok {
+				continue
+			}
+			if cidrs[i].Bits() == 32 {
+				set.v4 = append(set.v4, network)
+			} else {
+				set.v6 = append(set.v6, network)
+			}
+		}
+	}
+	set.v4 = mergeRanges(set.v4)
+	set.v6 = mergeRanges(set.v6)
+	return set
+}
+
+// mergeRanges sorts ranges and joins those that overlap or touch.
+func mergeRanges(ranges []ipRange) []ipRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].first.less(ranges[j].first) })
+	var merged []ipRange
+	for _, r := range ranges {
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			if last.last == hostMask(128) || !last.last.addOne().less(r.first) {
+				if last.last.less(r.last) {
+					last.last = r.last
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// subtractRanges returns the parts of ranges that are not in excluded, both
+// of which must be merged.
+func subtractRanges(ranges, excluded []ipRange) []ipRange {
+	var remaining []ipRange
+	j := 0
+	for _, r := range ranges {
+		for j < len(excluded) && excluded[j].last.less(r.first) {
+			j++
+		}
+		for k := j; k < len(excluded) && !r.last.less(excluded[k].first); k++ {
+			if r.first.less(excluded[k].first) {
+				remaining = append(remaining, ipRange{r.first, excluded[k].first.subOne()})
+			}
+			if !excluded[k].last.less(r.last) {
+				r.first, r.last = hostMask(128), uint128{}
+				break
+			}
+			r.first = excluded[k].last.addOne()
+		}
+		if !r.last.less(r.first) {
+			remaining = append(remaining, r)
+		}
+	}
+	return remaining
+}
+
+// rangeCidrs splits ranges of addresses that are addressBits long into the
+// fewest networks that cover them exactly.
+func rangeCidrs(ranges []ipRange, addressBits int) []IPCidr {
+	var cidrs []IPCidr
+	for _, r := range ranges {
+		first := r.first
+		for {
+			size := first.trailingZeros()
+			if size > addressBits {
+				size = addressBits
+			}
+			for size > 0 && r.last.less(first.or(hostMask(size))) {
+				size--
+			}
+			var ip net.IP
+			if addressBits == 32 {
+				ip = make(net.IP, net.IPv4len)
+				binary.BigEndian.PutUint32(ip, uint32(first.lo))
+			} else {
+				ip = make(net.IP, net.IPv6len)
+				binary.BigEndian.PutUint64(ip[:8], first.hi)
+				binary.BigEndian.PutUint64(ip[8:], first.lo)
+			}
+			cidrs = append(cidrs, IPCidr{ip, uint8(addressBits - size)})
+			last := first.or(hostMask(size))
+			if last == r.last {
+				break
+			}
+			first = last.addOne()
+		}
+	}
+	return cidrs
+}
+
+func (set *ipRangeSet) cidrs() []IPCidr {
+	return append(rangeCidrs(set.v4, 32), rangeCidrs(set.v6, 128)...)
+}
+
+// UnionIPCidrs returns the fewest networks that together cover exactly the
+// addresses of all of the lists, IPv4 before IPv6, each in ascending order.
+func UnionIPCidrs(lists ...[]IPCidr) []IPCidr {
+	return newIPRangeSet(lists...).cidrs()
+}
+
+// MinimizeIPCidrs returns the fewest networks that cover exactly the
+// addresses of cidrs, joining adjacent networks and dropping those contained
+// in others.
+func MinimizeIPCidrs(cidrs []IPCidr) []IPCidr {
+	return UnionIPCidrs(cidrs)
+}
+
+// SubtractIPCidrs returns the fewest networks that cover the addresses of
+// cidrs that are not in excluded.
+func SubtractIPCidrs(cidrs []IPCidr, excluded []IPCidr) []IPCidr {
+	set, without := newIPRangeSet(cidrs), newIPRangeSet(excluded)
+	set.v4 = subtractRanges(set.v4, without.v4)
+	set.v6 = subtractRanges(set.v6, without.v6)
+	return set.cidrs()
+}
+
+// EffectiveAllowedIPs returns the allowed IPs of the peer with its excluded
+// IPs taken out, which are what is routed to it when the tunnel runs.
+func (peer *Peer) EffectiveAllowedIPs() []IPCidr {
+	if len(peer.ExcludedIPs) == 0 {
+		return peer.AllowedIPs
+	}
+	return SubtractIPCidrs(peer.AllowedIPs, peer.ExcludedIPs)
+}
+
+// sameIPCidrs reports whether a and b cover exactly the same addresses.
+func sameIPCidrs(a, b []IPCidr) bool {
+	a, b = UnionIPCidrs(a), UnionIPCidrs(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Cidr != b[i].Cidr || !a[i].IP.Equal(b[i].IP) {
+			return false
+		}
+	}
+	return true
+}
+
+// IPCidrsContain reports whether any of cidrs contains ip.
+func IPCidrsContain(cidrs []IPCidr, ip net.IP) bool {
+	for i := range cidrs {
+		if cidrs[i].contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *IPCidr) contains(ip net.IP) bool {
+	network := r.IPNet()
+	return network.Contains(ip)
+}
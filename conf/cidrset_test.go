@@ -0,0 +1,227 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"math/rand"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+// cidrList generates networks mostly within 10.0.0.0/22 and fd00::/118, so
+// that a brute-force reference can check every address near them, with the
+// occasional much larger network.
+type cidrList []IPCidr
+
+func (cidrList) Generate(random *rand.Rand, size int) reflect.Value {
+	list := make(cidrList, random.Intn(8))
+	for i := range list {
+		var ip net.IP
+		var cidr int
+		if random.Intn(2) == 0 {
+			ip = net.IPv4(10, 0, byte(random.Intn(4)), byte(random.Intn(256))).To4()
+			cidr = 22 + random.Intn(11)
+		} else {
+			ip = net.ParseIP("fd00::")
+			ip[14], ip[15] = byte(random.Intn(4)), byte(random.Intn(256))
+			cidr = 118 + random.Intn(11)
+		}
+		if random.Intn(20) == 0 {
+			cidr = random.Intn(cidr / 2)
+		}
+		list[i] = IPCidr{ip, uint8(cidr)}
+	}
+	return reflect.ValueOf(list)
+}
+
+// sampleIPs returns every address of the generated ranges, along with those
+// around them and at the ends of both address spaces.
+func sampleIPs() []net.IP {
+	var ips []net.IP
+	for i := -4; i < 1024+4; i++ {
+		ips = append(ips, net.IPv4(10, 0, byte(i>>8), byte(i)).To4())
+		ip := net.ParseIP("fd00::")
+		ip[14], ip[15] = byte(i>>8), byte(i)
+		ips = append(ips, ip)
+	}
+	ips = append(ips, net.ParseIP("9.255.255.255").To4(), net.IPv4zero.To4(), net.IPv4bcast.To4(), net.ParseIP("fcff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"), net.IPv6zero, net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"))
+	return ips
+}
+
+// isMinimal reports whether cidrs are in order, without host bits, disjoint
+// and without both halves of any network, which makes them the fewest
+// networks that cover their addresses.
+func isMinimal(cidrs []IPCidr) bool {
+	for i := range cidrs {
+		network := cidrs[i].IPNet()
+		if !network.IP.Mask(network.Mask).Equal(cidrs[i].IP) {
+			return false
+		}
+		if i == 0 {
+			continue
+		}
+		previous, current := &cidrs[i-1], &cidrs[i]
+		if previous.Bits() != current.Bits() {
+			if previous.Bits() > current.Bits() {
+				return false
+			}
+			continue
+		}
+		previousRange, _ := previous.addressRange()
+		currentRange, _ := current.addressRange()
+		if !previousRange.last.less(currentRange.first) {
+			return false
+		}
+		parent := IPCidr{current.IP, current.Cidr - 1}
+		if previous.Cidr == current.Cidr && current.Cidr > 0 && parent.contains(previous.IP) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestIPCidrSetProperties(t *testing.T) {
+	ips := sampleIPs()
+	union := func(a, b cidrList) bool {
+		result := UnionIPCidrs(a, b)
+		for _, ip := range ips {
+			if IPCidrsContain(result, ip) != (IPCidrsContain(a, ip) || IPCidrsContain(b, ip)) {
+				t.Errorf("Union of %v and %v is wrong about %v", a, b, ip)
+				return false
+			}
+		}
+		return isMinimal(result) && reflect.DeepEqual(MinimizeIPCidrs(result), result)
+	}
+	subtract := func(a, e cidrList) bool {
+		result := SubtractIPCidrs(a, e)
+		for _, ip := range ips {
+			if IPCidrsContain(result, ip) != (IPCidrsContain(a, ip) && !IPCidrsContain(e, ip)) {
+				t.Errorf("%v without %v is wrong about %v", a, e, ip)
+				return false
+			}
+		}
+		return isMinimal(result) && reflect.DeepEqual(MinimizeIPCidrs(result), result)
+	}
+	config := &quick.Config{MaxCount: 500, Rand: rand.New(rand.NewSource(1))}
+	if err := quick.Check(union, config); err != nil {
+		t.Error(err)
+	}
+	if err := quick.Check(subtract, config); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIPCidrSetExamples(t *testing.T) {
+	parse := func(s string) []IPCidr {
+		if len(s) == 0 {
+			return nil
+		}
+		cidrs, err := parseIPCidrList(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cidrs
+	}
+	format := func(cidrs []IPCidr) string {
+		return strings.Join(cidrStrings(cidrs), ", ")
+	}
+	equal(t, "0.0.0.0/0, ::/0", format(MinimizeIPCidrs(parse("128.0.0.0/1, ::/1, 0.0.0.0/1, 8000::/1"))))
+	equal(t, "10.0.0.0/8, ::/0", format(MinimizeIPCidrs(parse("10.1.2.3/8, 10.9.0.0/16, fd00::/8, ::/0"))))
+	equal(t, "255.255.255.254/31", format(UnionIPCidrs(parse("255.255.255.255/32"), parse("255.255.255.254"))))
+	equal(t, "0.0.0.0/1, 128.0.0.0/2, 192.0.0.0/9, 192.128.0.0/11, 192.160.0.0/13, 192.169.0.0/16, 192.170.0.0/15, 192.172.0.0/14, 192.176.0.0/12, 192.192.0.0/10, 193.0.0.0/8, 194.0.0.0/7, 196.0.0.0/6, 200.0.0.0/5, 208.0.0.0/4, 224.0.0.0/3",
+		format(SubtractIPCidrs(parse("0.0.0.0/0"), parse("192.168.0.0/16"))))
+	equal(t, 10, len(SubtractIPCidrs(parse("::/0"), parse("fe80::/10"))))
+	equal(t, "", format(SubtractIPCidrs(parse("10.0.0.0/8"), parse("0.0.0.0/0"))))
+	equal(t, "10.0.0.0/8", format(SubtractIPCidrs(parse("10.0.0.0/8"), parse("::/0, 11.0.0.0/8"))))
+	if !IPCidrsContain(parse("10.0.0.0/8, fd00::/8"), net.ParseIP("fd12::1")) || IPCidrsContain(parse("10.0.0.0/8"), net.ParseIP("11.0.0.1")) {
+		t.Error("IPCidrsContain is wrong")
+	}
+}
+
+func TestExcludedIPs(t *testing.T) {
+	input := strings.Replace(testInput, "AllowedIPs = 10.192.122.3/32, 10.192.124.1/24", "AllowedIPs = 0.0.0.0/0, ::/0\n# ExcludedIPs = 192.168.0.0/16, fe80::/10", 1)
+	config, err := FromWgQuick(input, "excluded")
+	if !noError(t, err) {
+		return
+	}
+	peer := &config.Peers[0]
+	lenTest(t, peer.ExcludedIPs, 2)
+	equal(t, "0.0.0.0/0, ::/0", strings.Join(cidrStrings(peer.AllowedIPs), ", "))
+	effective := peer.EffectiveAllowedIPs()
+	equal(t, 16+10, len(effective))
+	if IPCidrsContain(effective, net.ParseIP("192.168.1.1")) || !IPCidrsContain(effective, net.ParseIP("8.8.8.8")) {
+		t.Errorf("Excluded IPs were not taken out of %v", effective)
+	}
+	uapi := config.toUAPI()
+	if strings.Contains(uapi, "allowed_ip=0.0.0.0/0\n") || !strings.Contains(uapi, "allowed_ip=0.0.0.0/1\n") {
+		t.Errorf("Excluded IPs were not taken out of the UAPI configuration:\n%s", uapi)
+	}
+
+	// The file is written back unchanged, and without its Document, the
+	// allowed IPs are written as given, next to the annotation.
+	equal(t, input, config.ToWgQuick())
+	generated := *config
+	generated.Document = nil
+	text := generated.ToWgQuick()
+	if !strings.Contains(text, "# ExcludedIPs = 192.168.0.0/16, fe80::/10") || !strings.Contains(text, "AllowedIPs = 0.0.0.0/0, ::/0") {
+		t.Errorf("Excluded IPs were not written:\n%s", text)
+	}
+	reparsed, err := FromWgQuick(text, "excluded")
+	if noError(t, err) {
+		equal(t, peer.AllowedIPs, reparsed.Peers[0].AllowedIPs)
+		equal(t, peer.ExcludedIPs, reparsed.Peers[0].ExcludedIPs)
+		if reparsed.Peers[0].Metadata != nil {
+			t.Errorf("Excluded IPs ended up in the metadata: %v", reparsed.Peers[0].Metadata)
+		}
+	}
+	data, err := config.ToJSON()
+	if noError(t, err) {
+		fromJSON, err := FromJSON(data, "excluded")
+		if noError(t, err) {
+			equal(t, peer.AllowedIPs, fromJSON.Peers[0].AllowedIPs)
+			equal(t, peer.ExcludedIPs, fromJSON.Peers[0].ExcludedIPs)
+		}
+	}
+
+	// The running tunnel reports the effective allowed IPs, which are saved
+	// as written unless they were changed.
+	runtimeUAPI := func(allowedIPs []IPCidr) string {
+		uapi := "private_key=" + config.Interface.PrivateKey.HexString() + "\npublic_key=" + peer.PublicKey.HexString() + "\n"
+		for _, allowedip := range allowedIPs {
+			uapi += "allowed_ip=" + allowedip.String() + "\n"
+		}
+		return uapi + "errno=0\n"
+	}
+	running, err := FromUAPI(runtimeUAPI(effective), config)
+	if noError(t, err) {
+		equal(t, peer.AllowedIPs, running.Peers[0].AllowedIPs)
+	}
+	changed, err := FromUAPI(runtimeUAPI(effective[1:]), config)
+	if noError(t, err) {
+		equal(t, effective[1:], changed.Peers[0].AllowedIPs)
+	}
+
+	// Excluding IPs stops blocking untunneled traffic, which resumes once
+	// they are no longer excluded.
+	single := *config
+	single.Peers = config.Peers[:1]
+	if single.BlocksUntunneledTraffic() {
+		t.Error("Untunneled traffic should not be blocked with excluded IPs")
+	}
+	single.Peers = []Peer{*peer}
+	single.Peers[0].ExcludedIPs = nil
+	if !single.BlocksUntunneledTraffic() {
+		t.Error("Untunneled traffic should be blocked again without excluded IPs")
+	}
+
+	_, diagnostics := FromWgQuickWithDiagnostics(strings.Replace(input, "fe80::/10", "fe80::/300", 1), "excluded")
+	if !HasErrors(diagnostics) {
+		t.Error("Invalid excluded IPs should be an error")
+	}
+}
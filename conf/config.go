@@ -7,6 +7,7 @@ package conf
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/base64"
 	"encoding/hex"
@@ -38,6 +39,10 @@ type Config struct {
 	Name      string
 	Interface Interface
 	Peers     []Peer
+
+	// Document is the text the configuration was parsed from, if any, which
+	// ToWgQuick updates rather than regenerating, preserving comments.
+	Document *Document
 }
 
 type Interface struct {
@@ -46,6 +51,19 @@ type Interface struct {
 	ListenPort uint16
 	MTU        uint16
 	DNS        []net.IP
+	DNSSearch  []string
+	PreUp      []string
+	PostUp     []string
+	PreDown    []string
+	PostDown   []string
+	Table      string
+	FwMark     uint32
+	SaveConfig bool
+
+	// Resolver selects how the host names of peer endpoints are looked up, as
+	// described by NewResolver. It is written as a "# Resolver = " annotation,
+	// so that the file remains usable with wg-quick.
+	Resolver string
 }
 
 type Peer struct {
@@ -55,11 +73,31 @@ type Peer struct {
 	Endpoint            Endpoint
 	PersistentKeepalive uint16
 
+	// ExcludedIPs are taken out of AllowedIPs when the configuration is
+	// applied, leaving the fewest networks that cover the rest, so that for
+	// instance a local network can be left out of a full tunnel. They are
+	// written as a "# ExcludedIPs = " annotation, and AllowedIPs are kept
+	// and written as they were given; EffectiveAllowedIPs returns what is
+	// left of them.
+	ExcludedIPs []IPCidr
+
+	// Name and Metadata come from "# Name = " and "# Meta.Key = Value"
+	// annotations in the peer's section, the latter with MetadataPrefix
+	// taken off their keys.
+	Name     string
+	Metadata map[string]string
+
 	RxBytes           Bytes
 	TxBytes           Bytes
 	LastHandshakeTime HandshakeTime
 }
 
+// TableOff is the value of Interface.Table that disables the installation of
+// routes for peer allowed IPs, leaving routing to be managed externally. An
+// empty Table means "auto", and any other value is a numeric table, which
+// Windows has no notion of, so it is treated the same as "auto".
+const TableOff = "off"
+
 func (r *IPCidr) String() string {
 	return fmt.Sprintf("%s/%d", r.IP.String(), r.Cidr)
 }
@@ -78,6 +116,10 @@ func (r *IPCidr) IPNet() net.IPNet {
 	}
 }
 
+func (iface *Interface) TableIsOff() bool {
+	return iface.Table == TableOff
+}
+
 func (e *Endpoint) String() string {
 	if strings.IndexByte(e.Host, ':') > 0 {
 		return fmt.Sprintf("[%s]:%d", e.Host, e.Port)
@@ -97,6 +139,18 @@ func (k *Key) HexString() string {
 	return hex.EncodeToString(k[:])
 }
 
+// Fingerprint returns a short identifier for the key that is stable but
+// doesn't reveal it, for use in place of secrets in redacted output.
+func (k *Key) Fingerprint() string {
+	hash := sha256.Sum256(k[:])
+	return hex.EncodeToString(hash[:4])
+}
+
+// Redacted returns a placeholder for the key that includes its fingerprint.
+func (k *Key) Redacted() string {
+	return fmt.Sprintf("(redacted %s)", k.Fingerprint())
+}
+
 func (k *Key) IsZero() bool {
 	var zeros Key
 	return subtle.ConstantTimeCompare(zeros[:], k[:]) == 1
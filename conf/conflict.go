@@ -0,0 +1,108 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"fmt"
+)
+
+// BlocksUntunneledTraffic reports whether the tunnel service engages a
+// firewall that blocks all traffic that is neither to nor from the tunnel,
+// which it does when the configuration has exactly one peer, one of whose
+// allowed IPs is 0.0.0.0/0 or ::/0 once its excluded IPs are taken out.
+func (config *Config) BlocksUntunneledTraffic() bool {
+	if len(config.Peers) != 1 {
+		return false
+	}
+	for _, allowedip := range config.Peers[0].EffectiveAllowedIPs() {
+		if allowedip.Cidr == 0 && allowedip.IP.IsUnspecified() {
+			return true
+		}
+	}
+	return false
+}
+
+// routesAll returns, for IPv4 and IPv6, whether cidrs together cover all
+// addresses of that family.
+func routesAll(cidrs ...[]IPCidr) (v4, v6 bool) {
+	for _, cidr := range UnionIPCidrs(cidrs...) {
+		if cidr.Cidr == 0 {
+			if cidr.Bits() == 32 {
+				v4 = true
+			} else {
+				v6 = true
+			}
+		}
+	}
+	return
+}
+
+// fullTunnelFamilies returns, for IPv4 and IPv6, whether the configuration
+// routes all traffic of that family, apart from any excluded IPs.
+func (config *Config) fullTunnelFamilies() (v4, v6 bool) {
+	var cidrs [][]IPCidr
+	for i := range config.Peers {
+		cidrs = append(cidrs, config.Peers[i].AllowedIPs, config.Peers[i].ExcludedIPs)
+	}
+	return routesAll(cidrs...)
+}
+
+// narrowAllowedIPs returns the allowed IPs of the configuration other than
+// those of peers that take all traffic of a family, which any narrower route
+// of another tunnel overrides.
+func (config *Config) narrowAllowedIPs() []IPCidr {
+	var narrow []IPCidr
+	for i := range config.Peers {
+		peer := &config.Peers[i]
+		v4, v6 := routesAll(peer.AllowedIPs, peer.ExcludedIPs)
+		for _, allowedip := range peer.EffectiveAllowedIPs() {
+			if !(allowedip.Bits() == 32 && v4 || allowedip.Bits() == 128 && v6) {
+				narrow = append(narrow, allowedip)
+			}
+		}
+	}
+	return narrow
+}
+
+// TunnelConflict returns why the tunnel of config cannot run at the same time
+// as the one of other, or nil if it can. Tunnels conflict when they listen on
+// the same port, when either blocks untunneled traffic, which cuts off the
+// other, when both route all traffic of an address family, or when any of
+// their narrower allowed IPs overlap, which leaves it to route metrics or
+// prefix lengths to decide where packets go. A full tunnel may run along
+// with tunnels for narrower networks, whose more specific routes win.
+func TunnelConflict(config *Config, other *Config) error {
+	if config.Interface.ListenPort != 0 && config.Interface.ListenPort == other.Interface.ListenPort {
+		return fmt.Errorf("Tunnel ‘%s’ already listens on port %d", other.Name, other.Interface.ListenPort)
+	}
+	if other.BlocksUntunneledTraffic() {
+		return fmt.Errorf("Tunnel ‘%s’ blocks all untunneled traffic, which would include that of ‘%s’", other.Name, config.Name)
+	}
+	if config.BlocksUntunneledTraffic() {
+		return fmt.Errorf("Tunnel ‘%s’ blocks all untunneled traffic, which would include that of ‘%s’", config.Name, other.Name)
+	}
+	if config.Interface.TableIsOff() || other.Interface.TableIsOff() {
+		return nil
+	}
+
+	full4, full6 := config.fullTunnelFamilies()
+	otherFull4, otherFull6 := other.fullTunnelFamilies()
+	if full4 && otherFull4 {
+		return fmt.Errorf("Tunnels ‘%s’ and ‘%s’ both route all IPv4 traffic", config.Name, other.Name)
+	}
+	if full6 && otherFull6 {
+		return fmt.Errorf("Tunnels ‘%s’ and ‘%s’ both route all IPv6 traffic", config.Name, other.Name)
+	}
+	narrow, otherNarrow := config.narrowAllowedIPs(), other.narrowAllowedIPs()
+	for i := range narrow {
+		for j := range otherNarrow {
+			if narrow[i].overlaps(&otherNarrow[j]) {
+				return fmt.Errorf("Allowed IPs %s overlap %s of tunnel ‘%s’", narrow[i].String(), otherNarrow[j].String(), other.Name)
+			}
+		}
+	}
+	return nil
+}
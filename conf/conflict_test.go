@@ -0,0 +1,78 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"strings"
+	"testing"
+)
+
+func conflictTestConfig(t *testing.T, name string, listenPort string, peers ...string) *Config {
+	text := "[Interface]\nPrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=\n" + listenPort
+	keys := []string{"xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=", "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="}
+	for i, peer := range peers {
+		text += "\n[Peer]\nPublicKey = " + keys[i] + "\n" + peer + "\n"
+	}
+	config, err := FromWgQuick(text, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestTunnelConflict(t *testing.T) {
+	corp := conflictTestConfig(t, "corp", "ListenPort = 51820", "AllowedIPs = 10.0.0.0/8, fd00::/8")
+	lab := conflictTestConfig(t, "lab", "", "AllowedIPs = 192.168.50.0/24", "AllowedIPs = 172.16.0.0/12")
+	full := conflictTestConfig(t, "full", "", "AllowedIPs = 0.0.0.0/1, 128.0.0.0/1, ::/0", "AllowedIPs = 192.168.50.0/24")
+	killSwitch := conflictTestConfig(t, "killswitch", "", "AllowedIPs = 0.0.0.0/0")
+	excluding := conflictTestConfig(t, "excluding", "", "AllowedIPs = 0.0.0.0/0\n# ExcludedIPs = 192.168.0.0/16")
+
+	for _, test := range []struct {
+		a, b     *Config
+		conflict string
+	}{
+		{corp, lab, ""},
+		{corp, full, ""},
+		{lab, full, "overlap"},
+		{full, excluding, "both route all IPv4"},
+		{corp, killSwitch, "blocks all untunneled traffic"},
+		{killSwitch, corp, "blocks all untunneled traffic"},
+		{corp, excluding, ""},
+		{lab, excluding, ""},
+		{corp, corp, "port 51820"},
+	} {
+		for _, swap := range []bool{false, true} {
+			a, b := test.a, test.b
+			if swap {
+				a, b = b, a
+			}
+			err := TunnelConflict(a, b)
+			if len(test.conflict) == 0 && err != nil {
+				t.Errorf("‘%s’ and ‘%s’ should not conflict: %v", a.Name, b.Name, err)
+			} else if len(test.conflict) > 0 && (err == nil || !strings.Contains(err.Error(), test.conflict)) {
+				t.Errorf("‘%s’ and ‘%s’ should conflict with %q, not %v", a.Name, b.Name, test.conflict, err)
+			}
+		}
+	}
+
+	// Routing managed externally leaves no routes to clash, but the firewall
+	// that blocks untunneled traffic is still engaged.
+	full.Interface.Table = TableOff
+	if err := TunnelConflict(full, excluding); err != nil {
+		t.Errorf("Table = off should avoid routing conflicts: %v", err)
+	}
+	killSwitch.Interface.Table = TableOff
+	if !killSwitch.BlocksUntunneledTraffic() {
+		t.Error("Table = off should still block untunneled traffic")
+	}
+	if err := TunnelConflict(corp, killSwitch); err == nil {
+		t.Error("Table = off should not hide that untunneled traffic is blocked")
+	}
+	full.Interface.ListenPort = 51820
+	if err := TunnelConflict(corp, full); err == nil {
+		t.Error("The same listen port should conflict even with Table = off")
+	}
+}
@@ -0,0 +1,327 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	dnsQueryTimeout    = time.Second * 5
+	dnsMaxMessageSize  = 65535
+	dohMessageMimeType = "application/dns-message"
+)
+
+// NewResolver returns the resolver for the Resolver setting of an interface.
+// An empty spec selects DefaultResolver. A spec starting with "https://" is
+// the URL of a DNS-over-HTTPS server. Since looking up its host with the
+// system resolver would defeat the purpose of the setting, the host must be
+// an address, or else the URL must be followed by a space and the address at
+// which to reach the host, as in "https://dns.example/dns-query 192.0.2.53",
+// in which case the certificate is still checked against the host name.
+// Anything else is the address of a DNS server, with an optional port that
+// defaults to 53.
+func NewResolver(spec string) (Resolver, error) {
+	if len(spec) == 0 {
+		return DefaultResolver, nil
+	}
+	if strings.HasPrefix(strings.ToLower(spec), "https://") {
+		fields := strings.Fields(spec)
+		u, err := url.Parse(fields[0])
+		if err != nil || len(u.Host) == 0 || len(u.Fragment) > 0 || u.User != nil || len(fields) > 2 {
+			return nil, &ParseError{"Invalid DNS-over-HTTPS URL", spec}
+		}
+		bootstrap := u.Hostname()
+		if len(fields) == 2 {
+			bootstrap = strings.TrimSuffix(strings.TrimPrefix(fields[1], "["), "]")
+		}
+		if !isIPLiteral(bootstrap) {
+			return nil, &ParseError{"DNS-over-HTTPS server must be given by address, or followed by the address at which to reach it", spec}
+		}
+		return newDoHResolver(u.String(), bootstrap), nil
+	}
+	host, port, err := net.SplitHostPort(spec)
+	if err != nil {
+		host, port = strings.TrimSuffix(strings.TrimPrefix(spec, "["), "]"), "53"
+	}
+	if !isIPLiteral(host) {
+		return nil, &ParseError{"Invalid resolver address", spec}
+	}
+	if p, err := parsePort(port); err != nil || p == 0 {
+		return nil, &ParseError{"Invalid resolver port", spec}
+	}
+	return &serverResolver{net.JoinHostPort(host, port)}, nil
+}
+
+// dnsExchange sends a single query and returns the raw response, giving up
+// once ctx is done.
+type dnsExchange func(ctx context.Context, query []byte) ([]byte, error)
+
+// lookupWithExchange asks for both the IPv6 and IPv4 addresses of name,
+// returning whichever are found.
+func lookupWithExchange(ctx context.Context, name, server string, exchange dnsExchange, randomID bool) ([]net.IPAddr, error) {
+	fqdn := name
+	if !strings.HasSuffix(fqdn, ".") {
+		fqdn += "."
+	}
+	qname, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return nil, &net.DNSError{Err: "invalid host name", Name: name, Server: server}
+	}
+	var addrs []net.IPAddr
+	var firstErr error
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeAAAA, dnsmessage.TypeA} {
+		found, err := lookupType(ctx, qname, qtype, exchange, randomID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if dnsErr, ok := err.(*net.DNSError); ok {
+				dnsErr.Name, dnsErr.Server = name, server
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		addrs = append(addrs, found...)
+	}
+	if len(addrs) > 0 {
+		return addrs, nil
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, Server: server, IsNotFound: true}
+}
+
+func lookupType(ctx context.Context, qname dnsmessage.Name, qtype dnsmessage.Type, exchange dnsExchange, randomID bool) ([]net.IPAddr, error) {
+	var id uint16
+	if randomID {
+		var b [2]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return nil, err
+		}
+		id = binary.BigEndian.Uint16(b[:])
+	}
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	packed, err = exchange(ctx, packed)
+	if err != nil {
+		return nil, err
+	}
+	var response dnsmessage.Message
+	err = response.Unpack(packed)
+	if err != nil || !response.Header.Response || response.Header.ID != id {
+		return nil, &net.DNSError{Err: "invalid DNS response", IsTemporary: true}
+	}
+	switch response.Header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, &net.DNSError{Err: "no such host", IsNotFound: true}
+	case dnsmessage.RCodeServerFailure:
+		return nil, &net.DNSError{Err: "server failure", IsTemporary: true}
+	default:
+		return nil, &net.DNSError{Err: fmt.Sprintf("server answered %s", response.Header.RCode.String())}
+	}
+
+	// Follow CNAME records from the name that was asked for, in any order.
+	names := map[string]bool{strings.ToLower(qname.String()): true}
+	for grew := true; grew; {
+		grew = false
+		for _, answer := range response.Answers {
+			if cname, ok := answer.Body.(*dnsmessage.CNAMEResource); ok && names[strings.ToLower(answer.Header.Name.String())] {
+				target := strings.ToLower(cname.CNAME.String())
+				if !names[target] {
+					names[target] = true
+					grew = true
+				}
+			}
+		}
+	}
+	var addrs []net.IPAddr
+	for _, answer := range response.Answers {
+		if !names[strings.ToLower(answer.Header.Name.String())] {
+			continue
+		}
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			addrs = append(addrs, net.IPAddr{IP: net.IP(append([]byte(nil), body.A[:]...))})
+		case *dnsmessage.AAAAResource:
+			addrs = append(addrs, net.IPAddr{IP: net.IP(append([]byte(nil), body.AAAA[:]...))})
+		}
+	}
+	return addrs, nil
+}
+
+// dohResolver looks up names with a DNS-over-HTTPS server, as described in
+// RFC 8484.
+type dohResolver struct {
+	url    string
+	client *http.Client
+}
+
+// newDoHResolver returns a resolver for the server at url, which it always
+// connects to at the address bootstrap, without any proxy, so that nothing
+// is looked up through the system resolver.
+func newDoHResolver(url string, bootstrap string) *dohResolver {
+	dialer := &net.Dialer{Timeout: dnsQueryTimeout}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		_, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.DialContext(ctx, network, net.JoinHostPort(bootstrap, port))
+	}
+	return &dohResolver{url: url, client: &http.Client{Transport: transport, Timeout: dnsQueryTimeout}}
+}
+
+func (r *dohResolver) LookupHost(ctx context.Context, name string) ([]net.IPAddr, error) {
+	return lookupRetryPolicy.lookup(ctx, name, func(ctx context.Context, name string) ([]net.IPAddr, error) {
+		return lookupWithExchange(ctx, name, r.url, r.exchange, false)
+	})
+}
+
+func (r *dohResolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", dohMessageMimeType)
+	request.Header.Set("Accept", dohMessageMimeType)
+	response, err := r.client.Do(request)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	} else if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), IsTemporary: true}
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, &net.DNSError{Err: fmt.Sprintf("server answered %s", response.Status), IsTemporary: response.StatusCode >= 500}
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, dnsMaxMessageSize))
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), IsTemporary: true}
+	}
+	return body, nil
+}
+
+// serverResolver looks up names with a DNS server over UDP, falling back to
+// TCP for answers that don't fit in a datagram.
+type serverResolver struct {
+	server string
+}
+
+func (r *serverResolver) LookupHost(ctx context.Context, name string) ([]net.IPAddr, error) {
+	return lookupRetryPolicy.lookup(ctx, name, func(ctx context.Context, name string) ([]net.IPAddr, error) {
+		return lookupWithExchange(ctx, name, r.server, r.exchange, true)
+	})
+}
+
+func (r *serverResolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	response, err := r.exchangeUDP(ctx, query)
+	if err == nil && len(response) > 2 && response[2]&0x02 != 0 {
+		response, err = r.exchangeTCP(ctx, query)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	} else if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), IsTemporary: true}
+	}
+	return response, nil
+}
+
+// dial connects to the server, closing the connection if ctx is done before
+// the returned function is called.
+func (r *serverResolver) dial(ctx context.Context, network string) (net.Conn, func(), error) {
+	dialer := net.Dialer{Timeout: dnsQueryTimeout}
+	conn, err := dialer.DialContext(ctx, network, r.server)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Now().Add(dnsQueryTimeout))
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	return conn, func() {
+		close(done)
+		conn.Close()
+	}, nil
+}
+
+func (r *serverResolver) exchangeUDP(ctx context.Context, query []byte) ([]byte, error) {
+	conn, closeConn, err := r.dial(ctx, "udp")
+	if err != nil {
+		return nil, err
+	}
+	defer closeConn()
+	_, err = conn.Write(query)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, dnsMaxMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Skip stray datagrams that can't be answers to this query.
+		if n >= 2 && buf[0] == query[0] && buf[1] == query[1] {
+			return buf[:n], nil
+		}
+	}
+}
+
+func (r *serverResolver) exchangeTCP(ctx context.Context, query []byte) ([]byte, error) {
+	conn, closeConn, err := r.dial(ctx, "tcp")
+	if err != nil {
+		return nil, err
+	}
+	defer closeConn()
+	message := make([]byte, 2, 2+len(query))
+	binary.BigEndian.PutUint16(message, uint16(len(query)))
+	_, err = conn.Write(append(message, query...))
+	if err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	_, err = io.ReadFull(conn, length[:])
+	if err != nil {
+		return nil, err
+	}
+	response := make([]byte, binary.BigEndian.Uint16(length[:]))
+	_, err = io.ReadFull(conn, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
@@ -0,0 +1,286 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestNewResolver(t *testing.T) {
+	valid := map[string]string{
+		"1.1.1.1":                                      "1.1.1.1:53",
+		"1.1.1.1:5353":                                 "1.1.1.1:5353",
+		"2606:4700:4700::1111":                         "[2606:4700:4700::1111]:53",
+		"[2606:4700:4700::1111]":                       "[2606:4700:4700::1111]:53",
+		"[2606:4700:4700::1111]:853":                   "[2606:4700:4700::1111]:853",
+		"https://1.1.1.1/dns-query":                    "https://1.1.1.1/dns-query",
+		"HTTPS://dns.example/dns-query?x=1 192.0.2.53": "https://dns.example/dns-query?x=1",
+		"https://dns.example/dns-query [2001:db8::53]": "https://dns.example/dns-query",
+	}
+	for spec, expected := range valid {
+		r, err := NewResolver(spec)
+		if err != nil {
+			t.Errorf("%s: %v", spec, err)
+			continue
+		}
+		switch r := r.(type) {
+		case *serverResolver:
+			equal(t, expected, r.server)
+		case *dohResolver:
+			equal(t, expected, r.url)
+		default:
+			t.Errorf("%s: unexpected resolver %T", spec, r)
+		}
+	}
+	if r, err := NewResolver(""); err != nil || r != DefaultResolver {
+		t.Error("Empty resolver should be the default resolver")
+	}
+	for _, spec := range []string{"dns.example", "1.1.1.1:0", "1.1.1.1:dns", "https://", "https://user@dns.example/", "http://1.1.1.1/dns-query",
+		"https://dns.example/dns-query", "https://dns.example/dns-query dns2.example", "https://1.1.1.1/dns-query 1.0.0.1 9.9.9.9"} {
+		if _, err := NewResolver(spec); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
+
+// answerQuery is a tiny authoritative server for the names used in tests.
+func answerQuery(t *testing.T, packed []byte) []byte {
+	var query dnsmessage.Message
+	if err := query.Unpack(packed); err != nil || len(query.Questions) != 1 {
+		t.Errorf("Invalid query: %v", err)
+		return nil
+	}
+	q := query.Questions[0]
+	response := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: query.Header.ID, Response: true, RecursionAvailable: true},
+		Questions: query.Questions,
+	}
+	header := func(name string, qtype dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: qtype, Class: dnsmessage.ClassINET, TTL: 60}
+	}
+	switch q.Name.String() {
+	case "vpn.example.":
+		if q.Type == dnsmessage.TypeA {
+			response.Answers = append(response.Answers, dnsmessage.Resource{Header: header("vpn.example.", q.Type), Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}})
+		} else {
+			// The address comes before the CNAME that leads to it, and an
+			// unrelated record is thrown in, which must be ignored.
+			response.Answers = append(response.Answers,
+				dnsmessage.Resource{Header: header("v6.example.", q.Type), Body: &dnsmessage.AAAAResource{AAAA: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}}},
+				dnsmessage.Resource{Header: header("VPN.example.", dnsmessage.TypeCNAME), Body: &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("v6.example.")}},
+				dnsmessage.Resource{Header: header("other.example.", q.Type), Body: &dnsmessage.AAAAResource{AAAA: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 2}}},
+			)
+		}
+	case "v4only.example.":
+		if q.Type == dnsmessage.TypeA {
+			response.Answers = append(response.Answers, dnsmessage.Resource{Header: header("v4only.example.", q.Type), Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 2}}})
+		}
+	case "broken.example.":
+		response.Header.RCode = dnsmessage.RCodeServerFailure
+	default:
+		response.Header.RCode = dnsmessage.RCodeNameError
+	}
+	packed, err := response.Pack()
+	if err != nil {
+		t.Error(err)
+	}
+	return packed
+}
+
+// withFastRetries counts sleeps instead of sleeping until restore is called.
+func withFastRetries() (sleeps *int, restore func()) {
+	saved := lookupRetryPolicy
+	sleeps = new(int)
+	lookupRetryPolicy = retryPolicy{
+		justBooted: func() bool { return false },
+		online:     func() bool { return true },
+		sleep:      func(context.Context, time.Duration) error { *sleeps++; return nil },
+	}
+	return sleeps, func() { lookupRetryPolicy = saved }
+}
+
+func testResolverLookups(t *testing.T, r Resolver) {
+	addrs, err := r.LookupHost(context.Background(), "vpn.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	equal(t, "[2001:db8::1 192.0.2.1]", ipAddrsString(addrs))
+	addrs, err = r.LookupHost(context.Background(), "v4only.example.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	equal(t, "[192.0.2.2]", ipAddrsString(addrs))
+	_, err = r.LookupHost(context.Background(), "missing.example")
+	if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+}
+
+func ipAddrsString(addrs []net.IPAddr) string {
+	s := make([]string, len(addrs))
+	for i := range addrs {
+		s[i] = addrs[i].String()
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
+
+func TestDoHResolver(t *testing.T) {
+	sleeps, restore := withFastRetries()
+	defer restore()
+	failures := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if failures > 0 {
+			failures--
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+			return
+		}
+		query, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(answerQuery(t, query))
+	}))
+	defer server.Close()
+	r := &dohResolver{url: server.URL + "/dns-query", client: server.Client()}
+	testResolverLookups(t, r)
+	equal(t, 0, *sleeps)
+
+	// Each attempt asks for both AAAA and A records.
+	failures = 4
+	addrs, err := r.LookupHost(context.Background(), "v4only.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	equal(t, "[192.0.2.2]", ipAddrsString(addrs))
+	equal(t, 2, *sleeps)
+
+	*sleeps = 0
+	_, err = r.LookupHost(context.Background(), "broken.example")
+	if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.Temporary() || dnsErr.Name != "broken.example" {
+		t.Errorf("Expected a temporary error, got %v", err)
+	}
+	equal(t, 10, *sleeps)
+}
+
+func TestDoHResolverBootstrap(t *testing.T) {
+	_, restore := withFastRetries()
+	defer restore()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(answerQuery(t, query))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	// The test certificate is for example.com, which must not be looked up.
+	r, err := NewResolver("https://example.com:" + port + "/dns-query 127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doh := r.(*dohResolver)
+	doh.client.Transport.(*http.Transport).TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+	testResolverLookups(t, doh)
+}
+
+func TestServerResolver(t *testing.T) {
+	_, restore := withFastRetries()
+	defer restore()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(answerQuery(t, buf[:n]), addr)
+		}
+	}()
+	r, err := NewResolver(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testResolverLookups(t, r)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var sleeps int
+	booted, online := true, false
+	policy := retryPolicy{
+		justBooted: func() bool { return booted },
+		online:     func() bool { return online },
+		sleep:      func(context.Context, time.Duration) error { sleeps++; return nil },
+	}
+	calls := 0
+	notFoundUntil := func(n int) func(context.Context, string) ([]net.IPAddr, error) {
+		return func(ctx context.Context, name string) ([]net.IPAddr, error) {
+			calls++
+			if calls <= n {
+				return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+			}
+			return []net.IPAddr{{IP: net.IPv4(192, 0, 2, 1)}}, nil
+		}
+	}
+	if _, err := policy.lookup(context.Background(), "vpn.example", notFoundUntil(3)); err != nil {
+		t.Errorf("Lookup failed right after boot: %v", err)
+	}
+	equal(t, 3, sleeps)
+
+	calls, sleeps, online = 0, 0, true
+	if _, err := policy.lookup(context.Background(), "vpn.example", notFoundUntil(3)); err == nil {
+		t.Error("Not found should not be retried once online")
+	}
+	equal(t, 0, sleeps)
+
+	calls, sleeps, booted, online = 0, 0, false, false
+	if _, err := policy.lookup(context.Background(), "vpn.example", notFoundUntil(3)); err == nil {
+		t.Error("Not found should not be retried long after boot")
+	}
+	equal(t, 0, sleeps)
+}
+
+func TestResolverSetting(t *testing.T) {
+	input := strings.Replace(testInput, "ListenPort = 51820", "# Resolver = https://1.1.1.1/dns-query\nListenPort = 51820", 1)
+	c, err := FromWgQuick(input, "test")
+	if !noError(t, err) {
+		return
+	}
+	equal(t, "https://1.1.1.1/dns-query", c.Interface.Resolver)
+	c.Interface.Resolver = "9.9.9.9"
+	if !strings.Contains(c.ToWgQuick(), "\n# Resolver = 9.9.9.9\nListenPort") {
+		t.Errorf("Resolver not updated in place:\n%s", c.ToWgQuick())
+	}
+	c.Interface.Resolver = ""
+	if strings.Contains(c.ToWgQuick(), "Resolver") {
+		t.Errorf("Resolver not removed:\n%s", c.ToWgQuick())
+	}
+	c.Document = nil
+	c.Interface.Resolver = "9.9.9.9"
+	c, err = FromWgQuick(c.ToWgQuick(), "test")
+	if noError(t, err) {
+		equal(t, "9.9.9.9", c.Interface.Resolver)
+	}
+
+	_, diagnostics := FromWgQuickWithDiagnostics(strings.Replace(input, "https://1.1.1.1", "https://", 1), "test")
+	if len(diagnostics) != 1 || diagnostics[0].Code != DiagnosticInvalidValue || diagnostics[0].Line != 6 {
+		t.Errorf("Expected an invalid value diagnostic on line 6, got %v", diagnostics)
+	}
+}
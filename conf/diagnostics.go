@@ -0,0 +1,244 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"fmt"
+	"net"
+	"unicode/utf8"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityInfo
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+type DiagnosticCode int
+
+const (
+	DiagnosticInvalidName DiagnosticCode = iota
+	DiagnosticOutsideSection
+	DiagnosticUnknownSection
+	DiagnosticMissingEquals
+	DiagnosticMissingValue
+	DiagnosticUnknownKey
+	DiagnosticInvalidValue
+	DiagnosticMissingPrivateKey
+	DiagnosticMissingPublicKey
+	DiagnosticDuplicateKey
+	DiagnosticDuplicatePeer
+	DiagnosticOverlappingAllowedIPs
+	DiagnosticKeepaliveWithoutEndpoint
+	DiagnosticUnreachableDNS
+	DiagnosticPeerIsSelf
+	DiagnosticNoAllowedIPs
+	DiagnosticAddressConflictsWithRoute
+	DiagnosticMTUTooSmallForIPv6
+	DiagnosticDNSOutsideAllowedIPs
+)
+
+var diagnosticCodeNames = [...]string{
+	DiagnosticInvalidName:               "invalid-name",
+	DiagnosticOutsideSection:            "outside-section",
+	DiagnosticUnknownSection:            "unknown-section",
+	DiagnosticMissingEquals:             "missing-equals",
+	DiagnosticMissingValue:              "missing-value",
+	DiagnosticUnknownKey:                "unknown-key",
+	DiagnosticInvalidValue:              "invalid-value",
+	DiagnosticMissingPrivateKey:         "missing-private-key",
+	DiagnosticMissingPublicKey:          "missing-public-key",
+	DiagnosticDuplicateKey:              "duplicate-key",
+	DiagnosticDuplicatePeer:             "duplicate-peer",
+	DiagnosticOverlappingAllowedIPs:     "overlapping-allowed-ips",
+	DiagnosticKeepaliveWithoutEndpoint:  "keepalive-without-endpoint",
+	DiagnosticUnreachableDNS:            "unreachable-dns",
+	DiagnosticPeerIsSelf:                "peer-is-self",
+	DiagnosticNoAllowedIPs:              "no-allowed-ips",
+	DiagnosticAddressConflictsWithRoute: "address-conflicts-with-route",
+	DiagnosticMTUTooSmallForIPv6:        "mtu-too-small-for-ipv6",
+	DiagnosticDNSOutsideAllowedIPs:      "dns-outside-allowed-ips",
+}
+
+// String returns a stable machine-readable identifier for the code.
+func (c DiagnosticCode) String() string {
+	if c >= 0 && int(c) < len(diagnosticCodeNames) {
+		return diagnosticCodeNames[c]
+	}
+	return fmt.Sprintf("DiagnosticCode(%d)", int(c))
+}
+
+// SourceSpan locates a diagnostic in the original text. Line and columns are
+// 1-based and counted in characters; EndColumn is exclusive. Section is the
+// 0-based index of the enclosing section in order of appearance. A zero Line
+// or a negative Section means the diagnostic isn't tied to that location.
+type SourceSpan struct {
+	Line      int
+	Column    int
+	EndColumn int
+	Section   int
+}
+
+type Diagnostic struct {
+	SourceSpan
+	Severity Severity
+	Code     DiagnosticCode
+	Err      error
+}
+
+func (d *Diagnostic) Error() string {
+	if d.Line == 0 {
+		return d.Err.Error()
+	}
+	return fmt.Sprintf("Line %d, column %d: %v", d.Line, d.Column, d.Err)
+}
+
+// HasErrors reports whether any of the diagnostics is an error rather than a warning.
+func HasErrors(diagnostics []Diagnostic) bool {
+	for i := range diagnostics {
+		if diagnostics[i].Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+type interfaceSpans struct {
+	header SourceSpan
+	dns    []SourceSpan
+}
+
+type peerSpans struct {
+	header     SourceSpan
+	publicKey  SourceSpan
+	keepalive  SourceSpan
+	allowedIPs []SourceSpan
+}
+
+type wgQuickParser struct {
+	collect     bool
+	diagnostics []Diagnostic
+	rawLine     string
+	line        int
+	section     int
+	iface       interfaceSpans
+	peers       []peerSpans
+}
+
+// span converts a byte range of the current raw line into a SourceSpan.
+func (p *wgQuickParser) span(start, end int) SourceSpan {
+	return SourceSpan{
+		Line:      p.line,
+		Column:    utf8.RuneCountInString(p.rawLine[:start]) + 1,
+		EndColumn: utf8.RuneCountInString(p.rawLine[:end]) + 1,
+		Section:   p.section,
+	}
+}
+
+func (p *wgQuickParser) report(severity Severity, code DiagnosticCode, err error, span SourceSpan) {
+	p.diagnostics = append(p.diagnostics, Diagnostic{span, severity, code, err})
+}
+
+// fail records an error and reports whether parsing should stop.
+func (p *wgQuickParser) fail(code DiagnosticCode, err error, span SourceSpan) bool {
+	p.report(SeverityError, code, err, span)
+	return !p.collect
+}
+
+func (p *wgQuickParser) warn(code DiagnosticCode, err error, span SourceSpan) {
+	p.report(SeverityWarning, code, err, span)
+}
+
+func (p *wgQuickParser) firstError() error {
+	for i := range p.diagnostics {
+		if p.diagnostics[i].Severity == SeverityError {
+			return p.diagnostics[i].Err
+		}
+	}
+	return nil
+}
+
+func (r *IPCidr) overlaps(other *IPCidr) bool {
+	if r.Bits() != other.Bits() {
+		return false
+	}
+	cidr := r.Cidr
+	if other.Cidr < cidr {
+		cidr = other.Cidr
+	}
+	mask := net.CIDRMask(int(cidr), int(r.Bits()))
+	return r.IP.Mask(mask).Equal(other.IP.Mask(mask))
+}
+
+// checkSemantics looks for problems that are only visible once the whole
+// configuration has been read.
+func (p *wgQuickParser) checkSemantics(conf *Config) {
+	seenKeys := make(map[Key]int, len(conf.Peers))
+	for i := range conf.Peers {
+		peer := &conf.Peers[i]
+		if peer.PublicKey.IsZero() {
+			continue
+		}
+		if first, ok := seenKeys[peer.PublicKey]; ok {
+			p.report(SeverityError, DiagnosticDuplicatePeer, &ParseError{fmt.Sprintf("Public key already used by peer %d", first+1), peer.PublicKey.String()}, p.peers[i].publicKey)
+		} else {
+			seenKeys[peer.PublicKey] = i
+		}
+	}
+
+	for i := range conf.Peers {
+		for j := 0; j < i; j++ {
+			for k := range conf.Peers[i].AllowedIPs {
+				for l := range conf.Peers[j].AllowedIPs {
+					if conf.Peers[i].AllowedIPs[k].overlaps(&conf.Peers[j].AllowedIPs[l]) {
+						p.warn(DiagnosticOverlappingAllowedIPs, &ParseError{fmt.Sprintf("Allowed IPs overlap with %s of peer %d", conf.Peers[j].AllowedIPs[l].String(), j+1), conf.Peers[i].AllowedIPs[k].String()}, p.peers[i].allowedIPs[k])
+					}
+				}
+			}
+		}
+	}
+
+	for i := range conf.Peers {
+		if conf.Peers[i].PersistentKeepalive > 0 && conf.Peers[i].Endpoint.IsEmpty() {
+			p.warn(DiagnosticKeepaliveWithoutEndpoint, &ParseError{"Persistent keepalive has no effect until the peer has an endpoint", fmt.Sprintf("%d", conf.Peers[i].PersistentKeepalive)}, p.peers[i].keepalive)
+		}
+	}
+
+	var haveIPv4, haveIPv6 bool
+	for _, address := range conf.Interface.Addresses {
+		if address.IP.To4() != nil {
+			haveIPv4 = true
+		} else {
+			haveIPv6 = true
+		}
+	}
+	// Without any address, the interface is either addressed by other means
+	// or missing its Address altogether, neither of which is about DNS.
+	if len(conf.Interface.Addresses) == 0 {
+		return
+	}
+	for i, dns := range conf.Interface.DNS {
+		if dns.To4() != nil && !haveIPv4 {
+			p.warn(DiagnosticUnreachableDNS, &ParseError{"DNS server is IPv4 but the interface has no IPv4 address", dns.String()}, p.iface.dns[i])
+		} else if dns.To4() == nil && !haveIPv6 {
+			p.warn(DiagnosticUnreachableDNS, &ParseError{"DNS server is IPv6 but the interface has no IPv6 address", dns.String()}, p.iface.dns[i])
+		}
+	}
+}
@@ -0,0 +1,23 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"context"
+	"net"
+)
+
+var lookupRetryPolicy = retryPolicy{
+	justBooted: func() bool { return false },
+	online:     func() bool { return true },
+	sleep:      sleepContext,
+}
+
+type systemResolver struct{}
+
+func (systemResolver) LookupHost(ctx context.Context, name string) ([]net.IPAddr, error) {
+	return lookupRetryPolicy.lookup(ctx, name, net.DefaultResolver.LookupIPAddr)
+}
@@ -6,9 +6,9 @@
 package conf
 
 import (
-	"fmt"
-	"log"
+	"context"
 	"net"
+	"strconv"
 	"syscall"
 	"time"
 	"unsafe"
@@ -18,34 +18,51 @@ import (
 
 //sys	internetGetConnectedState(flags *uint32, reserved uint32) (connected bool) = wininet.InternetGetConnectedState
 
-func resolveHostname(name string) (resolvedIPString string, err error) {
-	maxTries := 10
-	systemJustBooted := windows.DurationSinceBoot() <= time.Minute*4
-	if systemJustBooted {
-		maxTries *= 4
-	}
-	for i := 0; i < maxTries; i++ {
-		resolvedIPString, err = resolveHostnameOnce(name)
-		if err == nil {
-			return
+var lookupRetryPolicy = retryPolicy{
+	justBooted: func() bool { return windows.DurationSinceBoot() <= time.Minute*4 },
+	online: func() bool {
+		var state uint32
+		return internetGetConnectedState(&state, 0)
+	},
+	sleep: sleepContext,
+}
+
+type systemResolver struct{}
+
+func (systemResolver) LookupHost(ctx context.Context, name string) ([]net.IPAddr, error) {
+	return lookupRetryPolicy.lookup(ctx, name, func(ctx context.Context, name string) ([]net.IPAddr, error) {
+		// GetAddrInfoW can't be cancelled, so a lookup that outlives ctx is
+		// left to finish on its own.
+		type result struct {
+			addrs []net.IPAddr
+			err   error
 		}
-		if err == windows.WSATRY_AGAIN {
-			log.Printf("Temporary DNS error when resolving %s, sleeping for 4 seconds", name)
-			time.Sleep(time.Second * 4)
-			continue
+		results := make(chan result, 1)
+		go func() {
+			addrs, err := resolveHostnameOnce(name)
+			results <- result{addrs, err}
+		}()
+		var addrs []net.IPAddr
+		var err error
+		select {
+		case r := <-results:
+			addrs, err = r.addrs, r.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
-		var state uint32
-		if err == windows.WSAHOST_NOT_FOUND && systemJustBooted && !internetGetConnectedState(&state, 0) {
-			log.Printf("Host not found when resolving %s, but no Internet connection available, sleeping for 4 seconds", name)
-			time.Sleep(time.Second * 4)
-			continue
+		if err != nil {
+			return nil, &net.DNSError{
+				Err:         err.Error(),
+				Name:        name,
+				IsTemporary: err == windows.WSATRY_AGAIN,
+				IsNotFound:  err == windows.WSAHOST_NOT_FOUND,
+			}
 		}
-		return
-	}
-	return
+		return addrs, nil
+	})
 }
 
-func resolveHostnameOnce(name string) (resolvedIPString string, err error) {
+func resolveHostnameOnce(name string) (addrs []net.IPAddr, err error) {
 	hints := windows.AddrinfoW{
 		Family:   windows.AF_UNSPEC,
 		Socktype: windows.SOCK_DGRAM,
@@ -65,28 +82,24 @@ func resolveHostnameOnce(name string) (resolvedIPString string, err error) {
 		return
 	}
 	defer windows.FreeAddrInfoW(result)
-	ipv6 := ""
 	for ; result != nil; result = result.Next {
 		addr := unsafe.Pointer(result.Addr)
 		switch result.Family {
 		case windows.AF_INET:
 			a := (*syscall.RawSockaddrInet4)(addr).Addr
-			return net.IP{a[0], a[1], a[2], a[3]}.String(), nil
+			addrs = append(addrs, net.IPAddr{IP: net.IP{a[0], a[1], a[2], a[3]}})
 		case windows.AF_INET6:
-			if len(ipv6) != 0 {
-				continue
-			}
 			a := (*syscall.RawSockaddrInet6)(addr).Addr
-			ipv6 = net.IP{a[0], a[1], a[2], a[3], a[4], a[5], a[6], a[7], a[8], a[9], a[10], a[11], a[12], a[13], a[14], a[15]}.String()
+			ipv6 := net.IPAddr{IP: net.IP{a[0], a[1], a[2], a[3], a[4], a[5], a[6], a[7], a[8], a[9], a[10], a[11], a[12], a[13], a[14], a[15]}}
 			scope := uint32((*syscall.RawSockaddrInet6)(addr).Scope_id)
 			if scope != 0 {
-				ipv6 += fmt.Sprintf("%%%d", scope)
+				ipv6.Zone = strconv.FormatUint(uint64(scope), 10)
 			}
+			addrs = append(addrs, ipv6)
 		}
 	}
-	if len(ipv6) != 0 {
-		return ipv6, nil
+	if len(addrs) == 0 {
+		err = windows.WSAHOST_NOT_FOUND
 	}
-	err = windows.WSAHOST_NOT_FOUND
 	return
 }
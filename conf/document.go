@@ -0,0 +1,462 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"strings"
+)
+
+// Document is a lossless syntax tree of a configuration in wg-quick format.
+// It keeps comments, blank lines, key casing, ordering, unknown sections and
+// line endings, so that String returns exactly the text that was parsed.
+type Document struct {
+	lines           []docLine
+	sections        []docSection
+	trailingNewline bool
+}
+
+type docLineKind int
+
+const (
+	docLineBlank docLineKind = iota
+	docLineSection
+	docLineKeyValue
+	docLineAnnotation
+	docLineInvalid
+)
+
+// annotationKeys are the keys of "# Key = Value" annotations that hold
+// settings of their own, rather than free-form metadata.
+var annotationKeys = map[string]bool{"name": true, "resolver": true, "excludedips": true}
+
+// MetadataPrefix starts the key of every "# Key = Value" annotation that
+// holds free-form peer metadata, as in "# Meta.Owner = alice", so that
+// ordinary comments that happen to contain an equals sign, or lines that are
+// merely commented out, aren't mistaken for metadata.
+const MetadataPrefix = "Meta."
+
+// isAnnotationKey reports whether key may be used in a "# Key = Value"
+// annotation.
+func isAnnotationKey(key string) bool {
+	if annotationKeys[strings.ToLower(key)] {
+		return true
+	}
+	return len(key) > len(MetadataPrefix) && strings.EqualFold(key[:len(MetadataPrefix)], MetadataPrefix) && isMetadataKey(key[len(MetadataPrefix):])
+}
+
+// isMetadataKey reports whether key may be used as a metadata key, which is
+// written after MetadataPrefix.
+func isMetadataKey(key string) bool {
+	if len(key) == 0 {
+		return false
+	}
+	for i, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && (c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.')) {
+			return false
+		}
+	}
+	return true
+}
+
+type docLine struct {
+	raw      string
+	kind     docLineKind
+	content  string
+	lead     int
+	key      string
+	valStart int
+	valEnd   int
+	section  int
+}
+
+// docSection is a section of a document, which starts with the comments and
+// annotations directly above its header, if a blank line or the start of the
+// document sets them apart from what comes before, and ends where the next
+// section starts.
+type docSection struct {
+	name   string
+	start  int
+	header int
+	end    int
+}
+
+func ParseDocument(s string) *Document {
+	d := &Document{}
+	rawLines := strings.Split(s, "\n")
+	if len(rawLines) > 1 && rawLines[len(rawLines)-1] == "" {
+		d.trailingNewline = true
+		rawLines = rawLines[:len(rawLines)-1]
+	}
+	d.lines = make([]docLine, len(rawLines))
+	section := -1
+	for i, raw := range rawLines {
+		l := &d.lines[i]
+		l.raw = raw
+		content := raw
+		pound := strings.IndexByte(content, '#')
+		if pound >= 0 {
+			content = content[:pound]
+		}
+		l.content = strings.TrimSpace(content)
+		l.lead = strings.Index(raw, l.content)
+		switch {
+		case len(l.content) == 0:
+			l.kind = docLineBlank
+			if pound < 0 {
+				break
+			}
+			comment := raw[pound+1:]
+			equals := strings.IndexByte(comment, '=')
+			if equals < 0 || !isAnnotationKey(strings.TrimSpace(comment[:equals])) {
+				break
+			}
+			l.kind = docLineAnnotation
+			l.key = strings.ToLower(strings.TrimSpace(comment[:equals]))
+			val := strings.TrimSpace(comment[equals+1:])
+			l.valStart = pound + 1 + equals + 1
+			if len(val) > 0 {
+				l.valStart += strings.Index(comment[equals+1:], val)
+			}
+			l.valEnd = l.valStart + len(val)
+		case l.content[0] == '[' && l.content[len(l.content)-1] == ']':
+			l.kind = docLineSection
+			l.key = strings.ToLower(l.content[1 : len(l.content)-1])
+			if section >= 0 {
+				d.sections[section].end = i
+			}
+			section = len(d.sections)
+			d.sections = append(d.sections, docSection{name: l.key, header: i})
+		default:
+			equals := strings.IndexByte(l.content, '=')
+			if equals < 0 {
+				l.kind = docLineInvalid
+				break
+			}
+			l.kind = docLineKeyValue
+			l.key = strings.ToLower(strings.TrimSpace(l.content[:equals]))
+			val := strings.TrimSpace(l.content[equals+1:])
+			l.valStart = l.lead + equals + 1
+			if len(val) > 0 {
+				l.valStart += strings.Index(l.content[equals+1:], val)
+			}
+			l.valEnd = l.valStart + len(val)
+		}
+		l.section = section
+	}
+	if section >= 0 {
+		d.sections[section].end = len(d.lines)
+	}
+	for s := range d.sections {
+		section := &d.sections[s]
+		start := section.header
+		for start > 0 && d.lines[start-1].isComment() {
+			start--
+		}
+		if start == section.header || start > 0 && len(strings.TrimSpace(d.lines[start-1].raw)) > 0 {
+			start = section.header
+		}
+		section.start = start
+		if s > 0 {
+			d.sections[s-1].end = start
+		}
+		for i := start; i < section.header; i++ {
+			d.lines[i].section = s
+		}
+	}
+	return d
+}
+
+func (l *docLine) isComment() bool {
+	return l.kind == docLineAnnotation || l.kind == docLineBlank && len(strings.TrimSpace(l.raw)) > 0
+}
+
+func (d *Document) String() string {
+	var output strings.Builder
+	for i := range d.lines {
+		if i > 0 {
+			output.WriteByte('\n')
+		}
+		output.WriteString(d.lines[i].raw)
+	}
+	if d.trailingNewline {
+		output.WriteByte('\n')
+	}
+	return output.String()
+}
+
+func (d *Document) GobEncode() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Document) GobDecode(text []byte) error {
+	*d = *ParseDocument(string(text))
+	return nil
+}
+
+// Config builds a configuration from the document, failing on the first
+// error in the same way as FromWgQuick.
+func (d *Document) Config(name string) (*Config, error) {
+	p := wgQuickParser{}
+	c := p.parseDocument(d, name)
+	if err := p.firstError(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (d *Document) lineEnding() string {
+	if len(d.lines) > 0 && strings.HasSuffix(d.lines[0].raw, "\r") {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+type docEdit struct {
+	changed bool
+	lines   []string
+	after   []string
+}
+
+type docEditor struct {
+	doc   *Document
+	edits []docEdit
+}
+
+func (e *docEditor) setValue(i int, value string) {
+	l := &e.doc.lines[i]
+	prefix := l.raw[:l.valStart]
+	if strings.HasSuffix(prefix, "=") {
+		prefix += " "
+	}
+	e.edits[i] = docEdit{changed: true, lines: []string{prefix + value + l.raw[l.valEnd:]}, after: e.edits[i].after}
+}
+
+func (e *docEditor) remove(i int) {
+	e.edits[i].changed = true
+	e.edits[i].lines = nil
+}
+
+func (e *docEditor) insertAfter(i int, f *field, value string) {
+	line := f.key + " = " + value
+	if f.annotation {
+		line = "# " + line
+	}
+	if e.doc.lineEnding() == "\r\n" {
+		line += "\r"
+	}
+	e.edits[i].after = append(e.edits[i].after, line)
+}
+
+// updateField rewrites the lines holding a single field so that they hold
+// values, touching as few lines as possible. New lines are inserted after
+// the line at index anchor.
+func (e *docEditor) updateField(lines []int, anchor int, f *field, values []string) {
+	switch {
+	case len(values) == 0:
+		for _, i := range lines {
+			e.remove(i)
+		}
+	case f.kind == fieldSingle:
+		if len(lines) == 0 {
+			e.insertAfter(anchor, f, values[0])
+		} else {
+			e.setValue(lines[len(lines)-1], values[0])
+		}
+	case f.kind == fieldList:
+		value := strings.Join(values, ", ")
+		if len(lines) == 0 {
+			e.insertAfter(anchor, f, value)
+			break
+		}
+		e.setValue(lines[0], value)
+		for _, i := range lines[1:] {
+			e.remove(i)
+		}
+	case f.kind == fieldRepeated:
+		for j, value := range values {
+			if j < len(lines) {
+				e.setValue(lines[j], value)
+			} else if len(lines) > 0 {
+				e.insertAfter(lines[len(lines)-1], f, value)
+			} else {
+				e.insertAfter(anchor, f, value)
+			}
+		}
+		for j := len(values); j < len(lines); j++ {
+			e.remove(lines[j])
+		}
+	}
+}
+
+// redactLines replaces the values of all lines of a secret field, including
+// ones that are overridden by later lines and so not otherwise rewritten.
+func (e *docEditor) redactLines(lines []int, f *field, redact bool) {
+	if !redact || !f.secret {
+		return
+	}
+	for _, i := range lines {
+		l := &e.doc.lines[i]
+		e.setValue(i, f.redacted([]string{l.raw[l.valStart:l.valEnd]}, true)[0])
+	}
+}
+
+// fieldLines returns the indices of the lines for f in the given sections,
+// along with the index after which new lines should be inserted, which is
+// the last line of the same kind in the first section, or its header.
+func (e *docEditor) fieldLines(sections []int, f *field) (lines []int, anchor int) {
+	kind, key := docLineKeyValue, strings.ToLower(f.key)
+	if f.annotation {
+		kind = docLineAnnotation
+	}
+	anchor = -1
+	for _, s := range sections {
+		section := &e.doc.sections[s]
+		if anchor < 0 {
+			anchor = section.header
+			for i := section.header + 1; i < section.end; i++ {
+				if e.doc.lines[i].kind == kind {
+					anchor = i
+				}
+			}
+		}
+		for i := section.start; i < section.end; i++ {
+			if i != section.header && e.doc.lines[i].kind == kind && e.doc.lines[i].key == key {
+				lines = append(lines, i)
+			}
+		}
+	}
+	return
+}
+
+func (e *docEditor) removeSection(s int) {
+	section := &e.doc.sections[s]
+	start := section.start
+	if section.end == len(e.doc.lines) {
+		for start > 0 && len(strings.TrimSpace(e.doc.lines[start-1].raw)) == 0 {
+			start--
+		}
+	}
+	for i := start; i < section.end; i++ {
+		e.remove(i)
+	}
+}
+
+// Update returns a copy of the document that describes c, rewriting only the
+// lines for fields whose values differ from what the document already holds.
+// Peers are matched to sections by public key; sections of peers that are no
+// longer present are removed along with the comments and annotations
+// directly above them, and new peers are appended at the end.
+func (d *Document) Update(c *Config) *Document {
+	return d.update(c, false)
+}
+
+func (d *Document) update(c *Config, redact bool) *Document {
+	p := wgQuickParser{collect: true}
+	old := p.parseDocument(d, c.Name)
+	e := docEditor{doc: d, edits: make([]docEdit, len(d.lines))}
+
+	var interfaceSections, peerSections []int
+	for i := range d.sections {
+		switch d.sections[i].name {
+		case "interface":
+			interfaceSections = append(interfaceSections, i)
+		case "peer":
+			peerSections = append(peerSections, i)
+		}
+	}
+
+	var prepend, appended strings.Builder
+	if len(interfaceSections) == 0 {
+		prepend.WriteString("[Interface]\n")
+		writeFields(&prepend, interfaceFields, func(f *field) []string { return f.redacted(f.interfaceValues(&c.Interface), redact) })
+		prepend.WriteString("\n")
+	} else {
+		for i := range interfaceFields {
+			f := &interfaceFields[i]
+			values := f.redacted(f.interfaceValues(&c.Interface), redact)
+			if equalValues(f.interfaceValues(&old.Interface), values) {
+				continue
+			}
+			lines, anchor := e.fieldLines(interfaceSections, f)
+			e.redactLines(lines, f, redact)
+			e.updateField(lines, anchor, f, values)
+		}
+	}
+
+	used := make([]bool, len(old.Peers))
+	for i := range c.Peers {
+		peer := &c.Peers[i]
+		match := -1
+		for j := range old.Peers {
+			if !used[j] && old.Peers[j].PublicKey == peer.PublicKey {
+				match = j
+				break
+			}
+		}
+		if match < 0 {
+			appended.WriteString("\n[Peer]\n")
+			writePeer(&appended, peer, redact)
+			continue
+		}
+		used[match] = true
+		fields := append(peerAnnotationFields(peer, &old.Peers[match]), peerFields...)
+		for j := range fields {
+			f := &fields[j]
+			values := f.redacted(f.peerValues(peer), redact)
+			if equalValues(f.peerValues(&old.Peers[match]), values) {
+				continue
+			}
+			lines, anchor := e.fieldLines(peerSections[match:match+1], f)
+			e.redactLines(lines, f, redact)
+			e.updateField(lines, anchor, f, values)
+		}
+	}
+	for j := range used {
+		if !used[j] {
+			e.removeSection(peerSections[j])
+		}
+	}
+
+	ending := d.lineEnding()
+	var output strings.Builder
+	output.WriteString(strings.ReplaceAll(prepend.String(), "\n", ending))
+	first := true
+	for i := range d.lines {
+		lines := []string{d.lines[i].raw}
+		if e.edits[i].changed {
+			lines = e.edits[i].lines
+		}
+		for _, line := range append(lines, e.edits[i].after...) {
+			if !first {
+				output.WriteByte('\n')
+			}
+			output.WriteString(line)
+			first = false
+		}
+	}
+	if d.trailingNewline && !first {
+		output.WriteByte('\n')
+	}
+	if appended.Len() > 0 {
+		if !first && !d.trailingNewline {
+			output.WriteString(ending)
+		}
+		output.WriteString(strings.ReplaceAll(appended.String(), "\n", ending))
+	}
+	return ParseDocument(output.String())
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
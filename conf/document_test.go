@@ -0,0 +1,312 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+var documentTestKeys = []string{
+	"yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=",
+	"xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=",
+	"TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=",
+	"gN65BkIKy1eCE9pP1wdc8ROUtkHLF2PfAqYdyYBz6EA=",
+}
+
+func randomCase(r *rand.Rand, s string) string {
+	b := []byte(s)
+	for i := range b {
+		if r.Intn(3) == 0 {
+			b[i] = strings.ToUpper(string(b[i]))[0]
+		} else if r.Intn(3) == 0 {
+			b[i] = strings.ToLower(string(b[i]))[0]
+		}
+	}
+	return string(b)
+}
+
+func randomSpace(r *rand.Rand) string {
+	return []string{"", " ", "  ", "\t", " \t "}[r.Intn(5)]
+}
+
+func randomTrailer(r *rand.Rand) string {
+	switch r.Intn(4) {
+	case 0:
+		return randomSpace(r) + "# owner: ops, ticket NET-" + fmt.Sprint(r.Intn(1000))
+	case 1:
+		return randomSpace(r)
+	}
+	return ""
+}
+
+func randomNoise(r *rand.Rand, lines *[]string) {
+	for n := r.Intn(3); n > 0; n-- {
+		switch r.Intn(3) {
+		case 0:
+			*lines = append(*lines, "")
+		case 1:
+			*lines = append(*lines, randomSpace(r)+"# comment "+fmt.Sprint(r.Int()))
+		case 2:
+			*lines = append(*lines, randomSpace(r))
+		}
+	}
+}
+
+func randomKeyValue(r *rand.Rand, key, value string) string {
+	return randomSpace(r) + randomCase(r, key) + randomSpace(r) + "=" + randomSpace(r) + value + randomTrailer(r)
+}
+
+func randomConfigText(r *rand.Rand) string {
+	var lines []string
+	randomNoise(r, &lines)
+	lines = append(lines, randomSpace(r)+randomCase(r, "[Interface]")+randomTrailer(r))
+	interfaceLines := []string{randomKeyValue(r, "PrivateKey", documentTestKeys[0])}
+	if r.Intn(2) == 0 {
+		interfaceLines = append(interfaceLines, randomKeyValue(r, "ListenPort", fmt.Sprint(r.Intn(65535))))
+	}
+	for n := r.Intn(3); n > 0; n-- {
+		interfaceLines = append(interfaceLines, randomKeyValue(r, "Address", fmt.Sprintf("10.%d.0.1/24,%sfd00::%x/64", r.Intn(256), randomSpace(r), r.Intn(65536))))
+	}
+	if r.Intn(2) == 0 {
+		interfaceLines = append(interfaceLines, randomKeyValue(r, "DNS", "1.1.1.1, 2606:4700:4700::1111"))
+	}
+	if r.Intn(2) == 0 {
+		interfaceLines = append(interfaceLines, randomKeyValue(r, "PostUp", "echo up %i"))
+	}
+	r.Shuffle(len(interfaceLines), func(i, j int) { interfaceLines[i], interfaceLines[j] = interfaceLines[j], interfaceLines[i] })
+	for _, line := range interfaceLines {
+		lines = append(lines, line)
+		randomNoise(r, &lines)
+	}
+	peers := r.Intn(len(documentTestKeys))
+	for i := 1; i <= peers; i++ {
+		lines = append(lines, randomSpace(r)+randomCase(r, "[Peer]")+randomTrailer(r))
+		lines = append(lines, randomKeyValue(r, "PublicKey", documentTestKeys[i]))
+		if r.Intn(2) == 0 {
+			lines = append(lines, randomKeyValue(r, "AllowedIPs", fmt.Sprintf("192.168.%d.0/24", i)))
+		}
+		if r.Intn(2) == 0 {
+			lines = append(lines, randomKeyValue(r, "Endpoint", fmt.Sprintf("demo%d.wireguard.com:%d", i, r.Intn(65535)+1)))
+		}
+		if r.Intn(3) == 0 {
+			lines = append(lines, randomKeyValue(r, "PersistentKeepalive", fmt.Sprint(r.Intn(65535)+1)))
+		}
+		randomNoise(r, &lines)
+	}
+	eol := "\n"
+	if r.Intn(4) == 0 {
+		eol = "\r\n"
+	}
+	text := strings.Join(lines, eol)
+	if r.Intn(3) != 0 {
+		text += eol
+	}
+	return text
+}
+
+func TestDocumentRoundTripProperty(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		text := randomConfigText(r)
+		if got := ParseDocument(text).String(); got != text {
+			t.Fatalf("Document round trip differs:\ninput  %q\noutput %q", text, got)
+		}
+		c, err := FromWgQuick(text, "test")
+		if !noError(t, err) {
+			t.Fatalf("Input %q", text)
+		}
+		if got := c.ToWgQuick(); got != text {
+			t.Fatalf("Config round trip differs:\ninput  %q\noutput %q", text, got)
+		}
+	}
+}
+
+func TestDocumentRoundTripArbitraryText(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	alphabet := []byte("[]=#, \t\r\nabcPeerInterface")
+	for i := 0; i < 2000; i++ {
+		b := make([]byte, r.Intn(200))
+		for j := range b {
+			b[j] = alphabet[r.Intn(len(alphabet))]
+		}
+		if got := ParseDocument(string(b)).String(); got != string(b) {
+			t.Fatalf("Document round trip differs:\ninput  %q\noutput %q", b, got)
+		}
+	}
+}
+
+const documentTestInput = `# Managed by ops
+[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+listenport   =  51820 # fixed for firewall rules
+Address = 10.0.0.1/24
+
+# owner: alice, ticket NET-42, expires 2020-01-01
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 10.0.0.2/32 # alice's laptop
+
+# owner: bob
+[Peer]
+PublicKey = TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=
+AllowedIPs = 10.0.0.3/32
+
+[Unknown]
+Foo = bar
+`
+
+func TestDocumentUpdateRewritesOnlyChangedFields(t *testing.T) {
+	c, err := FromWgQuick(strings.Replace(documentTestInput, "\n[Unknown]\nFoo = bar\n", "", 1), "test")
+	if !noError(t, err) {
+		return
+	}
+	original := c.ToWgQuick()
+	c.Interface.ListenPort = 1234
+	c.Peers[0].AllowedIPs = append(c.Peers[0].AllowedIPs, IPCidr{IP: []byte{10, 0, 1, 0}, Cidr: 24})
+	c.Peers[1].PersistentKeepalive = 25
+	expected := strings.NewReplacer(
+		"listenport   =  51820 #", "listenport   =  1234 #",
+		"AllowedIPs = 10.0.0.2/32 #", "AllowedIPs = 10.0.0.2/32, 10.0.1.0/24 #",
+		"AllowedIPs = 10.0.0.3/32\n", "AllowedIPs = 10.0.0.3/32\nPersistentKeepalive = 25\n",
+	).Replace(original)
+	equal(t, expected, c.ToWgQuick())
+}
+
+func TestDocumentUpdatePeers(t *testing.T) {
+	d := ParseDocument(documentTestInput)
+	c, err := FromWgQuick(documentTestInput[:strings.Index(documentTestInput, "\n[Unknown]")+1], "test")
+	if !noError(t, err) {
+		return
+	}
+	k, _ := NewPrivateKeyFromString(documentTestKeys[3])
+	c.Peers = append(c.Peers[1:], Peer{PublicKey: *k})
+	expected := `# Managed by ops
+[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+listenport   =  51820 # fixed for firewall rules
+Address = 10.0.0.1/24
+
+# owner: bob
+[Peer]
+PublicKey = TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=
+AllowedIPs = 10.0.0.3/32
+
+[Unknown]
+Foo = bar
+
+[Peer]
+PublicKey = gN65BkIKy1eCE9pP1wdc8ROUtkHLF2PfAqYdyYBz6EA=
+`
+	equal(t, expected, d.Update(c).String())
+	equal(t, documentTestInput, d.String())
+}
+
+func TestDocumentUpdateCRLF(t *testing.T) {
+	input := "[Interface]\r\nPrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=\r\n"
+	c, err := FromWgQuick(input, "test")
+	if !noError(t, err) {
+		return
+	}
+	c.Interface.MTU = 1420
+	equal(t, input+"MTU = 1420\r\n", c.ToWgQuick())
+}
+
+func TestDocumentGob(t *testing.T) {
+	c, err := FromWgQuick(testInput, "test")
+	if !noError(t, err) {
+		return
+	}
+	var buf bytes.Buffer
+	if !noError(t, gob.NewEncoder(&buf).Encode(c)) {
+		return
+	}
+	var decoded Config
+	if !noError(t, gob.NewDecoder(&buf).Decode(&decoded)) {
+		return
+	}
+	equal(t, testInput, decoded.ToWgQuick())
+}
+
+func TestPeerAnnotations(t *testing.T) {
+	const input = `[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+
+[Peer]
+# Name = Alice's laptop
+# Meta.Owner = alice@example.com
+#meta.Ticket=NET-42
+# Endpoint = 192.0.2.1:51820
+# Location = rack 4
+# just a comment
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+`
+	c, err := FromWgQuick(input, "test")
+	if !noError(t, err) {
+		return
+	}
+	equal(t, "Alice's laptop", c.Peers[0].Name)
+	equal(t, map[string]string{"Owner": "alice@example.com", "Ticket": "NET-42"}, c.Peers[0].Metadata)
+	equal(t, true, c.Peers[0].Endpoint.IsEmpty())
+
+	c.Peers[0].Name = "Alice's desktop"
+	c.Peers[0].Metadata = map[string]string{"owner": "alice@example.com", "Expires": "2020-01-01"}
+	equal(t, strings.NewReplacer(
+		"# Name = Alice's laptop\n", "# Name = Alice's desktop\n",
+		"#meta.Ticket=NET-42\n", "# Meta.Expires = 2020-01-01\n",
+	).Replace(input), c.ToWgQuick())
+
+	c.Document = nil
+	k, _ := NewPrivateKeyFromString(documentTestKeys[2])
+	c.Peers = append(c.Peers, Peer{PublicKey: *k, Name: "Bob\nEvil = 1"})
+	fresh, err := FromWgQuick(c.ToWgQuick(), "test")
+	if !noError(t, err) {
+		return
+	}
+	equal(t, "Alice's desktop", fresh.Peers[0].Name)
+	equal(t, map[string]string{"Expires": "2020-01-01", "owner": "alice@example.com"}, fresh.Peers[0].Metadata)
+	equal(t, "Bob Evil = 1", fresh.Peers[1].Name)
+}
+
+func TestDocumentRemovePeerAnnotations(t *testing.T) {
+	const input = `[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 10.0.0.2/32
+
+# Name = Bob
+# Meta.Owner = bob@example.com
+[Peer]
+PublicKey = TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=
+AllowedIPs = 10.0.0.3/32
+
+[Peer]
+PublicKey = gN65BkIKy1eCE9pP1wdc8ROUtkHLF2PfAqYdyYBz6EA=
+AllowedIPs = 10.0.0.4/32
+`
+	c, err := FromWgQuick(input, "test")
+	if !noError(t, err) {
+		return
+	}
+	equal(t, "", c.Peers[0].Name)
+	equal(t, "Bob", c.Peers[1].Name)
+	equal(t, map[string]string{"Owner": "bob@example.com"}, c.Peers[1].Metadata)
+
+	c.Peers = append(c.Peers[:1], c.Peers[2])
+	updated := c.ToWgQuick()
+	equal(t, strings.Replace(input, "# Name = Bob\n# Meta.Owner = bob@example.com\n[Peer]\nPublicKey = TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=\nAllowedIPs = 10.0.0.3/32\n\n", "", 1), updated)
+	reparsed, err := FromWgQuick(updated, "test")
+	if noError(t, err) {
+		equal(t, "", reparsed.Peers[0].Name)
+		equal(t, true, reparsed.Peers[0].Metadata == nil)
+	}
+}
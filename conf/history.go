@@ -0,0 +1,82 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"strings"
+	"time"
+)
+
+// MaxRevisions is the number of revisions kept of each tunnel's
+// configuration. Older ones are pruned as new ones are saved.
+const MaxRevisions = 10
+
+// Revision describes one saved version of a tunnel's configuration. Numbers
+// increase with each save of the same tunnel. An empty Author means that the
+// revision was saved by the system, such as for SaveConfig.
+type Revision struct {
+	Number uint64
+	Time   time.Time
+	Author string
+}
+
+// storedRevision is how a revision is kept on disk.
+type storedRevision struct {
+	Time   time.Time `json:"time"`
+	Author string    `json:"author"`
+	Text   string    `json:"text"`
+}
+
+func diffLines(text string) []string {
+	text = strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if len(text) == 0 {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// DiffText compares two configurations line by line, returning every line of
+// the result prefixed by "  " if it is in both, "- " if it is only in oldText
+// and "+ " if it is only in newText.
+func DiffText(oldText, newText string) string {
+	a, b := diffLines(oldText), diffLines(newText)
+
+	// common[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	var output strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			output.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case j == len(b) || i < len(a) && common[i+1][j] >= common[i][j+1]:
+			output.WriteString("- " + a[i] + "\n")
+			i++
+		default:
+			output.WriteString("+ " + b[j] + "\n")
+			j++
+		}
+	}
+	return output.String()
+}
@@ -0,0 +1,115 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDiffText(t *testing.T) {
+	for _, test := range []struct {
+		old, new, diff string
+	}{
+		{"", "", ""},
+		{"a\nb\n", "a\nb\n", "  a\n  b\n"},
+		{"", "a\n", "+ a\n"},
+		{"a\n", "", "- a\n"},
+		{"a\nb\nc\n", "a\nc\n", "  a\n- b\n  c\n"},
+		{"a\nc\n", "a\nb\nc\n", "  a\n+ b\n  c\n"},
+		{"a\nb\nc\n", "a\nx\nc\n", "  a\n- b\n+ x\n  c\n"},
+		{"a\r\nb\r\n", "a\nb", "  a\n  b\n"},
+	} {
+		equal(t, test.diff, DiffText(test.old, test.new))
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	forEachStore(t, testRevisions)
+}
+
+func testRevisions(t *testing.T, store Store) {
+	const name = "golangHistoryTest"
+	defer store.Delete(name)
+	c, err := FromWgQuick(testInput, name)
+	if !noError(t, err) {
+		return
+	}
+	first := c.Interface.PrivateKey
+	for i := 0; i < MaxRevisions+2; i++ {
+		if i > 0 {
+			k, err := NewPrivateKey()
+			if !noError(t, err) {
+				return
+			}
+			c.Interface.PrivateKey = *k
+		}
+		author := "someone"
+		if i == MaxRevisions+1 {
+			author = ""
+		}
+		if !noError(t, store.Save(c, author)) {
+			return
+		}
+	}
+
+	revisions, err := store.Revisions(name)
+	if !noError(t, err) || !lenTest(t, revisions, MaxRevisions) {
+		return
+	}
+	equal(t, uint64(MaxRevisions+2), revisions[0].Number)
+	equal(t, uint64(3), revisions[len(revisions)-1].Number)
+	equal(t, "", revisions[0].Author)
+	equal(t, "someone", revisions[1].Author)
+	for i := 1; i < len(revisions); i++ {
+		if revisions[i].Time.After(revisions[i-1].Time) {
+			t.Errorf("Revision %d is newer than revision %d", revisions[i].Number, revisions[i-1].Number)
+		}
+	}
+
+	latest, err := store.LoadRevision(name, revisions[0].Number)
+	if noError(t, err) {
+		equal(t, c.Interface.PrivateKey, latest.Interface.PrivateKey)
+	}
+	if _, err = store.LoadRevision(name, 1); !os.IsNotExist(err) {
+		t.Errorf("Pruned revision should not exist, but loading it gave %v", err)
+	}
+
+	// Rolling back is saving an older revision again.
+	older, err := store.LoadRevision(name, 3)
+	if !noError(t, err) || !noError(t, store.Save(older, "someone")) {
+		return
+	}
+	loaded, err := store.Load(name)
+	if noError(t, err) {
+		equal(t, older.Interface.PrivateKey, loaded.Interface.PrivateKey)
+		if loaded.Interface.PrivateKey == first {
+			t.Error("Revision 3 should not have the key of revision 1")
+		}
+	}
+	revisions, err = store.Revisions(name)
+	if noError(t, err) && lenTest(t, revisions, MaxRevisions) {
+		equal(t, uint64(MaxRevisions+3), revisions[0].Number)
+	}
+
+	if !noError(t, store.PurgeRevisions(name)) {
+		return
+	}
+	revisions, err = store.Revisions(name)
+	if noError(t, err) && lenTest(t, revisions, 1) {
+		equal(t, uint64(MaxRevisions+3), revisions[0].Number)
+	}
+	if _, err = store.LoadRevision(name, 3); !os.IsNotExist(err) {
+		t.Errorf("Purged revision should not exist, but loading it gave %v", err)
+	}
+	noError(t, store.PurgeRevisions(name))
+
+	noError(t, store.Delete(name))
+	revisions, err = store.Revisions(name)
+	if noError(t, err) {
+		lenTest(t, revisions, 0)
+	}
+}
@@ -0,0 +1,324 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConflictPolicy says what importing does with a tunnel whose name is already
+// taken, ignoring case, by an existing tunnel or one imported before it.
+type ConflictPolicy int
+
+const (
+	ConflictSkip      ConflictPolicy = iota // leave the existing tunnel alone
+	ConflictOverwrite                       // replace the existing tunnel
+	ConflictRename                          // import under the first free name-2, name-3, …
+)
+
+type ImportStatus int
+
+const (
+	ImportSucceeded ImportStatus = iota
+	ImportOverwrote
+	ImportSkippedDuplicate
+	ImportParseError
+	ImportFailed
+	ImportWrongPassphrase
+)
+
+// ImportFile is a file to import, with the contents read by the caller. Files
+// ending in .conf hold one configuration, .json files hold one or several,
+// and .zip files hold any number of both. Bundles are zip files encrypted
+// with Passphrase. Images hold one configuration as a QR code. If Only is
+// set, just the configurations with those results, matched by their source
+// and name, are imported from the file.
+type ImportFile struct {
+	Path       string
+	Contents   []byte
+	Passphrase string
+	Only       []ImportResult
+}
+
+// ImportResult tells what happened to one configuration found while
+// importing, or to a file in which none could be found. Source is the file,
+// followed by the entry for those inside of a zip file. Name is the name the
+// tunnel was imported as, or would have been. Line and Column locate a parse
+// error, when known.
+type ImportResult struct {
+	Source string
+	Name   string
+	Status ImportStatus
+	Error  string
+	Line   int
+	Column int
+}
+
+func (r *ImportResult) String() string {
+	name := r.Name
+	if len(name) == 0 {
+		name = r.Source
+	}
+	switch r.Status {
+	case ImportSucceeded:
+		return fmt.Sprintf("%s: imported", name)
+	case ImportOverwrote:
+		return fmt.Sprintf("%s: replaced existing tunnel", name)
+	case ImportSkippedDuplicate:
+		return fmt.Sprintf("%s: another tunnel already exists with this name", name)
+	case ImportWrongPassphrase:
+		return fmt.Sprintf("%s: %s", name, ErrBundlePassphrase.Error())
+	}
+	if r.Line > 0 {
+		return fmt.Sprintf("%s: line %d, column %d: %s", name, r.Line, r.Column, r.Error)
+	}
+	return fmt.Sprintf("%s: %s", name, r.Error)
+}
+
+// isFrom tells whether the result is of a configuration found in file.
+func (r *ImportResult) isFrom(file *ImportFile) bool {
+	return r.Source == file.Path || strings.HasPrefix(r.Source, file.Path+"/")
+}
+
+// SkippedDuplicates returns those of files from which configurations were
+// skipped for having the name of an existing tunnel, limited to those
+// configurations, so that they can be imported again with another policy.
+func SkippedDuplicates(files []ImportFile, results []ImportResult) []ImportFile {
+	var skipped []ImportFile
+	for i := range files {
+		file := files[i]
+		file.Only = nil
+		for j := range results {
+			if results[j].Status == ImportSkippedDuplicate && results[j].isFrom(&files[i]) {
+				file.Only = append(file.Only, results[j])
+			}
+		}
+		if len(file.Only) > 0 {
+			skipped = append(skipped, file)
+		}
+	}
+	return skipped
+}
+
+// wanted tells whether the configuration of name found at source is to be
+// imported from file.
+func (file *ImportFile) wanted(source, name string) bool {
+	if len(file.Only) == 0 {
+		return true
+	}
+	for i := range file.Only {
+		if file.Only[i].Source == source && file.Only[i].Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+type importCandidate struct {
+	result int // index into the results
+	text   string
+	parsed *Config
+}
+
+func nameFromFileName(fileName string) string {
+	base := filepath.Base(strings.ReplaceAll(fileName, "\\", "/"))
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+func readImportFile(file *ImportFile, add func(source, name, text string, parsed *Config, err error)) {
+	ext := strings.ToLower(filepath.Ext(file.Path))
+	switch {
+	case ext == ".conf":
+		add(file.Path, nameFromFileName(file.Path), string(file.Contents), nil, nil)
+	case isQRCodeImage(ext):
+		text, err := readQRCodeImage(file.Contents)
+		add(file.Path, nameFromFileName(file.Path), text, nil, err)
+	case ext == ".json":
+		configs, err := ConfigsFromJSON(file.Contents)
+		if err != nil {
+			add(file.Path, "", "", nil, err)
+			return
+		}
+		for _, config := range configs {
+			add(file.Path, config.Name, "", config, nil)
+		}
+	case ext == ".zip":
+		readImportZip(file.Path, file.Contents, add)
+	case ext == BundleFileExtension:
+		zipFile, err := DecryptBundle(file.Contents, file.Passphrase)
+		if err != nil {
+			add(file.Path, "", "", nil, err)
+			return
+		}
+		readImportZip(file.Path, zipFile, add)
+	default:
+		add(file.Path, "", "", nil, errors.New("Unsupported file type"))
+	}
+}
+
+// importMaxFileSize and importMaxZipSize bound how much is decompressed from
+// each file in a zip archive and from the whole archive, so that a small
+// archive can't expand to fill memory. Archives going over either are
+// rejected as a whole.
+const (
+	importMaxFileSize = 1024 * 1024      /* 1 MiB */
+	importMaxZipSize  = 16 * 1024 * 1024 /* 16 MiB */
+)
+
+func readImportZip(path string, contents []byte, add func(source, name, text string, parsed *Config, err error)) {
+	r, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+	if err != nil {
+		add(path, "", "", nil, err)
+		return
+	}
+	var files []ImportFile
+	total := 0
+	for _, f := range r.File {
+		source := path + "/" + f.Name
+		ext := strings.ToLower(filepath.Ext(f.Name))
+		if ext != ".conf" && ext != ".json" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			add(source, "", "", nil, err)
+			continue
+		}
+		contents, err := ioutil.ReadAll(io.LimitReader(rc, importMaxFileSize+1))
+		rc.Close()
+		if err != nil {
+			add(source, "", "", nil, err)
+			continue
+		}
+		if len(contents) > importMaxFileSize {
+			add(source, "", "", nil, fmt.Errorf("File is larger than %d bytes, so the archive was not imported", importMaxFileSize))
+			return
+		}
+		total += len(contents)
+		if total > importMaxZipSize {
+			add(path, "", "", nil, fmt.Errorf("Archive holds more than %d bytes of configurations, so it was not imported", importMaxZipSize))
+			return
+		}
+		files = append(files, ImportFile{Path: source, Contents: contents})
+	}
+	for i := range files {
+		readImportFile(&files[i], add)
+	}
+}
+
+// parseImported parses the text of a .conf file, in whichever encoding it is
+// in, returning the first error with its location if it can't be parsed.
+func parseImported(text string, result *ImportResult) *Config {
+	config, err := FromWgQuickWithUnknownEncoding(text, result.Name)
+	if err == nil {
+		return config
+	}
+	result.Status, result.Error = ImportParseError, err.Error()
+	_, diagnostics := FromWgQuickWithDiagnostics(text, result.Name)
+	for i := range diagnostics {
+		if diagnostics[i].Severity == SeverityError {
+			result.Error = diagnostics[i].Err.Error()
+			result.Line, result.Column = diagnostics[i].Line, diagnostics[i].Column
+			break
+		}
+	}
+	return nil
+}
+
+// suffixedName appends "-n" to name, shortening it if needed so that the
+// result is no longer than names may be.
+func suffixedName(name string, n int) string {
+	suffix := "-" + strconv.Itoa(n)
+	const maxLength = 32
+	if len(name)+len(suffix) > maxLength {
+		name = name[:maxLength-len(suffix)]
+	}
+	return name + suffix
+}
+
+// Import saves every configuration found in files to store, settling name
+// conflicts according to policy. There is one result for each configuration,
+// and one for each file or zip entry that could not be read. Tunnels are saved
+// in reverse order of name, so that the one listed first is added last.
+func Import(store Store, files []ImportFile, policy ConflictPolicy, author string) ([]ImportResult, error) {
+	existingNames, err := store.ListConfigNames()
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[string]string, len(existingNames))
+	for _, name := range existingNames {
+		taken[strings.ToLower(name)] = name
+	}
+
+	var results []ImportResult
+	var candidates []importCandidate
+	for i := range files {
+		file := &files[i]
+		readImportFile(file, func(source, name, text string, parsed *Config, err error) {
+			if !file.wanted(source, name) {
+				return
+			}
+			result := ImportResult{Source: source, Name: name}
+			if err == ErrBundlePassphrase {
+				result.Status, result.Error = ImportWrongPassphrase, err.Error()
+			} else if err != nil {
+				result.Status, result.Error = ImportParseError, err.Error()
+			} else {
+				candidates = append(candidates, importCandidate{len(results), text, parsed})
+			}
+			results = append(results, result)
+		})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return TunnelNameIsLess(results[candidates[j].result].Name, results[candidates[i].result].Name)
+	})
+
+	for _, candidate := range candidates {
+		result := &results[candidate.result]
+		config := candidate.parsed
+		if config == nil {
+			config = parseImported(candidate.text, result)
+			if config == nil {
+				continue
+			}
+		}
+		if existing, ok := taken[strings.ToLower(config.Name)]; ok {
+			switch policy {
+			case ConflictSkip:
+				result.Status = ImportSkippedDuplicate
+				continue
+			case ConflictOverwrite:
+				result.Status = ImportOverwrote
+				config.Name = existing
+			case ConflictRename:
+				for n := 2; ; n++ {
+					name := suffixedName(config.Name, n)
+					if _, ok := taken[strings.ToLower(name)]; !ok && TunnelNameIsValid(name) {
+						config.Name = name
+						break
+					}
+				}
+			}
+		}
+		result.Name = config.Name
+		err = store.Save(config, author)
+		if err != nil {
+			result.Status, result.Error = ImportFailed, err.Error()
+			continue
+		}
+		taken[strings.ToLower(config.Name)] = config.Name
+	}
+	return results, nil
+}
@@ -0,0 +1,260 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/windows/qrcode"
+)
+
+func zipFile(t *testing.T, entries map[string]string) []byte {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for name, contents := range entries {
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(contents))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func importResult(results []ImportResult, source string) *ImportResult {
+	for i := range results {
+		if results[i].Source == source {
+			return &results[i]
+		}
+	}
+	return nil
+}
+
+func TestImportFiles(t *testing.T) {
+	store := NewMemoryStore()
+	first, err := FromWgQuick(testInput, "first")
+	if !noError(t, err) {
+		return
+	}
+	second := *first
+	second.Name = "second"
+	list, err := ConfigsToJSON([]*Config{first, &second})
+	if !noError(t, err) {
+		return
+	}
+	files := []ImportFile{
+		{Path: `C:\Users\someone\Downloads\plain.conf`, Contents: []byte(testInput)},
+		{Path: "list.json", Contents: list},
+		{Path: "archive.zip", Contents: zipFile(t, map[string]string{
+			"dir/zipped.conf": testInput,
+			"readme.txt":      "not a configuration",
+		})},
+		{Path: "broken.conf", Contents: []byte("[Interface]\nPrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=\nAddress = nonsense\n")},
+		{Path: "notes.txt", Contents: nil},
+	}
+	results, err := Import(store, files, ConflictSkip, "someone")
+	if !noError(t, err) || !lenTest(t, results, 6) {
+		return
+	}
+	for _, source := range []string{`C:\Users\someone\Downloads\plain.conf`, "archive.zip/dir/zipped.conf"} {
+		if result := importResult(results, source); result == nil || result.Status != ImportSucceeded {
+			t.Errorf("%s should have been imported: %+v", source, result)
+		}
+	}
+	equal(t, "plain", importResult(results, `C:\Users\someone\Downloads\plain.conf`).Name)
+	equal(t, "zipped", importResult(results, "archive.zip/dir/zipped.conf").Name)
+	for _, result := range results {
+		if result.Source == "list.json" && result.Status != ImportSucceeded {
+			t.Errorf("%s from the list should have been imported: %v", result.Name, result.String())
+		}
+	}
+	if broken := importResult(results, "broken.conf"); broken != nil {
+		equal(t, ImportParseError, broken.Status)
+		equal(t, 3, broken.Line)
+		equal(t, 11, broken.Column)
+		if !strings.Contains(broken.String(), "line 3, column 11") {
+			t.Errorf("Description of parse error should give its position: %s", broken.String())
+		}
+	} else {
+		t.Error("There should be a result for the broken file")
+	}
+	if notes := importResult(results, "notes.txt"); notes == nil || notes.Status != ImportParseError {
+		t.Errorf("Unsupported file should be reported: %+v", notes)
+	}
+
+	names, err := store.ListConfigNames()
+	if noError(t, err) {
+		equal(t, []string{"first", "plain", "second", "zipped"}, names)
+	}
+	revisions, err := store.Revisions("plain")
+	if noError(t, err) && lenTest(t, revisions, 1) {
+		equal(t, "someone", revisions[0].Author)
+	}
+}
+
+func TestImportConflicts(t *testing.T) {
+	const longName = "a-name-that-is-32-characters-xyz"
+	for _, test := range []struct {
+		policy ConflictPolicy
+		status ImportStatus
+		names  []string // imported as, in the order of the files
+		stored []string
+	}{
+		{ConflictSkip, ImportSkippedDuplicate, []string{"existing", longName, "fresh", "fresh"}, []string{"Existing", longName, "fresh"}},
+		{ConflictOverwrite, ImportOverwrote, []string{"Existing", longName, "fresh", "fresh"}, []string{"Existing", longName, "fresh"}},
+		{ConflictRename, ImportSucceeded, []string{"existing-2", "a-name-that-is-32-characters-x-2", "fresh", "fresh-2"}, []string{"Existing", "a-name-that-is-32-characters-x-2", longName, "existing-2", "fresh", "fresh-2"}},
+	} {
+		store := NewMemoryStore()
+		for _, name := range []string{"Existing", longName} {
+			c, err := FromWgQuick(testInput, name)
+			if !noError(t, err) || !noError(t, store.Save(c, "")) {
+				return
+			}
+		}
+		files := []ImportFile{
+			{Path: "existing.conf", Contents: []byte(testInput)},
+			{Path: longName + ".conf", Contents: []byte(testInput)},
+			{Path: "fresh.conf", Contents: []byte(testInput)},
+			{Path: "other/fresh.conf", Contents: []byte(testInput)},
+		}
+		results, err := Import(store, files, test.policy, "")
+		if !noError(t, err) || !lenTest(t, results, len(files)) {
+			continue
+		}
+		for i := range results {
+			equal(t, test.names[i], results[i].Name)
+		}
+		equal(t, test.status, results[0].Status)
+		equal(t, test.status, results[1].Status)
+		// Of the two files with the same name, the first is imported as is.
+		equal(t, ImportSucceeded, results[2].Status)
+		equal(t, test.status, results[3].Status)
+		names, err := store.ListConfigNames()
+		if noError(t, err) {
+			equal(t, test.stored, names)
+		}
+		for _, name := range names {
+			if !TunnelNameIsValid(name) {
+				t.Errorf("Imported name %q is not valid", name)
+			}
+		}
+	}
+}
+
+func TestImportSkippedDuplicates(t *testing.T) {
+	store := NewMemoryStore()
+	existing, err := FromWgQuick(testInput, "Existing")
+	if !noError(t, err) || !noError(t, store.Save(existing, "")) {
+		return
+	}
+	files := []ImportFile{
+		{Path: "existing.conf", Contents: []byte(testInput)},
+		{Path: "fresh.conf", Contents: []byte(testInput)},
+		{Path: "archive.zip", Contents: zipFile(t, map[string]string{
+			"a/fresh.conf": testInput,
+			"new.conf":     testInput,
+		})},
+	}
+	results, err := Import(store, files, ConflictSkip, "")
+	if !noError(t, err) || !lenTest(t, results, 4) {
+		return
+	}
+	skipped := SkippedDuplicates(files, results)
+	if !lenTest(t, skipped, 2) {
+		return
+	}
+	equal(t, "existing.conf", skipped[0].Path)
+	equal(t, "archive.zip", skipped[1].Path)
+	lenTest(t, skipped[1].Only, 1)
+
+	// Only the skipped configurations are imported again.
+	results, err = Import(store, skipped, ConflictRename, "")
+	if !noError(t, err) || !lenTest(t, results, 2) {
+		return
+	}
+	equal(t, "existing-2", importResult(results, "existing.conf").Name)
+	equal(t, "fresh-2", importResult(results, "archive.zip/a/fresh.conf").Name)
+	names, err := store.ListConfigNames()
+	if noError(t, err) {
+		equal(t, []string{"Existing", "existing-2", "fresh", "fresh-2", "new"}, names)
+	}
+}
+
+func TestImportQRCode(t *testing.T) {
+	config, err := FromWgQuick(testInput, "phone")
+	if !noError(t, err) {
+		return
+	}
+	code, err := config.QRCode()
+	if !noError(t, err) {
+		return
+	}
+	var image bytes.Buffer
+	if !noError(t, code.WritePNG(&image, 2)) {
+		return
+	}
+	brokenCode, err := qrcode.Encode([]byte("[Interface]\nPrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=\nAddress = nonsense\n"), qrcode.Low)
+	if !noError(t, err) {
+		return
+	}
+	var brokenImage bytes.Buffer
+	if !noError(t, brokenCode.WritePNG(&brokenImage, 2)) {
+		return
+	}
+
+	store := NewMemoryStore()
+	results, err := Import(store, []ImportFile{
+		{Path: "phone.png", Contents: image.Bytes()},
+		{Path: "broken.png", Contents: brokenImage.Bytes()},
+		{Path: "empty.png", Contents: zipFile(t, nil)},
+	}, ConflictSkip, "")
+	if !noError(t, err) || !lenTest(t, results, 3) {
+		return
+	}
+	equal(t, ImportSucceeded, results[0].Status)
+	equal(t, ImportParseError, results[1].Status)
+	equal(t, 3, results[1].Line)
+	equal(t, 11, results[1].Column)
+	equal(t, ImportParseError, results[2].Status)
+	loaded, err := store.Load("phone")
+	if noError(t, err) {
+		equal(t, config.ToWgQuick(), loaded.ToWgQuick())
+	}
+}
+
+func TestImportZipTooLarge(t *testing.T) {
+	padding := strings.Repeat("#", importMaxFileSize/2)
+	tooMany := make(map[string]string)
+	for i := 0; i <= importMaxZipSize/len(padding); i++ {
+		tooMany[fmt.Sprintf("tunnel-%d.conf", i)] = testInput + padding
+	}
+	store := NewMemoryStore()
+	results, err := Import(store, []ImportFile{
+		{Path: "big.zip", Contents: zipFile(t, map[string]string{
+			"big.conf":  testInput + strings.Repeat("#", importMaxFileSize),
+			"fine.conf": testInput,
+		})},
+		{Path: "many.zip", Contents: zipFile(t, tooMany)},
+	}, ConflictSkip, "")
+	if !noError(t, err) || !lenTest(t, results, 2) {
+		return
+	}
+	equal(t, "big.zip/big.conf", results[0].Source)
+	equal(t, ImportParseError, results[0].Status)
+	equal(t, "many.zip", results[1].Source)
+	equal(t, ImportParseError, results[1].Status)
+	names, err := store.ListConfigNames()
+	if noError(t, err) {
+		lenTest(t, names, 0)
+	}
+}
@@ -0,0 +1,345 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// JSONFormatVersion is the version of the JSON representation written by
+// ToJSON. Documents with any other version are rejected.
+const JSONFormatVersion = 1
+
+type jsonDocument struct {
+	Version int `json:"version"`
+	jsonConfig
+}
+
+type jsonListDocument struct {
+	Version int          `json:"version"`
+	Tunnels []jsonConfig `json:"tunnels"`
+}
+
+type jsonConfig struct {
+	Name      string        `json:"name"`
+	Interface jsonInterface `json:"interface"`
+	Peers     []jsonPeer    `json:"peers,omitempty"`
+}
+
+type jsonInterface struct {
+	PrivateKey string   `json:"private_key"`
+	Addresses  []string `json:"addresses,omitempty"`
+	ListenPort uint16   `json:"listen_port,omitempty"`
+	MTU        uint16   `json:"mtu,omitempty"`
+	DNS        []string `json:"dns,omitempty"`
+	DNSSearch  []string `json:"dns_search,omitempty"`
+	PreUp      []string `json:"pre_up,omitempty"`
+	PostUp     []string `json:"post_up,omitempty"`
+	PreDown    []string `json:"pre_down,omitempty"`
+	PostDown   []string `json:"post_down,omitempty"`
+	Table      string   `json:"table,omitempty"`
+	FwMark     uint32   `json:"fwmark,omitempty"`
+	SaveConfig bool     `json:"save_config,omitempty"`
+	Resolver   string   `json:"resolver,omitempty"`
+}
+
+type jsonPeer struct {
+	Name                string            `json:"name,omitempty"`
+	Metadata            map[string]string `json:"metadata,omitempty"`
+	PublicKey           string            `json:"public_key"`
+	PresharedKey        string            `json:"preshared_key,omitempty"`
+	AllowedIPs          []string          `json:"allowed_ips,omitempty"`
+	ExcludedIPs         []string          `json:"excluded_ips,omitempty"`
+	Endpoint            string            `json:"endpoint,omitempty"`
+	PersistentKeepalive uint16            `json:"persistent_keepalive,omitempty"`
+}
+
+func newJSONConfig(config *Config) *jsonConfig {
+	iface := &config.Interface
+	j := &jsonConfig{
+		Name: config.Name,
+		Interface: jsonInterface{
+			PrivateKey: iface.PrivateKey.String(),
+			Addresses:  cidrStrings(iface.Addresses),
+			ListenPort: iface.ListenPort,
+			MTU:        iface.MTU,
+			DNSSearch:  iface.DNSSearch,
+			PreUp:      iface.PreUp,
+			PostUp:     iface.PostUp,
+			PreDown:    iface.PreDown,
+			PostDown:   iface.PostDown,
+			Table:      iface.Table,
+			FwMark:     iface.FwMark,
+			SaveConfig: iface.SaveConfig,
+			Resolver:   iface.Resolver,
+		},
+	}
+	if len(j.Interface.Addresses) == 0 {
+		j.Interface.Addresses = nil
+	}
+	for _, dns := range iface.DNS {
+		j.Interface.DNS = append(j.Interface.DNS, dns.String())
+	}
+	for i := range config.Peers {
+		peer := &config.Peers[i]
+		jp := jsonPeer{
+			Name:                peer.Name,
+			Metadata:            peer.Metadata,
+			PublicKey:           peer.PublicKey.String(),
+			AllowedIPs:          cidrStrings(peer.AllowedIPs),
+			PersistentKeepalive: peer.PersistentKeepalive,
+		}
+		if len(jp.AllowedIPs) == 0 {
+			jp.AllowedIPs = nil
+		}
+		if len(peer.ExcludedIPs) > 0 {
+			jp.ExcludedIPs = cidrStrings(peer.ExcludedIPs)
+		}
+		if !peer.PresharedKey.IsZero() {
+			jp.PresharedKey = peer.PresharedKey.String()
+		}
+		if !peer.Endpoint.IsEmpty() {
+			jp.Endpoint = peer.Endpoint.String()
+		}
+		j.Peers = append(j.Peers, jp)
+	}
+	return j
+}
+
+func marshalJSONDocument(document interface{}) ([]byte, error) {
+	out, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// ToJSON returns the configuration as a JSON document of the current
+// JSONFormatVersion, with keys in base64 and addresses and endpoints as strings.
+func (config *Config) ToJSON() ([]byte, error) {
+	return marshalJSONDocument(&jsonDocument{JSONFormatVersion, *newJSONConfig(config)})
+}
+
+// ConfigsToJSON returns several configurations as a single JSON document,
+// which ConfigsFromJSON reads back.
+func ConfigsToJSON(configs []*Config) ([]byte, error) {
+	document := &jsonListDocument{Version: JSONFormatVersion, Tunnels: make([]jsonConfig, len(configs))}
+	for i, config := range configs {
+		document.Tunnels[i] = *newJSONConfig(config)
+	}
+	return marshalJSONDocument(document)
+}
+
+// unmarshalJSONDocument strictly decodes data into document, which must
+// have a version field that matches JSONFormatVersion.
+func unmarshalJSONDocument(data []byte, document interface{}, version *int) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(document)
+	if err != nil {
+		return err
+	}
+	if decoder.More() {
+		return &ParseError{"Invalid JSON document", "trailing data"}
+	}
+	if *version != JSONFormatVersion {
+		return &ParseError{"Unsupported JSON format version", strconv.Itoa(*version)}
+	}
+	return nil
+}
+
+func isJSONListDocument(data []byte) bool {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(data, &fields) != nil {
+		return false
+	}
+	_, ok := fields["tunnels"]
+	return ok
+}
+
+// FromJSON parses a JSON document written by ToJSON, validating it with the
+// same rules as FromWgQuick. If name is empty, the name stored in the
+// document is used.
+func FromJSON(data []byte, name string) (*Config, error) {
+	var document jsonDocument
+	err := unmarshalJSONDocument(data, &document, &document.Version)
+	if err != nil {
+		return nil, err
+	}
+	return document.toConfig(name)
+}
+
+// ConfigsFromJSON parses a JSON document written by either ToJSON or
+// ConfigsToJSON, returning the tunnels it holds.
+func ConfigsFromJSON(data []byte) ([]*Config, error) {
+	if !isJSONListDocument(data) {
+		config, err := FromJSON(data, "")
+		if err != nil {
+			return nil, err
+		}
+		return []*Config{config}, nil
+	}
+	var document jsonListDocument
+	err := unmarshalJSONDocument(data, &document, &document.Version)
+	if err != nil {
+		return nil, err
+	}
+	configs := make([]*Config, len(document.Tunnels))
+	for i := range document.Tunnels {
+		configs[i], err = document.Tunnels[i].toConfig("")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return configs, nil
+}
+
+func parseJSONCommands(commands []string) ([]string, error) {
+	for _, command := range commands {
+		if len(strings.TrimSpace(command)) == 0 || strings.ContainsAny(command, "\r\n#") {
+			return nil, &ParseError{"Invalid command", command}
+		}
+	}
+	return commands, nil
+}
+
+func (j *jsonConfig) toConfig(name string) (*Config, error) {
+	if len(name) == 0 {
+		name = j.Name
+	}
+	if !TunnelNameIsValid(name) {
+		return nil, &ParseError{"Tunnel name is not valid", name}
+	}
+	config := &Config{Name: name}
+	iface := &config.Interface
+
+	if len(j.Interface.PrivateKey) == 0 {
+		return nil, &ParseError{"An interface must have a private key", "[none specified]"}
+	}
+	k, err := parseKeyBase64(j.Interface.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	iface.PrivateKey = *k
+	for _, address := range j.Interface.Addresses {
+		a, err := parseIPCidr(address)
+		if err != nil {
+			return nil, err
+		}
+		iface.Addresses = append(iface.Addresses, *a)
+	}
+	iface.ListenPort = j.Interface.ListenPort
+	if j.Interface.MTU != 0 {
+		iface.MTU, err = parseMTU(strconv.Itoa(int(j.Interface.MTU)))
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, address := range j.Interface.DNS {
+		a := net.ParseIP(address)
+		if a == nil {
+			return nil, &ParseError{"Invalid IP address", address}
+		}
+		iface.DNS = append(iface.DNS, a)
+	}
+	for _, domain := range j.Interface.DNSSearch {
+		d, err := parseSearchDomain(domain)
+		if err != nil {
+			return nil, err
+		}
+		iface.DNSSearch = append(iface.DNSSearch, d)
+	}
+	if iface.PreUp, err = parseJSONCommands(j.Interface.PreUp); err != nil {
+		return nil, err
+	}
+	if iface.PostUp, err = parseJSONCommands(j.Interface.PostUp); err != nil {
+		return nil, err
+	}
+	if iface.PreDown, err = parseJSONCommands(j.Interface.PreDown); err != nil {
+		return nil, err
+	}
+	if iface.PostDown, err = parseJSONCommands(j.Interface.PostDown); err != nil {
+		return nil, err
+	}
+	if len(j.Interface.Table) > 0 {
+		iface.Table, err = parseTable(j.Interface.Table)
+		if err != nil {
+			return nil, err
+		}
+	}
+	iface.FwMark = j.Interface.FwMark
+	iface.SaveConfig = j.Interface.SaveConfig
+	if iface.Resolver, err = parseResolver(j.Interface.Resolver); err != nil {
+		return nil, err
+	}
+
+	for i := range j.Peers {
+		jp := &j.Peers[i]
+		var peer Peer
+		if len(jp.PublicKey) == 0 {
+			return nil, &ParseError{"All peers must have public keys", "[none specified]"}
+		}
+		k, err := parseKeyBase64(jp.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		peer.PublicKey = *k
+		if len(jp.PresharedKey) > 0 {
+			k, err = parseKeyBase64(jp.PresharedKey)
+			if err != nil {
+				return nil, err
+			}
+			peer.PresharedKey = *k
+		}
+		for _, address := range jp.AllowedIPs {
+			a, err := parseIPCidr(address)
+			if err != nil {
+				return nil, err
+			}
+			peer.AllowedIPs = append(peer.AllowedIPs, *a)
+		}
+		for _, address := range jp.ExcludedIPs {
+			a, err := parseIPCidr(address)
+			if err != nil {
+				return nil, err
+			}
+			peer.ExcludedIPs = append(peer.ExcludedIPs, *a)
+		}
+		if len(jp.Endpoint) > 0 {
+			e, err := parseEndpoint(jp.Endpoint)
+			if err != nil {
+				return nil, err
+			}
+			peer.Endpoint = *e
+		}
+		peer.PersistentKeepalive = jp.PersistentKeepalive
+		if strings.ContainsAny(jp.Name, "\r\n") {
+			return nil, &ParseError{"Invalid peer name", jp.Name}
+		}
+		peer.Name = jp.Name
+		seen := make(map[string]bool, len(jp.Metadata))
+		for key, value := range jp.Metadata {
+			lower := strings.ToLower(key)
+			if !isMetadataKey(key) || seen[lower] {
+				return nil, &ParseError{"Invalid metadata key", key}
+			}
+			seen[lower] = true
+			if strings.ContainsAny(value, "\r\n") {
+				return nil, &ParseError{fmt.Sprintf("Invalid value for metadata key %s", key), value}
+			}
+		}
+		if len(jp.Metadata) > 0 {
+			peer.Metadata = jp.Metadata
+		}
+		config.Peers = append(config.Peers, peer)
+	}
+	return config, nil
+}
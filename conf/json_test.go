@@ -0,0 +1,130 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+func checkGolden(t *testing.T, name string, actual []byte) {
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := ioutil.WriteFile(path, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := ioutil.ReadFile(path)
+	if !noError(t, err) {
+		return
+	}
+	equal(t, string(expected), string(actual))
+}
+
+func loadGoldenConfig(t *testing.T) *Config {
+	text, err := ioutil.ReadFile(filepath.Join("testdata", "golden.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := FromWgQuick(string(text), "golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestJSONGolden(t *testing.T) {
+	c := loadGoldenConfig(t)
+	out, err := c.ToJSON()
+	if !noError(t, err) {
+		return
+	}
+	checkGolden(t, "golden.json", out)
+
+	c2, err := FromJSON(out, "")
+	if !noError(t, err) {
+		return
+	}
+	text, err := ioutil.ReadFile(filepath.Join("testdata", "golden.conf"))
+	if !noError(t, err) {
+		return
+	}
+	equal(t, string(text), c2.ToWgQuick())
+
+	c3, err := FromJSON(out, "renamed")
+	if noError(t, err) {
+		equal(t, "renamed", c3.Name)
+	}
+}
+
+func TestJSONGoldenList(t *testing.T) {
+	c := loadGoldenConfig(t)
+	other := *c
+	other.Name = "other"
+	other.Peers = nil
+	out, err := ConfigsToJSON([]*Config{c, &other})
+	if !noError(t, err) {
+		return
+	}
+	checkGolden(t, "golden-list.json", out)
+
+	configs, err := ConfigsFromJSON(out)
+	if !noError(t, err) || !lenTest(t, configs, 2) {
+		return
+	}
+	equal(t, "golden", configs[0].Name)
+	equal(t, "other", configs[1].Name)
+	lenTest(t, configs[1].Peers, 0)
+
+	_, err = FromJSON(out, "")
+	if err == nil {
+		t.Error("Expected an error when reading a list of tunnels as a single tunnel")
+	}
+}
+
+func TestJSONValidation(t *testing.T) {
+	const valid = `{"version": 1, "name": "test", "interface": {"private_key": "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="}, "peers": [{"public_key": "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="}]}`
+	_, err := FromJSON([]byte(valid), "")
+	noError(t, err)
+
+	invalid := map[string]string{
+		"version":        `"version": 1`,
+		"name":           `"name": "test"`,
+		"private key":    `"private_key": "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="`,
+		"public key":     `"public_key": "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="`,
+		"unknown field":  `"interface": {`,
+		"address":        `"private_key"`,
+		"command":        `"private_key"`,
+		"metadata":       `"public_key"`,
+		"mtu":            `"private_key"`,
+		"trailing data":  valid,
+		"future version": `"version": 1`,
+	}
+	replacements := map[string]string{
+		"version":        `"version": 0`,
+		"name":           `"name": "not valid!"`,
+		"private key":    `"private_key": ""`,
+		"public key":     `"public_key": "short"`,
+		"unknown field":  `"interface": {"bogus": 1, `,
+		"address":        `"addresses": ["10.0.0.300/24"], "private_key"`,
+		"command":        `"post_up": ["echo one\necho two"], "private_key"`,
+		"metadata":       `"metadata": {"Bad key": "x"}, "public_key"`,
+		"mtu":            `"mtu": 100, "private_key"`,
+		"trailing data":  valid + valid,
+		"future version": `"version": 2`,
+	}
+	for name, old := range invalid {
+		_, err := FromJSON([]byte(strings.Replace(valid, old, replacements[name], 1)), "")
+		if err == nil {
+			t.Errorf("Expected an error for invalid %s", name)
+		}
+	}
+}
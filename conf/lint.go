@@ -0,0 +1,139 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"fmt"
+	"net"
+)
+
+// minIPv6MTU is the smallest MTU that IPv6 allows on a link.
+const minIPv6MTU = 1280
+
+// Finding is a problem found by Lint. Peer is the index of the peer concerned,
+// or -1 when it is about the interface.
+type Finding struct {
+	Severity Severity
+	Code     DiagnosticCode
+	Peer     int
+	Message  string
+}
+
+func (f *Finding) String() string {
+	return fmt.Sprintf("%s: %s [%s]", f.Severity, f.Message, f.Code)
+}
+
+// Lint looks for things in a configuration that are valid but probably not
+// what was meant, such as peers whose allowed IPs overlap or addresses that
+// collide with networks the computer is already attached to.
+func Lint(config *Config) []Finding {
+	routes, _ := localRoutes(config.Name)
+	return lint(config, routes)
+}
+
+// HasErrorFindings reports whether any of the findings is an error.
+func HasErrorFindings(findings []Finding) bool {
+	for i := range findings {
+		if findings[i].Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func peerDescription(config *Config, i int) string {
+	if len(config.Peers[i].Name) > 0 {
+		return fmt.Sprintf("Peer %d (%s)", i+1, config.Peers[i].Name)
+	}
+	return fmt.Sprintf("Peer %d", i+1)
+}
+
+// lint is Lint with the routes of the local networks given, so that it does
+// not depend on the computer it runs on.
+func lint(config *Config, routes []net.IPNet) []Finding {
+	var findings []Finding
+	add := func(severity Severity, code DiagnosticCode, peer int, format string, a ...interface{}) {
+		message := fmt.Sprintf(format, a...)
+		if peer >= 0 {
+			message = peerDescription(config, peer) + ": " + message
+		}
+		findings = append(findings, Finding{severity, code, peer, message})
+	}
+
+	publicKey := config.Interface.PrivateKey.Public()
+	seenKeys := make(map[Key]int, len(config.Peers))
+	for i := range config.Peers {
+		peer := &config.Peers[i]
+		if !config.Interface.PrivateKey.IsZero() && peer.PublicKey == *publicKey {
+			add(SeverityError, DiagnosticPeerIsSelf, i, "Public key is the tunnel's own")
+		}
+		if first, ok := seenKeys[peer.PublicKey]; ok {
+			add(SeverityError, DiagnosticDuplicatePeer, i, "Public key is already used by %s", peerDescription(config, first))
+		} else if !peer.PublicKey.IsZero() {
+			seenKeys[peer.PublicKey] = i
+		}
+		if len(peer.EffectiveAllowedIPs()) == 0 {
+			add(SeverityWarning, DiagnosticNoAllowedIPs, i, "No allowed IPs, so nothing will be sent to this peer")
+		}
+		if peer.PersistentKeepalive > 0 && peer.Endpoint.IsEmpty() {
+			add(SeverityInfo, DiagnosticKeepaliveWithoutEndpoint, i, "Persistent keepalive has no effect until the peer has an endpoint")
+		}
+	}
+
+	// Packets go to the peer with the most specific allowed IPs, and of two
+	// peers with the same ones, only the last gets them.
+	for i := range config.Peers {
+		for j := 0; j < i; j++ {
+			for k := range config.Peers[i].AllowedIPs {
+				for l := range config.Peers[j].AllowedIPs {
+					a, b := &config.Peers[i].AllowedIPs[k], &config.Peers[j].AllowedIPs[l]
+					if !a.overlaps(b) {
+						continue
+					}
+					if a.Cidr == b.Cidr {
+						add(SeverityWarning, DiagnosticOverlappingAllowedIPs, j, "Allowed IPs %s are taken over by %s", b.String(), peerDescription(config, i))
+					} else {
+						add(SeverityWarning, DiagnosticOverlappingAllowedIPs, i, "Allowed IPs %s overlap %s of %s; the more specific one wins", a.String(), b.String(), peerDescription(config, j))
+					}
+				}
+			}
+		}
+	}
+
+	haveIPv6 := false
+	for i := range config.Interface.Addresses {
+		address := &config.Interface.Addresses[i]
+		if address.IP.To4() == nil {
+			haveIPv6 = true
+		}
+		for _, route := range routes {
+			ones, _ := route.Mask.Size()
+			local := IPCidr{IP: route.IP, Cidr: uint8(ones)}
+			if address.overlaps(&local) {
+				add(SeverityWarning, DiagnosticAddressConflictsWithRoute, -1, "Address %s collides with the local network %s", address.String(), local.String())
+			}
+		}
+	}
+	if haveIPv6 && config.Interface.MTU > 0 && config.Interface.MTU < minIPv6MTU {
+		add(SeverityWarning, DiagnosticMTUTooSmallForIPv6, -1, "MTU %d is below the minimum of %d for IPv6, so the IPv6 addresses will not work", config.Interface.MTU, minIPv6MTU)
+	}
+
+	if len(config.Peers) > 0 {
+		for _, dns := range config.Interface.DNS {
+			reachable := false
+			for i := range config.Peers {
+				if IPCidrsContain(config.Peers[i].EffectiveAllowedIPs(), dns) {
+					reachable = true
+				}
+			}
+			if !reachable {
+				add(SeverityWarning, DiagnosticDNSOutsideAllowedIPs, -1, "DNS server %s is outside the allowed IPs of every peer, so it is queried outside of the tunnel", dns.String())
+			}
+		}
+	}
+
+	return findings
+}
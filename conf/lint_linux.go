@@ -0,0 +1,41 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"net"
+)
+
+// localRoutes returns the networks of the addresses of the interfaces other
+// than excludedInterface, standing in for the on-link routes used on Windows.
+func localRoutes(excludedInterface string) ([]net.IPNet, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var routes []net.IPNet
+	for _, iface := range interfaces {
+		if iface.Name == excludedInterface || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ones, bits := ipnet.Mask.Size()
+			if ones == 0 || ones == bits || ipnet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			routes = append(routes, net.IPNet{IP: ipnet.IP.Mask(ipnet.Mask), Mask: ipnet.Mask})
+		}
+	}
+	return routes, nil
+}
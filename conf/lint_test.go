@@ -0,0 +1,96 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func lintCodes(findings []Finding) map[DiagnosticCode]int {
+	codes := make(map[DiagnosticCode]int)
+	for i := range findings {
+		codes[findings[i].Code]++
+	}
+	return codes
+}
+
+func TestLint(t *testing.T) {
+	config, err := FromWgQuick(testInput, "lint")
+	if !noError(t, err) {
+		return
+	}
+	if findings := lint(config, nil); len(findings) != 0 {
+		t.Errorf("Clean configuration has findings: %v", findings)
+	}
+
+	_, lan, _ := net.ParseCIDR("10.192.0.0/16")
+	_, other, _ := net.ParseCIDR("172.16.0.0/12")
+	findings := lint(config, []net.IPNet{*lan, *other})
+	if lenTest(t, findings, 1) {
+		equal(t, DiagnosticAddressConflictsWithRoute, findings[0].Code)
+		equal(t, SeverityWarning, findings[0].Severity)
+		equal(t, -1, findings[0].Peer)
+		if !strings.Contains(findings[0].Message, "10.192.122.1/24") || !strings.Contains(findings[0].Message, "10.192.0.0/16") {
+			t.Errorf("Finding does not name the address and route: %s", findings[0].Message)
+		}
+	}
+
+	overlapping, _ := FromWgQuick(testInput, "lint")
+	overlapping.Peers[2].AllowedIPs = append(overlapping.Peers[2].AllowedIPs, overlapping.Peers[1].AllowedIPs[1])
+	overlapping.Peers[0].AllowedIPs = append(overlapping.Peers[0].AllowedIPs, IPCidr{net.IPv4(10, 192, 122, 0), 24})
+	findings = lint(overlapping, nil)
+	equal(t, 2, lintCodes(findings)[DiagnosticOverlappingAllowedIPs])
+	for i := range findings {
+		if strings.Contains(findings[i].Message, "taken over") {
+			equal(t, 1, findings[i].Peer)
+		}
+	}
+
+	peers, _ := FromWgQuick(testInput, "lint")
+	peers.Peers[0].PublicKey = *peers.Interface.PrivateKey.Public()
+	peers.Peers[2].PublicKey = peers.Peers[1].PublicKey
+	peers.Peers[1].AllowedIPs = nil
+	peers.Peers[1].Endpoint = Endpoint{}
+	peers.Peers[2].AllowedIPs = nil
+	findings = lint(peers, nil)
+	codes := lintCodes(findings)
+	equal(t, 1, codes[DiagnosticPeerIsSelf])
+	equal(t, 1, codes[DiagnosticDuplicatePeer])
+	equal(t, 2, codes[DiagnosticNoAllowedIPs])
+	equal(t, 1, codes[DiagnosticKeepaliveWithoutEndpoint])
+	if !HasErrorFindings(findings) {
+		t.Error("Peer problems should be errors")
+	}
+	if HasErrorFindings(lint(overlapping, nil)) {
+		t.Error("Overlapping allowed IPs should not be errors")
+	}
+
+	v6, _ := FromWgQuick(testInput, "lint")
+	v6.Interface.MTU = 1200
+	findings = lint(v6, nil)
+	lenTest(t, findings, 0)
+	v6.Interface.Addresses = append(v6.Interface.Addresses, IPCidr{net.ParseIP("fd00::1"), 64})
+	findings = lint(v6, nil)
+	if lenTest(t, findings, 1) {
+		equal(t, DiagnosticMTUTooSmallForIPv6, findings[0].Code)
+	}
+	v6.Interface.MTU = 1280
+	lenTest(t, lint(v6, nil), 0)
+
+	dns, _ := FromWgQuick(testInput, "lint")
+	dns.Interface.DNS = []net.IP{net.IPv4(10, 192, 122, 4), net.IPv4(192, 168, 1, 1), net.IPv4(8, 8, 8, 8)}
+	findings = lint(dns, nil)
+	if lenTest(t, findings, 1) {
+		equal(t, DiagnosticDNSOutsideAllowedIPs, findings[0].Code)
+		if !strings.Contains(findings[0].Message, "8.8.8.8") {
+			t.Errorf("Finding does not name the DNS server: %s", findings[0].Message)
+		}
+	}
+	dns.Peers = nil
+	lenTest(t, lint(dns, nil), 0)
+}
@@ -0,0 +1,42 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"net"
+
+	"golang.org/x/sys/windows"
+
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+// localRoutes returns the networks that the computer is directly attached to,
+// leaving out those of the interface called excludedInterface, which is the
+// tunnel's own when it is running.
+func localRoutes(excludedInterface string) ([]net.IPNet, error) {
+	rows, err := winipcfg.GetIPForwardTable2(windows.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+	var routes []net.IPNet
+	for i := range rows {
+		row := &rows[i]
+		nextHop := row.NextHop.IP()
+		if nextHop == nil || !nextHop.IsUnspecified() || row.Loopback {
+			continue
+		}
+		route := row.DestinationPrefix.IPNet()
+		ones, bits := route.Mask.Size()
+		if ones == 0 || ones == bits || route.IP.IsMulticast() || route.IP.IsLinkLocalUnicast() || route.IP.IsLoopback() {
+			continue
+		}
+		if iface, err := row.InterfaceLUID.Interface(); err == nil && iface.Alias() == excludedInterface {
+			continue
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
@@ -0,0 +1,192 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryRevision struct {
+	Revision
+	text string
+}
+
+type memoryStore struct {
+	sync.Mutex
+	configs   map[string]string
+	templates map[string]TunnelTemplate
+	revisions map[string][]memoryRevision // oldest first
+}
+
+// NewMemoryStore returns a store that keeps configurations in memory only,
+// which is useful for tests and for running without a configuration
+// directory.
+func NewMemoryStore() Store {
+	return &memoryStore{configs: make(map[string]string), templates: make(map[string]TunnelTemplate), revisions: make(map[string][]memoryRevision)}
+}
+
+func notFoundError(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+func (s *memoryStore) ListConfigNames() ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+	names := make([]string, 0, len(s.configs))
+	for name := range s.configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *memoryStore) Load(name string) (*Config, error) {
+	s.Lock()
+	text, ok := s.configs[name]
+	s.Unlock()
+	if !ok {
+		return nil, notFoundError("load", name)
+	}
+	return FromWgQuick(text, name)
+}
+
+func (s *memoryStore) Save(config *Config, author string) error {
+	if !TunnelNameIsValid(config.Name) {
+		return errors.New("Tunnel name is not valid")
+	}
+	text := config.ToWgQuick()
+	s.Lock()
+	s.configs[config.Name] = text
+	revisions := s.revisions[config.Name]
+	next := uint64(1)
+	if len(revisions) > 0 {
+		next = revisions[len(revisions)-1].Number + 1
+	}
+	revisions = append(revisions, memoryRevision{Revision{next, time.Now(), author}, text})
+	if len(revisions) > MaxRevisions {
+		revisions = append([]memoryRevision(nil), revisions[len(revisions)-MaxRevisions:]...)
+	}
+	s.revisions[config.Name] = revisions
+	s.Unlock()
+	config.Document = ParseDocument(text)
+	return nil
+}
+
+func (s *memoryStore) Delete(name string) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.configs[name]; !ok {
+		return notFoundError("delete", name)
+	}
+	delete(s.configs, name)
+	delete(s.templates, name)
+	delete(s.revisions, name)
+	return nil
+}
+
+func (s *memoryStore) Rename(oldName, newName string) error {
+	if !TunnelNameIsValid(oldName) || !TunnelNameIsValid(newName) {
+		return errors.New("Tunnel name is not valid")
+	}
+	s.Lock()
+	defer s.Unlock()
+	text, ok := s.configs[oldName]
+	if !ok {
+		return notFoundError("rename", oldName)
+	}
+	for existing := range s.configs {
+		if strings.EqualFold(existing, newName) && existing != oldName {
+			return fmt.Errorf("Another tunnel already exists with the name ‘%s’", existing)
+		}
+	}
+	delete(s.configs, oldName)
+	s.configs[newName] = text
+	if t, ok := s.templates[oldName]; ok {
+		delete(s.templates, oldName)
+		s.templates[newName] = t
+	}
+	if revisions, ok := s.revisions[oldName]; ok {
+		delete(s.revisions, oldName)
+		s.revisions[newName] = revisions
+	}
+	return nil
+}
+
+func (s *memoryStore) SaveTemplate(name string, t *TunnelTemplate) error {
+	if !TunnelNameIsValid(name) {
+		return errors.New("Tunnel name is not valid")
+	}
+	saved := TunnelTemplate{Text: t.Text, Values: make(TemplateValues, len(t.Values))}
+	for key, value := range t.Values {
+		saved.Values[key] = value
+	}
+	s.Lock()
+	s.templates[name] = saved
+	s.Unlock()
+	return nil
+}
+
+func (s *memoryStore) LoadTemplate(name string) (*TunnelTemplate, error) {
+	s.Lock()
+	defer s.Unlock()
+	saved, ok := s.templates[name]
+	if !ok {
+		return nil, notFoundError("load", name)
+	}
+	t := &TunnelTemplate{Text: saved.Text, Values: make(TemplateValues, len(saved.Values))}
+	for key, value := range saved.Values {
+		t.Values[key] = value
+	}
+	return t, nil
+}
+
+func (s *memoryStore) Revisions(name string) ([]Revision, error) {
+	s.Lock()
+	defer s.Unlock()
+	kept := s.revisions[name]
+	revisions := make([]Revision, len(kept))
+	for i := range kept {
+		revisions[len(kept)-1-i] = kept[i].Revision
+	}
+	return revisions, nil
+}
+
+func (s *memoryStore) LoadRevision(name string, number uint64) (*Config, error) {
+	s.Lock()
+	var text string
+	found := false
+	for _, revision := range s.revisions[name] {
+		if revision.Number == number {
+			text, found = revision.text, true
+			break
+		}
+	}
+	s.Unlock()
+	if !found {
+		return nil, notFoundError("load", name+"@"+strconv.FormatUint(number, 10))
+	}
+	return FromWgQuick(text, name)
+}
+
+func (s *memoryStore) PurgeRevisions(name string) error {
+	s.Lock()
+	defer s.Unlock()
+	if revisions := s.revisions[name]; len(revisions) > 1 {
+		s.revisions[name] = append([]memoryRevision(nil), revisions[len(revisions)-1])
+	}
+	return nil
+}
+
+func (s *memoryStore) Path(name string) (string, error) {
+	return "", errors.New("Tunnels kept in memory have no file to be started from")
+}
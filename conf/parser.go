@@ -48,8 +48,9 @@ func parseIPCidr(s string) (ipcidr *IPCidr, err error) {
 	}
 	if len(cidrStr) > 0 {
 		err = &ParseError{"Invalid network prefix length", s}
-		cidr, err = strconv.Atoi(cidrStr)
-		if err != nil || cidr < 0 || cidr > 128 {
+		var atoiErr error
+		cidr, atoiErr = strconv.Atoi(cidrStr)
+		if atoiErr != nil || cidr < 0 || cidr > 128 {
 			return
 		}
 		if cidr > 32 && maybeV4 != nil {
@@ -134,6 +135,84 @@ func parsePersistentKeepalive(s string) (uint16, error) {
 	return uint16(m), nil
 }
 
+func parseTable(s string) (string, error) {
+	switch s {
+	case "auto":
+		return "", nil
+	case TableOff:
+		return TableOff, nil
+	}
+	t, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return "", &ParseError{"Invalid routing table", s}
+	}
+	return strconv.FormatUint(t, 10), nil
+}
+
+func parseFwMark(s string) (uint32, error) {
+	if s == "off" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, &ParseError{"Invalid firewall mark", s}
+	}
+	return uint32(m), nil
+}
+
+func parseSaveConfig(s string) (bool, error) {
+	switch s {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, &ParseError{"SaveConfig must be either true or false", s}
+}
+
+// parseSearchDomain accepts the DNS search domains that wg-quick allows in DNS
+// alongside server addresses. Anything whose last label is numeric or that has
+// a colon is taken to be a mistyped address instead.
+func parseSearchDomain(s string) (string, error) {
+	domain := strings.TrimSuffix(s, ".")
+	if strings.IndexByte(domain, ':') >= 0 {
+		return "", &ParseError{"Invalid IP address", s}
+	}
+	if len(domain) == 0 || len(domain) > 253 {
+		return "", &ParseError{"Invalid DNS search domain", s}
+	}
+	labels := strings.Split(domain, ".")
+	numeric := true
+	for _, c := range labels[len(labels)-1] {
+		if c < '0' || c > '9' {
+			numeric = false
+			break
+		}
+	}
+	if numeric {
+		return "", &ParseError{"Invalid IP address", s}
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", &ParseError{"Invalid DNS search domain", s}
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return "", &ParseError{"Invalid DNS search domain", s}
+			}
+		}
+	}
+	return s, nil
+}
+
+func parseResolver(s string) (string, error) {
+	_, err := NewResolver(s)
+	if err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
 func parseKeyBase64(s string) (*Key, error) {
 	k, err := base64.StdEncoding.DecodeString(s)
 	if err != nil {
@@ -180,11 +259,28 @@ func splitList(s string) ([]string, error) {
 	return out, nil
 }
 
+func parseIPCidrList(s string) ([]IPCidr, error) {
+	items, err := splitList(s)
+	if err != nil {
+		return nil, err
+	}
+	cidrs := make([]IPCidr, 0, len(items))
+	for _, item := range items {
+		cidr, err := parseIPCidr(item)
+		if err != nil {
+			return nil, err
+		}
+		cidrs = append(cidrs, *cidr)
+	}
+	return cidrs, nil
+}
+
 type parserState int
 
 const (
 	inInterfaceSection parserState = iota
 	inPeerSection
+	inUnknownSection
 	notInASection
 )
 
@@ -194,150 +290,339 @@ func (c *Config) maybeAddPeer(p *Peer) {
 	}
 }
 
+func (p *wgQuickParser) maybeAddPeer(c *Config, peer *Peer, spans *peerSpans) {
+	if peer != nil {
+		c.maybeAddPeer(peer)
+		p.peers = append(p.peers, *spans)
+	}
+}
+
+// annotate applies the annotation on line l to the interface or to peer,
+// depending on the section it belongs to, returning whether parsing should
+// stop. Annotations that have no meaning in their section are ignored.
+func (p *wgQuickParser) annotate(conf *Config, peer *Peer, state parserState, l *docLine) bool {
+	value := l.raw[l.valStart:l.valEnd]
+	switch {
+	case state == inInterfaceSection && l.key == "resolver":
+		resolver, err := parseResolver(value)
+		if err != nil {
+			return p.fail(DiagnosticInvalidValue, err, p.span(l.valStart, l.valEnd))
+		}
+		conf.Interface.Resolver = resolver
+	case state == inPeerSection && l.key == "name":
+		peer.Name = value
+	case state == inPeerSection && l.key == "excludedips":
+		excluded, err := parseIPCidrList(value)
+		if err != nil {
+			return p.fail(DiagnosticInvalidValue, err, p.span(l.valStart, l.valEnd))
+		}
+		peer.ExcludedIPs = append(peer.ExcludedIPs, excluded...)
+	case state == inPeerSection && strings.HasPrefix(l.key, strings.ToLower(MetadataPrefix)):
+		key := strings.TrimSpace(l.raw[strings.IndexByte(l.raw, '#')+1 : strings.IndexByte(l.raw, '=')])[len(MetadataPrefix):]
+		if peer.Metadata == nil {
+			peer.Metadata = make(map[string]string)
+		}
+		for existing := range peer.Metadata {
+			if strings.EqualFold(existing, key) {
+				delete(peer.Metadata, existing)
+			}
+		}
+		peer.Metadata[key] = value
+	}
+	return false
+}
+
 func FromWgQuick(s string, name string) (*Config, error) {
+	p := wgQuickParser{}
+	c := p.parse(s, name)
+	if err := p.firstError(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// FromWgQuickWithDiagnostics parses s like FromWgQuick, but instead of stopping
+// at the first error it reports every error and warning it finds, in order.
+// The returned configuration contains whatever could be parsed and should
+// not be used if HasErrors is true of the diagnostics.
+func FromWgQuickWithDiagnostics(s string, name string) (*Config, []Diagnostic) {
+	p := wgQuickParser{collect: true}
+	c := p.parse(s, name)
+	if !HasErrors(p.diagnostics) {
+		p.checkSemantics(c)
+	}
+	return c, p.diagnostics
+}
+
+func (p *wgQuickParser) parse(s string, name string) *Config {
+	return p.parseDocument(ParseDocument(s), name)
+}
+
+func (p *wgQuickParser) parseDocument(doc *Document, name string) *Config {
+	conf := Config{Name: name, Document: doc}
+	p.section = -1
 	if !TunnelNameIsValid(name) {
-		return nil, &ParseError{"Tunnel name is not valid", name}
+		if p.fail(DiagnosticInvalidName, &ParseError{"Tunnel name is not valid", name}, SourceSpan{Section: -1}) {
+			return nil
+		}
 	}
-	lines := strings.Split(s, "\n")
 	parserState := notInASection
-	conf := Config{Name: name}
 	sawPrivateKey := false
 	var peer *Peer
-	for _, line := range lines {
-		pound := strings.IndexByte(line, '#')
-		if pound >= 0 {
-			line = line[:pound]
-		}
-		line = strings.TrimSpace(line)
+	var spans peerSpans
+	var sawKeys map[string]bool
+	var leading []int
+	for i := range doc.lines {
+		p.line, p.rawLine = i+1, doc.lines[i].raw
+		line, lead := doc.lines[i].content, doc.lines[i].lead
 		lineLower := strings.ToLower(line)
 		if len(line) == 0 {
+			if doc.lines[i].kind != docLineAnnotation {
+				continue
+			}
+			if doc.lines[i].section > p.section {
+				leading = append(leading, i)
+			} else if p.annotate(&conf, peer, parserState, &doc.lines[i]) {
+				return nil
+			}
 			continue
 		}
-		if lineLower == "[interface]" {
-			conf.maybeAddPeer(peer)
-			parserState = inInterfaceSection
-			continue
-		}
-		if lineLower == "[peer]" {
-			conf.maybeAddPeer(peer)
-			peer = &Peer{}
-			parserState = inPeerSection
+		if lineLower == "[interface]" || lineLower == "[peer]" {
+			p.maybeAddPeer(&conf, peer, &spans)
+			p.section++
+			sawKeys = make(map[string]bool)
+			if lineLower == "[interface]" {
+				peer = nil
+				p.iface.header = p.span(lead, lead+len(line))
+				parserState = inInterfaceSection
+			} else {
+				peer = &Peer{}
+				spans = peerSpans{header: p.span(lead, lead+len(line))}
+				parserState = inPeerSection
+			}
+			for _, j := range leading {
+				p.line, p.rawLine = j+1, doc.lines[j].raw
+				if p.annotate(&conf, peer, parserState, &doc.lines[j]) {
+					return nil
+				}
+			}
+			leading = nil
 			continue
 		}
 		if parserState == notInASection {
-			return nil, &ParseError{"Line must occur in a section", line}
+			if p.fail(DiagnosticOutsideSection, &ParseError{"Line must occur in a section", line}, p.span(lead, lead+len(line))) {
+				return nil
+			}
+			continue
 		}
 		equals := strings.IndexByte(line, '=')
 		if equals < 0 {
-			return nil, &ParseError{"Invalid config key is missing an equals separator", line}
+			code := DiagnosticMissingEquals
+			if line[0] == '[' && line[len(line)-1] == ']' {
+				code = DiagnosticUnknownSection
+				p.maybeAddPeer(&conf, peer, &spans)
+				peer = nil
+				leading = nil
+				p.section++
+				parserState = inUnknownSection
+			}
+			if p.fail(code, &ParseError{"Invalid config key is missing an equals separator", line}, p.span(lead, lead+len(line))) {
+				return nil
+			}
+			continue
+		}
+		if parserState == inUnknownSection {
+			continue
 		}
 		key, val := strings.TrimSpace(lineLower[:equals]), strings.TrimSpace(line[equals+1:])
+		keySpan := p.span(lead, lead+len(strings.TrimSpace(line[:equals])))
 		if len(val) == 0 {
-			return nil, &ParseError{"Key must have a value", line}
+			if p.fail(DiagnosticMissingValue, &ParseError{"Key must have a value", line}, p.span(lead, lead+len(line))) {
+				return nil
+			}
+			continue
 		}
-		if parserState == inInterfaceSection {
+		valStart := lead + equals + 1 + strings.Index(line[equals+1:], val)
+		valSpan := p.span(valStart, valStart+len(val))
+		var err error
+		var errSpan SourceSpan
+		var items []string
+		var itemSpans []SourceSpan
+		isList := key == "allowedips" && parserState == inPeerSection ||
+			(key == "address" || key == "dns") && parserState == inInterfaceSection
+		if isList {
+			items, err = splitList(val)
+			errSpan = valSpan
+			from := 0
+			for _, item := range items {
+				itemStart := from + strings.Index(val[from:], item)
+				from = itemStart + len(item)
+				itemSpans = append(itemSpans, p.span(valStart+itemStart, valStart+from))
+			}
+		}
+		if err == nil && parserState == inInterfaceSection {
+			errSpan = valSpan
 			switch key {
 			case "privatekey":
-				k, err := parseKeyBase64(val)
-				if err != nil {
-					return nil, err
+				var k *Key
+				k, err = parseKeyBase64(val)
+				if err == nil {
+					conf.Interface.PrivateKey = *k
+					sawPrivateKey = true
 				}
-				conf.Interface.PrivateKey = *k
-				sawPrivateKey = true
 			case "listenport":
-				p, err := parsePort(val)
-				if err != nil {
-					return nil, err
+				var port uint16
+				port, err = parsePort(val)
+				if err == nil {
+					conf.Interface.ListenPort = port
 				}
-				conf.Interface.ListenPort = p
 			case "mtu":
-				m, err := parseMTU(val)
-				if err != nil {
-					return nil, err
+				var m uint16
+				m, err = parseMTU(val)
+				if err == nil {
+					conf.Interface.MTU = m
 				}
-				conf.Interface.MTU = m
 			case "address":
-				addresses, err := splitList(val)
-				if err != nil {
-					return nil, err
-				}
-				for _, address := range addresses {
-					a, err := parseIPCidr(address)
+				for i, address := range items {
+					var a *IPCidr
+					a, err = parseIPCidr(address)
 					if err != nil {
-						return nil, err
+						errSpan = itemSpans[i]
+						break
 					}
 					conf.Interface.Addresses = append(conf.Interface.Addresses, *a)
 				}
 			case "dns":
-				addresses, err := splitList(val)
-				if err != nil {
-					return nil, err
-				}
-				for _, address := range addresses {
+				for i, address := range items {
 					a := net.ParseIP(address)
 					if a == nil {
-						return nil, &ParseError{"Invalid IP address", address}
+						var domain string
+						domain, err = parseSearchDomain(address)
+						if err != nil {
+							errSpan = itemSpans[i]
+							break
+						}
+						conf.Interface.DNSSearch = append(conf.Interface.DNSSearch, domain)
+						continue
 					}
 					conf.Interface.DNS = append(conf.Interface.DNS, a)
+					p.iface.dns = append(p.iface.dns, itemSpans[i])
+				}
+			case "preup":
+				conf.Interface.PreUp = append(conf.Interface.PreUp, val)
+			case "postup":
+				conf.Interface.PostUp = append(conf.Interface.PostUp, val)
+			case "predown":
+				conf.Interface.PreDown = append(conf.Interface.PreDown, val)
+			case "postdown":
+				conf.Interface.PostDown = append(conf.Interface.PostDown, val)
+			case "table":
+				var t string
+				t, err = parseTable(val)
+				if err == nil {
+					conf.Interface.Table = t
+				}
+			case "fwmark":
+				var m uint32
+				m, err = parseFwMark(val)
+				if err == nil {
+					conf.Interface.FwMark = m
+				}
+			case "saveconfig":
+				var b bool
+				b, err = parseSaveConfig(val)
+				if err == nil {
+					conf.Interface.SaveConfig = b
 				}
 			default:
-				return nil, &ParseError{"Invalid key for [Interface] section", key}
+				if p.fail(DiagnosticUnknownKey, &ParseError{"Invalid key for [Interface] section", key}, keySpan) {
+					return nil
+				}
+				continue
 			}
-		} else if parserState == inPeerSection {
+		} else if err == nil && parserState == inPeerSection {
+			errSpan = valSpan
 			switch key {
 			case "publickey":
-				k, err := parseKeyBase64(val)
-				if err != nil {
-					return nil, err
+				var k *Key
+				k, err = parseKeyBase64(val)
+				if err == nil {
+					peer.PublicKey = *k
+					spans.publicKey = valSpan
 				}
-				peer.PublicKey = *k
 			case "presharedkey":
-				k, err := parseKeyBase64(val)
-				if err != nil {
-					return nil, err
+				var k *Key
+				k, err = parseKeyBase64(val)
+				if err == nil {
+					peer.PresharedKey = *k
 				}
-				peer.PresharedKey = *k
 			case "allowedips":
-				addresses, err := splitList(val)
-				if err != nil {
-					return nil, err
-				}
-				for _, address := range addresses {
-					a, err := parseIPCidr(address)
+				for i, address := range items {
+					var a *IPCidr
+					a, err = parseIPCidr(address)
 					if err != nil {
-						return nil, err
+						errSpan = itemSpans[i]
+						break
 					}
 					peer.AllowedIPs = append(peer.AllowedIPs, *a)
+					spans.allowedIPs = append(spans.allowedIPs, itemSpans[i])
 				}
 			case "persistentkeepalive":
-				p, err := parsePersistentKeepalive(val)
-				if err != nil {
-					return nil, err
+				var keepalive uint16
+				keepalive, err = parsePersistentKeepalive(val)
+				if err == nil {
+					peer.PersistentKeepalive = keepalive
+					spans.keepalive = valSpan
 				}
-				peer.PersistentKeepalive = p
 			case "endpoint":
-				e, err := parseEndpoint(val)
-				if err != nil {
-					return nil, err
+				var e *Endpoint
+				e, err = parseEndpoint(val)
+				if err == nil {
+					peer.Endpoint = *e
 				}
-				peer.Endpoint = *e
 			default:
-				return nil, &ParseError{"Invalid key for [Peer] section", key}
+				if p.fail(DiagnosticUnknownKey, &ParseError{"Invalid key for [Peer] section", key}, keySpan) {
+					return nil
+				}
+				continue
 			}
 		}
+		if err != nil {
+			if p.fail(DiagnosticInvalidValue, err, errSpan) {
+				return nil
+			}
+			continue
+		}
+		switch key {
+		case "address", "dns", "allowedips", "preup", "postup", "predown", "postdown":
+		default:
+			if sawKeys[key] {
+				p.warn(DiagnosticDuplicateKey, &ParseError{"Key is specified more than once in this section", key}, keySpan)
+			}
+			sawKeys[key] = true
+		}
 	}
-	conf.maybeAddPeer(peer)
+	p.maybeAddPeer(&conf, peer, &spans)
+	p.line, p.rawLine = 0, ""
 
 	if !sawPrivateKey {
-		return nil, &ParseError{"An interface must have a private key", "[none specified]"}
+		span := p.iface.header
+		if span.Line == 0 {
+			span.Section = -1
+		}
+		if p.fail(DiagnosticMissingPrivateKey, &ParseError{"An interface must have a private key", "[none specified]"}, span) {
+			return nil
+		}
 	}
-	for _, p := range conf.Peers {
-		if p.PublicKey.IsZero() {
-			return nil, &ParseError{"All peers must have public keys", "[none specified]"}
+	for i := range conf.Peers {
+		if conf.Peers[i].PublicKey.IsZero() {
+			if p.fail(DiagnosticMissingPublicKey, &ParseError{"All peers must have public keys", "[none specified]"}, p.peers[i].header) {
+				return nil
+			}
 		}
 	}
 
-	return &conf, nil
+	return &conf
 }
 
 func FromWgQuickWithUnknownEncoding(s string, name string) (*Config, error) {
@@ -363,10 +648,19 @@ func FromUAPI(s string, existingConfig *Config) (*Config, error) {
 	conf := Config{
 		Name: existingConfig.Name,
 		Interface: Interface{
-			Addresses: existingConfig.Interface.Addresses,
-			DNS:       existingConfig.Interface.DNS,
-			MTU:       existingConfig.Interface.MTU,
+			Addresses:  existingConfig.Interface.Addresses,
+			DNS:        existingConfig.Interface.DNS,
+			DNSSearch:  existingConfig.Interface.DNSSearch,
+			MTU:        existingConfig.Interface.MTU,
+			PreUp:      existingConfig.Interface.PreUp,
+			PostUp:     existingConfig.Interface.PostUp,
+			PreDown:    existingConfig.Interface.PreDown,
+			PostDown:   existingConfig.Interface.PostDown,
+			Table:      existingConfig.Interface.Table,
+			SaveConfig: existingConfig.Interface.SaveConfig,
+			Resolver:   existingConfig.Interface.Resolver,
 		},
+		Document: existingConfig.Document,
 	}
 	var peer *Peer
 	for _, line := range lines {
@@ -408,8 +702,11 @@ func FromUAPI(s string, existingConfig *Config) (*Config, error) {
 				}
 				conf.Interface.ListenPort = p
 			case "fwmark":
-				// Ignored for now.
-
+				m, err := parseFwMark(val)
+				if err != nil {
+					return nil, err
+				}
+				conf.Interface.FwMark = m
 			default:
 				return nil, &ParseError{"Invalid key for interface section", key}
 			}
@@ -480,5 +777,22 @@ func FromUAPI(s string, existingConfig *Config) (*Config, error) {
 	}
 	conf.maybeAddPeer(peer)
 
+	for i := range conf.Peers {
+		for j := range existingConfig.Peers {
+			if conf.Peers[i].PublicKey == existingConfig.Peers[j].PublicKey {
+				conf.Peers[i].Name = existingConfig.Peers[j].Name
+				conf.Peers[i].Metadata = existingConfig.Peers[j].Metadata
+				conf.Peers[i].ExcludedIPs = existingConfig.Peers[j].ExcludedIPs
+				// The running tunnel only knows the allowed IPs left after
+				// taking out the excluded ones, so unless they were changed
+				// since, keep them as they were written.
+				if sameIPCidrs(conf.Peers[i].AllowedIPs, existingConfig.Peers[j].EffectiveAllowedIPs()) {
+					conf.Peers[i].AllowedIPs = existingConfig.Peers[j].AllowedIPs
+				}
+				break
+			}
+		}
+	}
+
 	return &conf, nil
 }
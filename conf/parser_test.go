@@ -9,6 +9,7 @@ import (
 	"net"
 	"reflect"
 	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -79,8 +80,8 @@ func TestFromWgQuick(t *testing.T) {
 	if noError(t, err) {
 
 		lenTest(t, conf.Interface.Addresses, 2)
-		contains(t, conf.Interface.Addresses, IPCidr{net.IPv4(10, 10, 0, 1), uint8(16)})
-		contains(t, conf.Interface.Addresses, IPCidr{net.IPv4(10, 192, 122, 1), uint8(24)})
+		contains(t, conf.Interface.Addresses, IPCidr{net.IPv4(10, 10, 0, 1).To4(), uint8(16)})
+		contains(t, conf.Interface.Addresses, IPCidr{net.IPv4(10, 192, 122, 1).To4(), uint8(24)})
 		equal(t, "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=", conf.Interface.PrivateKey.String())
 		equal(t, uint16(51820), conf.Interface.ListenPort)
 
@@ -126,3 +127,193 @@ func TestParseEndpoint(t *testing.T) {
 		t.Error("Error was expected")
 	}
 }
+
+func TestHooks(t *testing.T) {
+	const hooksInput = `
+[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+PreUp = echo one
+PostUp = route add 10.0.0.0 mask 255.0.0.0 10.192.122.1
+PostUp = echo %i is up
+PreDown = echo going down
+PostDown = route delete 10.0.0.0
+`
+	conf, err := FromWgQuick(hooksInput, "test")
+	if !noError(t, err) {
+		return
+	}
+	equal(t, []string{"echo one"}, conf.Interface.PreUp)
+	equal(t, []string{"route add 10.0.0.0 mask 255.0.0.0 10.192.122.1", "echo %i is up"}, conf.Interface.PostUp)
+	equal(t, []string{"echo going down"}, conf.Interface.PreDown)
+	equal(t, []string{"route delete 10.0.0.0"}, conf.Interface.PostDown)
+
+	reparsed, err := FromWgQuick(conf.ToWgQuick(), "test")
+	if noError(t, err) {
+		equal(t, conf, reparsed)
+	}
+}
+
+func TestWgQuickRoutingKeys(t *testing.T) {
+	const routingInput = `
+[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Table = off
+FwMark = 0x1234
+SaveConfig = true
+`
+	conf, err := FromWgQuick(routingInput, "test")
+	if !noError(t, err) {
+		return
+	}
+	equal(t, TableOff, conf.Interface.Table)
+	equal(t, true, conf.Interface.TableIsOff())
+	equal(t, uint32(0x1234), conf.Interface.FwMark)
+	equal(t, true, conf.Interface.SaveConfig)
+
+	reparsed, err := FromWgQuick(conf.ToWgQuick(), "test")
+	if noError(t, err) {
+		equal(t, conf, reparsed)
+	}
+
+	for _, table := range []struct{ in, out string }{{"auto", ""}, {"1234", "1234"}, {"off", "off"}} {
+		conf, err = FromWgQuick("[Interface]\nPrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=\nTable = "+table.in, "test")
+		if noError(t, err) {
+			equal(t, table.out, conf.Interface.Table)
+		}
+	}
+	for _, invalid := range []string{"Table = main", "FwMark = -1", "SaveConfig = yes"} {
+		_, err = FromWgQuick("[Interface]\nPrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=\n"+invalid, "test")
+		if err == nil {
+			t.Errorf("Error was expected for %q", invalid)
+		}
+	}
+}
+
+func TestFromUAPIFwMark(t *testing.T) {
+	existing := &Config{Name: "test", Interface: Interface{Table: TableOff, Resolver: "9.9.9.9"}}
+	conf, err := FromUAPI("private_key=c8099e5f3b5fa575c96ded7a2238b78c32e2da0581e89a08206e20f05fcdce69\nlisten_port=51820\nfwmark=42\nerrno=0\n", existing)
+	if noError(t, err) {
+		equal(t, uint32(42), conf.Interface.FwMark)
+		equal(t, TableOff, conf.Interface.Table)
+		equal(t, "9.9.9.9", conf.Interface.Resolver)
+	}
+}
+
+func TestDNSSearchDomains(t *testing.T) {
+	input := strings.Replace(testInput, "ListenPort = 51820", "DNS = 10.0.0.1, corp.example.com, fd00::1, lab.corp.example.com.\nListenPort = 51820", 1)
+	conf, err := FromWgQuick(input, "test")
+	if !noError(t, err) {
+		return
+	}
+	lenTest(t, conf.Interface.DNS, 2)
+	equal(t, []string{"corp.example.com", "lab.corp.example.com."}, conf.Interface.DNSSearch)
+
+	conf.Document = nil
+	conf, err = FromWgQuick(conf.ToWgQuick(), "test")
+	if noError(t, err) {
+		lenTest(t, conf.Interface.DNS, 2)
+		equal(t, []string{"corp.example.com", "lab.corp.example.com."}, conf.Interface.DNSSearch)
+	}
+
+	for _, invalid := range []string{"10.0.0.300", "fd00::g", "-corp.example", "corp..example", "corp example", "_.1"} {
+		_, err = FromWgQuick(strings.Replace(testInput, "ListenPort = 51820", "DNS = 10.0.0.1, "+invalid+"\nListenPort = 51820", 1), "test")
+		if err == nil {
+			t.Errorf("Error was expected for %q", invalid)
+		}
+	}
+	_, err = FromWgQuick(strings.Replace(testInput, "ListenPort = 51820", "DNS = 10.0.0.300\nListenPort = 51820", 1), "test")
+	if err == nil || !strings.Contains(err.Error(), "Invalid IP address") {
+		t.Errorf("Expected a mistyped address to be reported as such, got %v", err)
+	}
+}
+
+func TestDiagnosticsCollectAll(t *testing.T) {
+	const input = `[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Address = 10.0.0.1/24, 10.0.0.300/24
+  MTU = big
+Bogus = 1
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 0.0.0.0/0`
+	_, diagnostics := FromWgQuickWithDiagnostics(input, "test")
+	if !lenTest(t, diagnostics, 3) {
+		return
+	}
+	equal(t, true, HasErrors(diagnostics))
+	equal(t, DiagnosticInvalidValue, diagnostics[0].Code)
+	equal(t, SourceSpan{Line: 3, Column: 24, EndColumn: 37, Section: 0}, diagnostics[0].SourceSpan)
+	equal(t, "Line 3, column 24: Invalid IP address: \"10.0.0.300/24\"", diagnostics[0].Error())
+	equal(t, DiagnosticInvalidValue, diagnostics[1].Code)
+	equal(t, SourceSpan{Line: 4, Column: 9, EndColumn: 12, Section: 0}, diagnostics[1].SourceSpan)
+	equal(t, DiagnosticUnknownKey, diagnostics[2].Code)
+	equal(t, SourceSpan{Line: 5, Column: 1, EndColumn: 6, Section: 0}, diagnostics[2].SourceSpan)
+
+	_, err := FromWgQuick(input, "test")
+	equal(t, diagnostics[0].Err, err)
+}
+
+func TestDiagnosticsWarnings(t *testing.T) {
+	const input = `[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Address = 10.0.0.1/24
+DNS = 1.1.1.1, 2606:4700:4700::1111
+ListenPort = 1
+ListenPort = 2
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 10.0.0.0/16
+
+[Peer]
+PublicKey = TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=
+AllowedIPs = 192.168.0.0/24, 10.0.5.0/24
+PersistentKeepalive = 25
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+Endpoint = 192.0.2.1:51820`
+	conf, diagnostics := FromWgQuickWithDiagnostics(input, "test")
+	lenTest(t, conf.Peers, 3)
+	if !lenTest(t, diagnostics, 5) {
+		for _, d := range diagnostics {
+			t.Log(d.Code, d.Error())
+		}
+		return
+	}
+	equal(t, DiagnosticDuplicateKey, diagnostics[0].Code)
+	equal(t, SourceSpan{Line: 6, Column: 1, EndColumn: 11, Section: 0}, diagnostics[0].SourceSpan)
+	equal(t, DiagnosticDuplicatePeer, diagnostics[1].Code)
+	equal(t, SeverityError, diagnostics[1].Severity)
+	equal(t, SourceSpan{Line: 18, Column: 13, EndColumn: 57, Section: 3}, diagnostics[1].SourceSpan)
+	equal(t, DiagnosticOverlappingAllowedIPs, diagnostics[2].Code)
+	equal(t, SourceSpan{Line: 14, Column: 30, EndColumn: 41, Section: 2}, diagnostics[2].SourceSpan)
+	equal(t, DiagnosticKeepaliveWithoutEndpoint, diagnostics[3].Code)
+	equal(t, SourceSpan{Line: 15, Column: 23, EndColumn: 25, Section: 2}, diagnostics[3].SourceSpan)
+	equal(t, DiagnosticUnreachableDNS, diagnostics[4].Code)
+	equal(t, SourceSpan{Line: 4, Column: 16, EndColumn: 36, Section: 0}, diagnostics[4].SourceSpan)
+	for i := range diagnostics {
+		if i != 1 {
+			equal(t, SeverityWarning, diagnostics[i].Severity)
+		}
+	}
+
+	_, err := FromWgQuick(input, "test")
+	noError(t, err)
+
+	// An interface without addresses doesn't make every DNS server unreachable.
+	_, diagnostics = FromWgQuickWithDiagnostics("[Interface]\nPrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=\nDNS = 1.1.1.1, 2606:4700:4700::1111\n", "test")
+	lenTest(t, diagnostics, 0)
+}
+
+func TestDiagnosticsMissingKeys(t *testing.T) {
+	_, diagnostics := FromWgQuickWithDiagnostics("[Interface]\nListenPort = 1\n\n[Peer]\nAllowedIPs = 10.0.0.0/8\n", "test")
+	if !lenTest(t, diagnostics, 2) {
+		return
+	}
+	equal(t, DiagnosticMissingPrivateKey, diagnostics[0].Code)
+	equal(t, SourceSpan{Line: 1, Column: 1, EndColumn: 12, Section: 0}, diagnostics[0].SourceSpan)
+	equal(t, DiagnosticMissingPublicKey, diagnostics[1].Code)
+	equal(t, SourceSpan{Line: 4, Column: 1, EndColumn: 7, Section: 1}, diagnostics[1].SourceSpan)
+}
@@ -0,0 +1,32 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.zx2c4.com/wireguard/windows/version"
+)
+
+func tunnelConfigurationsDirectory() (string, error) {
+	root, err := RootDirectory()
+	if err != nil {
+		return "", err
+	}
+	c := filepath.Join(root, "Configurations")
+	return c, os.MkdirAll(c, os.ModeDir|0700)
+}
+
+func RootDirectory() (string, error) {
+	root, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	name, _ := version.RunningNameVersion()
+	c := filepath.Join(root, name)
+	return c, os.MkdirAll(c, os.ModeDir|0700)
+}
@@ -0,0 +1,38 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"bytes"
+
+	"golang.zx2c4.com/wireguard/windows/qrcode"
+)
+
+// QRCode encodes the configuration in wg-quick format as a QR code, as read by
+// the WireGuard apps for phones. The code holds the private key.
+func (config *Config) QRCode() (*qrcode.Code, error) {
+	return qrcode.Encode([]byte(config.ToWgQuick()), qrcode.Low)
+}
+
+// isQRCodeImage tells whether a file name has the extension of an image that
+// may hold a QR code.
+func isQRCodeImage(ext string) bool {
+	switch ext {
+	case ".png", ".jpg", ".jpeg", ".gif":
+		return true
+	}
+	return false
+}
+
+// readQRCodeImage returns the text in the QR code of an image file, which
+// still needs to be parsed.
+func readQRCodeImage(contents []byte) (string, error) {
+	data, err := qrcode.DecodeReader(bytes.NewReader(contents))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
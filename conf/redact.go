@@ -0,0 +1,70 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+)
+
+func isBase64Char(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '+' || c == '/'
+}
+
+func isHexChar(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// keyAt returns the key at the start of text, in either the base64 encoding
+// of wg-quick or the hex encoding of UAPI, along with its encoded length.
+func keyAt(text []byte) (k Key, n int, ok bool) {
+	allHex := true
+	for n < len(text) && isBase64Char(text[n]) {
+		allHex = allHex && isHexChar(text[n])
+		n++
+	}
+	switch {
+	case n == base64.StdEncoding.EncodedLen(KeyLength)-1 && n < len(text) && text[n] == '=' && (n+1 == len(text) || text[n+1] != '='):
+		var decoded [KeyLength + 1]byte
+		decodedLen, err := base64.StdEncoding.Decode(decoded[:], text[:n+1])
+		if err != nil || decodedLen != KeyLength {
+			return k, 0, false
+		}
+		copy(k[:], decoded[:])
+		return k, n + 1, true
+	case n == hex.EncodedLen(KeyLength) && allHex:
+		hex.Decode(k[:], text[:n])
+		return k, n, true
+	}
+	return k, 0, false
+}
+
+// RedactKeys replaces every key-shaped string in text, whether in the base64
+// encoding of wg-quick or the hex encoding of UAPI, with a placeholder
+// holding the key's fingerprint. Keys turn up bare in hook commands and their
+// output, and public keys can't be told apart from private ones, so all of
+// them are redacted, wherever they are.
+func RedactKeys(text []byte) []byte {
+	var out []byte
+	last := 0
+	for i := 0; i < len(text); i++ {
+		if !isBase64Char(text[i]) || i > 0 && isBase64Char(text[i-1]) {
+			continue
+		}
+		k, n, ok := keyAt(text[i:])
+		if !ok {
+			continue
+		}
+		out = append(out, text[last:i]...)
+		out = append(out, k.Redacted()...)
+		last = i + n
+		i = last - 1
+	}
+	if out == nil {
+		return text
+	}
+	return append(out, text[last:]...)
+}
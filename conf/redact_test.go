@@ -0,0 +1,89 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactKeys(t *testing.T) {
+	k, err := NewPrivateKeyFromString("yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=")
+	if !noError(t, err) {
+		return
+	}
+	redacted := k.Redacted()
+	equal(t, "(redacted "+k.Fingerprint()+")", redacted)
+	equal(t, 8, len(k.Fingerprint()))
+
+	c := Config{Name: "test", Interface: Interface{PrivateKey: *k}}
+	uapi, err := c.ToUAPI()
+	if !noError(t, err) {
+		return
+	}
+	equal(t, "private_key="+redacted+"\n", string(RedactKeys([]byte(uapi))))
+
+	psk, err := NewPrivateKeyFromString("TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=")
+	if !noError(t, err) {
+		return
+	}
+	pub := k.Public()
+	tests := map[string]string{
+		"PrivateKey = " + k.String():                                 "PrivateKey = " + redacted,
+		"privatekey=" + k.String() + "\n":                            "privatekey=" + redacted + "\n",
+		"PresharedKey\t=\t" + psk.String():                           "PresharedKey\t=\t" + psk.Redacted(),
+		"preshared_key=" + psk.HexString() + "\npublic_key=x":        "preshared_key=" + psk.Redacted() + "\npublic_key=x",
+		"private_key=" + strings.ToUpper(k.HexString()):              "private_key=" + redacted,
+		"PublicKey = " + pub.String():                                "PublicKey = " + pub.Redacted(),
+		"key " + k.HexString() + ", " + k.String() + ".":             "key " + redacted + ", " + redacted + ".",
+		"my_private_key=" + k.HexString():                            "my_private_key=" + redacted,
+		"private_key=" + k.HexString() + "0":                         "private_key=" + k.HexString() + "0",
+		"PrivateKey = " + k.String() + "=":                           "PrivateKey = " + k.String() + "=",
+		"x" + k.String():                                             "x" + k.String(),
+		"peer(xTIB…p8Dg) - Received handshake response":              "peer(xTIB…p8Dg) - Received handshake response",
+		"PrivateKey = " + k.String() + " PrivateKey = " + k.String(): "PrivateKey = " + redacted + " PrivateKey = " + redacted,
+		// Hook commands and their output hold keys without field names.
+		"cmd /c wg set %i peer " + pub.String() + " preshared-key " + psk.String(): "cmd /c wg set %i peer " + pub.Redacted() + " preshared-key " + psk.Redacted(),
+		"cmd> curl -H \"X-Key: " + k.String() + "\" https://vpn.example/register":  "cmd> curl -H \"X-Key: " + redacted + "\" https://vpn.example/register",
+	}
+	for input, expected := range tests {
+		equal(t, expected, string(RedactKeys([]byte(input))))
+	}
+}
+
+func TestToWgQuickRedacted(t *testing.T) {
+	c, err := FromWgQuick(testInput, "test")
+	if !noError(t, err) {
+		return
+	}
+	redacted := c.ToWgQuickRedacted()
+	for _, secret := range []string{"yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=", "PresharedKey = TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="} {
+		if strings.Contains(redacted, secret) {
+			t.Errorf("Redacted output contains secret %q", secret)
+		}
+	}
+	equal(t, true, strings.Contains(redacted, "PrivateKey = "+c.Interface.PrivateKey.Redacted()+" \n"))
+	equal(t, true, strings.Contains(redacted, "PresharedKey = "+c.Peers[2].PresharedKey.Redacted()+" \n"))
+	equal(t, true, strings.Contains(redacted, "PublicKey = TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0= \n"))
+	equal(t, testInput, c.ToWgQuick())
+
+	c.Document = nil
+	fresh := c.ToWgQuickRedacted()
+	equal(t, true, strings.HasPrefix(fresh, "[Interface]\nPrivateKey = "+c.Interface.PrivateKey.Redacted()+"\n"))
+	_, err = FromWgQuick(fresh, "test")
+	if err == nil {
+		t.Error("Redacted output should not parse as a configuration")
+	}
+
+	c, err = FromWgQuick("[Interface]\nPrivateKey = 6EtabScXwQA6E7QxVwNT26ypFGzxUMX4V1aA/rpSAno=\nPrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=\n", "test")
+	if !noError(t, err) {
+		return
+	}
+	redacted = c.ToWgQuickRedacted()
+	if strings.Contains(redacted, "6EtabScXwQA6E7QxVwNT26ypFGzxUMX4V1aA/rpSAno=") {
+		t.Error("Redacted output contains overridden private key")
+	}
+}
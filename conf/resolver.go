@@ -0,0 +1,197 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// Resolver looks up every address of a host name. The addresses are returned
+// in the order the resolver prefers them. Lookups give up once ctx is done.
+type Resolver interface {
+	LookupHost(ctx context.Context, name string) ([]net.IPAddr, error)
+}
+
+// DefaultResolver is the resolver used by ToUAPI unless the interface has a
+// Resolver set. It uses the system's name resolution, retrying while the
+// network is still coming up.
+var DefaultResolver Resolver = systemResolver{}
+
+// retryPolicy decides how long lookups keep trying while the network is
+// still coming up, such as right after boot.
+type retryPolicy struct {
+	justBooted func() bool
+	online     func() bool
+	sleep      func(ctx context.Context, d time.Duration) error
+}
+
+// sleepContext sleeps for d, or until ctx is done, in which case it returns
+// the error of ctx.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// lookup calls lookupOnce until it succeeds, retrying temporary failures, as
+// well as hosts that aren't found while the system has only just booted and
+// has no Internet connection yet, until ctx is done. Errors from lookupOnce
+// should be *net.DNSError for the two cases to be told apart.
+func (policy *retryPolicy) lookup(ctx context.Context, name string, lookupOnce func(context.Context, string) ([]net.IPAddr, error)) (addrs []net.IPAddr, err error) {
+	maxTries := 10
+	systemJustBooted := policy.justBooted()
+	if systemJustBooted {
+		maxTries *= 4
+	}
+	for i := 0; i < maxTries; i++ {
+		addrs, err = lookupOnce(ctx, name)
+		if err == nil {
+			return
+		}
+		dnsErr, ok := err.(*net.DNSError)
+		if !ok {
+			return
+		}
+		if dnsErr.Temporary() {
+			log.Printf("Temporary DNS error when resolving %s, sleeping for 4 seconds", name)
+		} else if dnsErr.IsNotFound && systemJustBooted && !policy.online() {
+			log.Printf("Host not found when resolving %s, but no Internet connection available, sleeping for 4 seconds", name)
+		} else {
+			return
+		}
+		if sleepErr := policy.sleep(ctx, time.Second*4); sleepErr != nil {
+			return nil, sleepErr
+		}
+	}
+	return
+}
+
+type FamilyPreference int
+
+const (
+	NoFamilyPreference FamilyPreference = iota
+	PreferIPv4
+	PreferIPv6
+)
+
+// EndpointResolver turns the host names of peer endpoints into addresses.
+type EndpointResolver struct {
+	Resolver   Resolver
+	Preference FamilyPreference
+}
+
+// orderAddresses puts the addresses of the preferred family first. Without a
+// preference, the families are interleaved, starting with the family of the
+// first address, as happy eyeballs does.
+func orderAddresses(addrs []net.IPAddr, preference FamilyPreference) []net.IPAddr {
+	var ipv4, ipv6 []net.IPAddr
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			ipv4 = append(ipv4, addr)
+		} else {
+			ipv6 = append(ipv6, addr)
+		}
+	}
+	switch preference {
+	case PreferIPv4:
+		return append(ipv4, ipv6...)
+	case PreferIPv6:
+		return append(ipv6, ipv4...)
+	}
+	first, second := ipv4, ipv6
+	if len(addrs) > 0 && addrs[0].IP.To4() == nil {
+		first, second = ipv6, ipv4
+	}
+	ordered := make([]net.IPAddr, 0, len(addrs))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			ordered = append(ordered, first[i])
+		}
+		if i < len(second) {
+			ordered = append(ordered, second[i])
+		}
+	}
+	return ordered
+}
+
+func isIPLiteral(host string) bool {
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	return net.ParseIP(host) != nil
+}
+
+// Candidates returns every address the endpoint's host resolves to, best
+// first. An endpoint that is already an address is returned unchanged.
+func (r *EndpointResolver) Candidates(ctx context.Context, endpoint *Endpoint) ([]Endpoint, error) {
+	if isIPLiteral(endpoint.Host) {
+		return []Endpoint{*endpoint}, nil
+	}
+	addrs, err := r.Resolver.LookupHost(ctx, endpoint.Host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", endpoint.Host)
+	}
+	addrs = orderAddresses(addrs, r.Preference)
+	candidates := make([]Endpoint, len(addrs))
+	for i, addr := range addrs {
+		candidates[i] = Endpoint{addr.String(), endpoint.Port}
+	}
+	return candidates, nil
+}
+
+// EndpointLookupError is the error ResolveConfig returns when the host of a
+// peer's endpoint cannot be resolved.
+type EndpointLookupError struct {
+	PublicKey Key
+	Endpoint  Endpoint
+	Err       error
+}
+
+func (e *EndpointLookupError) Error() string {
+	reason := e.Err.Error()
+	if dnsErr, ok := e.Err.(*net.DNSError); ok && len(dnsErr.Err) > 0 {
+		reason = dnsErr.Err
+	}
+	return fmt.Sprintf("DNS lookup of %s failed: %s", e.Endpoint.Host, reason)
+}
+
+func (e *EndpointLookupError) Unwrap() error {
+	return e.Err
+}
+
+// ResolveConfig returns a copy of the configuration in which the endpoint of
+// every peer is replaced by the best candidate for its host. Lookup failures
+// are returned as *EndpointLookupError.
+func (r *EndpointResolver) ResolveConfig(ctx context.Context, config *Config) (*Config, error) {
+	resolved := *config
+	resolved.Peers = make([]Peer, len(config.Peers))
+	copy(resolved.Peers, config.Peers)
+	for i := range resolved.Peers {
+		peer := &resolved.Peers[i]
+		if peer.Endpoint.IsEmpty() {
+			continue
+		}
+		candidates, err := r.Candidates(ctx, &peer.Endpoint)
+		if err != nil {
+			return nil, &EndpointLookupError{peer.PublicKey, peer.Endpoint, err}
+		}
+		peer.Endpoint = candidates[0]
+	}
+	return &resolved, nil
+}
@@ -0,0 +1,126 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type fakeResolver map[string][]string
+
+func (f fakeResolver) LookupHost(ctx context.Context, name string) ([]net.IPAddr, error) {
+	addresses, ok := f[name]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	var addrs []net.IPAddr
+	for _, address := range addresses {
+		ip, zone := address, ""
+		if i := strings.IndexByte(address, '%'); i >= 0 {
+			ip, zone = address[:i], address[i+1:]
+		}
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip), Zone: zone})
+	}
+	return addrs, nil
+}
+
+type failingResolver struct {
+	err error
+}
+
+func (f failingResolver) LookupHost(ctx context.Context, name string) ([]net.IPAddr, error) {
+	return nil, f.err
+}
+
+func endpointStrings(endpoints []Endpoint) []string {
+	var s []string
+	for i := range endpoints {
+		s = append(s, endpoints[i].String())
+	}
+	return s
+}
+
+func TestResolverCandidates(t *testing.T) {
+	dns := fakeResolver{
+		"mixed.example": {"2001:db8::1", "192.0.2.1", "192.0.2.2", "2001:db8::2", "192.0.2.3"},
+		"linklocal":     {"fe80::1%3"},
+		"empty":         {},
+	}
+	tests := []struct {
+		host       string
+		preference FamilyPreference
+		expected   []string
+	}{
+		{"mixed.example", NoFamilyPreference, []string{"[2001:db8::1]:51820", "192.0.2.1:51820", "[2001:db8::2]:51820", "192.0.2.2:51820", "192.0.2.3:51820"}},
+		{"mixed.example", PreferIPv4, []string{"192.0.2.1:51820", "192.0.2.2:51820", "192.0.2.3:51820", "[2001:db8::1]:51820", "[2001:db8::2]:51820"}},
+		{"mixed.example", PreferIPv6, []string{"[2001:db8::1]:51820", "[2001:db8::2]:51820", "192.0.2.1:51820", "192.0.2.2:51820", "192.0.2.3:51820"}},
+		{"linklocal", NoFamilyPreference, []string{"[fe80::1%3]:51820"}},
+		{"198.51.100.7", PreferIPv6, []string{"198.51.100.7:51820"}},
+		{"2001:db8::7", NoFamilyPreference, []string{"[2001:db8::7]:51820"}},
+	}
+	for _, test := range tests {
+		resolver := &EndpointResolver{Resolver: dns, Preference: test.preference}
+		candidates, err := resolver.Candidates(context.Background(), &Endpoint{test.host, 51820})
+		if err != nil {
+			t.Errorf("%s: %v", test.host, err)
+			continue
+		}
+		if actual := endpointStrings(candidates); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s with preference %d: got %v, expected %v", test.host, test.preference, actual, test.expected)
+		}
+	}
+
+	resolver := &EndpointResolver{Resolver: dns}
+	for _, host := range []string{"missing.example", "empty"} {
+		if _, err := resolver.Candidates(context.Background(), &Endpoint{host, 51820}); err == nil {
+			t.Errorf("%s: expected an error", host)
+		}
+	}
+}
+
+func TestResolveConfig(t *testing.T) {
+	c, err := FromWgQuick(testInput, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Peers[1].Endpoint = Endpoint{}
+	resolver := &EndpointResolver{Resolver: fakeResolver{"test.wireguard.com": {"192.0.2.1", "2001:db8::1"}}, Preference: PreferIPv6}
+	resolved, err := resolver.ResolveConfig(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Peers[2].Endpoint.Host != "test.wireguard.com" {
+		t.Error("ResolveConfig modified the original configuration")
+	}
+	equal(t, c.Peers[0].Endpoint, resolved.Peers[0].Endpoint)
+	equal(t, true, resolved.Peers[1].Endpoint.IsEmpty())
+	equal(t, "[2001:db8::1]:18981", resolved.Peers[2].Endpoint.String())
+	uapi := resolved.toUAPI()
+	if !strings.Contains(uapi, "endpoint=[2001:db8::1]:18981\n") {
+		t.Errorf("Resolved endpoint missing from UAPI configuration:\n%s", uapi)
+	}
+
+	resolver.Resolver = fakeResolver{}
+	_, err = resolver.ResolveConfig(context.Background(), c)
+	lookupErr, ok := err.(*EndpointLookupError)
+	if !ok {
+		t.Fatalf("Expected an endpoint lookup error for an unresolvable endpoint, not %v", err)
+	}
+	equal(t, c.Peers[2].PublicKey, lookupErr.PublicKey)
+	equal(t, "DNS lookup of test.wireguard.com failed: no such host", lookupErr.Error())
+
+	resolver.Resolver = failingResolver{&net.DNSError{Err: "host not found", Name: "test.wireguard.com", IsNotFound: true}}
+	_, err = resolver.ResolveConfig(context.Background(), c)
+	equal(t, "DNS lookup of test.wireguard.com failed: host not found", err.Error())
+	if _, ok := errors.Unwrap(err).(*net.DNSError); !ok {
+		t.Errorf("Endpoint lookup error should wrap the resolver's error, not %v", errors.Unwrap(err))
+	}
+}
@@ -0,0 +1,125 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeyRotation says which keys RotateKeys replaces.
+type KeyRotation struct {
+	PrivateKey    bool // the interface's private key
+	PresharedKeys bool // the preshared key of every peer
+}
+
+// RotatedKeys is what the other ends of a tunnel need to know after its keys
+// were rotated. It holds no private key.
+type RotatedKeys struct {
+	Rotation     KeyRotation
+	OldPublicKey Key
+	PublicKey    Key
+	Peers        []RotatedPeer
+}
+
+// RotatedPeer gives the preshared key now shared with a peer, which is zero
+// when there is none.
+type RotatedPeer struct {
+	Name         string
+	PublicKey    Key
+	PresharedKey Key
+}
+
+// RotateKeys generates new keys for the configuration, which is left
+// unchanged if that fails.
+func (config *Config) RotateKeys(rotation KeyRotation) (*RotatedKeys, error) {
+	if !rotation.PrivateKey && !rotation.PresharedKeys {
+		return nil, errors.New("No keys were chosen to be rotated")
+	}
+	privateKey := config.Interface.PrivateKey
+	if rotation.PrivateKey {
+		k, err := NewPrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		privateKey = *k
+	}
+	presharedKeys := make([]Key, len(config.Peers))
+	for i := range config.Peers {
+		presharedKeys[i] = config.Peers[i].PresharedKey
+		if rotation.PresharedKeys {
+			k, err := NewPresharedKey()
+			if err != nil {
+				return nil, err
+			}
+			presharedKeys[i] = *k
+		}
+	}
+
+	rotated := &RotatedKeys{
+		Rotation:     rotation,
+		OldPublicKey: *config.Interface.PrivateKey.Public(),
+		PublicKey:    *privateKey.Public(),
+		Peers:        make([]RotatedPeer, len(config.Peers)),
+	}
+	config.Interface.PrivateKey = privateKey
+	for i := range config.Peers {
+		config.Peers[i].PresharedKey = presharedKeys[i]
+		rotated.Peers[i] = RotatedPeer{
+			Name:         config.Peers[i].Name,
+			PublicKey:    config.Peers[i].PublicKey,
+			PresharedKey: presharedKeys[i],
+		}
+	}
+	return rotated, nil
+}
+
+// KeysToUAPI returns the UAPI set operation that gives a running tunnel the
+// keys of the configuration chosen by rotation, leaving everything else alone.
+func (config *Config) KeysToUAPI(rotation KeyRotation) string {
+	var output strings.Builder
+	if rotation.PrivateKey {
+		output.WriteString(fmt.Sprintf("private_key=%s\n", config.Interface.PrivateKey.HexString()))
+	}
+	if rotation.PresharedKeys {
+		for _, peer := range config.Peers {
+			output.WriteString(fmt.Sprintf("public_key=%s\n", peer.PublicKey.HexString()))
+			output.WriteString(fmt.Sprintf("preshared_key=%s\n", peer.PresharedKey.HexString()))
+		}
+	}
+	return output.String()
+}
+
+// RemoteSnippet returns, for each peer, the lines to change in the peer's own
+// configuration, in its section for this tunnel.
+func (r *RotatedKeys) RemoteSnippet() string {
+	var output strings.Builder
+	writeSection := func(presharedKey *Key) {
+		output.WriteString("[Peer]\n")
+		output.WriteString(fmt.Sprintf("PublicKey = %s\n", r.PublicKey.String()))
+		if presharedKey != nil && !presharedKey.IsZero() {
+			output.WriteString(fmt.Sprintf("PresharedKey = %s\n", presharedKey.String()))
+		}
+	}
+	if len(r.Peers) == 0 {
+		writeSection(nil)
+		return output.String()
+	}
+	for i := range r.Peers {
+		peer := &r.Peers[i]
+		if i > 0 {
+			output.WriteString("\n")
+		}
+		name := peer.PublicKey.String()
+		if len(peer.Name) > 0 {
+			name = fmt.Sprintf("%s (%s)", peer.Name, name)
+		}
+		output.WriteString(fmt.Sprintf("# On %s, in the [Peer] with PublicKey = %s:\n", name, r.OldPublicKey.String()))
+		writeSection(&peer.PresharedKey)
+	}
+	return output.String()
+}
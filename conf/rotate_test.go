@@ -0,0 +1,102 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRotateKeys(t *testing.T) {
+	config, err := FromWgQuick(testInput, "rotated")
+	if !noError(t, err) {
+		return
+	}
+	original, _ := FromWgQuick(testInput, "rotated")
+
+	if _, err = config.RotateKeys(KeyRotation{}); err == nil {
+		t.Error("Rotating nothing should fail")
+	}
+
+	rotated, err := config.RotateKeys(KeyRotation{PrivateKey: true})
+	if !noError(t, err) {
+		return
+	}
+	if config.Interface.PrivateKey == original.Interface.PrivateKey {
+		t.Error("Private key was not rotated")
+	}
+	equal(t, *original.Interface.PrivateKey.Public(), rotated.OldPublicKey)
+	equal(t, *config.Interface.PrivateKey.Public(), rotated.PublicKey)
+	for i := range config.Peers {
+		equal(t, original.Peers[i].PresharedKey, config.Peers[i].PresharedKey)
+	}
+	equal(t, "private_key="+config.Interface.PrivateKey.HexString()+"\n", config.KeysToUAPI(rotated.Rotation))
+
+	rotated, err = config.RotateKeys(KeyRotation{PresharedKeys: true})
+	if !noError(t, err) || !lenTest(t, rotated.Peers, len(config.Peers)) {
+		return
+	}
+	equal(t, rotated.OldPublicKey, rotated.PublicKey)
+	seen := make(map[Key]bool)
+	for i := range config.Peers {
+		psk := config.Peers[i].PresharedKey
+		if psk.IsZero() || psk == original.Peers[i].PresharedKey || seen[psk] {
+			t.Errorf("Peer %d did not get a new preshared key of its own", i)
+		}
+		seen[psk] = true
+		equal(t, config.Peers[i].PublicKey, rotated.Peers[i].PublicKey)
+		equal(t, psk, rotated.Peers[i].PresharedKey)
+	}
+	uapi := config.KeysToUAPI(rotated.Rotation)
+	if strings.Contains(uapi, "private_key") || strings.Count(uapi, "preshared_key=") != len(config.Peers) {
+		t.Errorf("Wrong UAPI for preshared keys:\n%s", uapi)
+	}
+
+	// The keys are kept by the text of the configuration, along with its comments.
+	text := config.ToWgQuick()
+	if !strings.Contains(text, "#comments don't matter") {
+		t.Error("Comments were lost")
+	}
+	reparsed, err := FromWgQuick(text, "rotated")
+	if noError(t, err) {
+		equal(t, config.Interface.PrivateKey, reparsed.Interface.PrivateKey)
+		for i := range config.Peers {
+			equal(t, config.Peers[i].PresharedKey, reparsed.Peers[i].PresharedKey)
+		}
+	}
+}
+
+func TestRemoteSnippet(t *testing.T) {
+	config, err := FromWgQuick(testInput, "rotated")
+	if !noError(t, err) {
+		return
+	}
+	config.Peers[1].Name = "laptop"
+	rotated, err := config.RotateKeys(KeyRotation{PrivateKey: true, PresharedKeys: true})
+	if !noError(t, err) {
+		return
+	}
+	snippet := rotated.RemoteSnippet()
+	equal(t, len(config.Peers), strings.Count(snippet, "[Peer]\n"))
+	equal(t, len(config.Peers), strings.Count(snippet, "PublicKey = "+rotated.PublicKey.String()+"\n"))
+	for i := range config.Peers {
+		if !strings.Contains(snippet, "PresharedKey = "+config.Peers[i].PresharedKey.String()+"\n") {
+			t.Errorf("Preshared key of peer %d is missing", i)
+		}
+	}
+	if !strings.Contains(snippet, "laptop (TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=)") {
+		t.Errorf("Peer names should be given:\n%s", snippet)
+	}
+	if strings.Contains(snippet, config.Interface.PrivateKey.String()) {
+		t.Error("Snippet must not contain the private key")
+	}
+
+	lonely, _ := FromWgQuick("[Interface]\nPrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=\n", "lonely")
+	rotated, err = lonely.RotateKeys(KeyRotation{PrivateKey: true})
+	if noError(t, err) {
+		equal(t, "[Peer]\nPublicKey = "+rotated.PublicKey.String()+"\n", rotated.RemoteSnippet())
+	}
+}
@@ -0,0 +1,85 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"sort"
+)
+
+// PeerStats is what changed about a peer of a running tunnel since the
+// previous snapshot. Peers are told apart by the fingerprint of their public
+// key, so that no key material needs to be passed along. RxBytes and TxBytes
+// are the totals, while RxDelta and TxDelta are what was transferred since
+// the previous snapshot. Removed is set for a peer that is no longer there.
+type PeerStats struct {
+	Fingerprint       string
+	Name              string
+	Endpoint          Endpoint
+	RxBytes           Bytes
+	TxBytes           Bytes
+	RxDelta           Bytes
+	TxDelta           Bytes
+	LastHandshakeTime HandshakeTime
+	Removed           bool
+}
+
+// StatsAggregator turns successive runtime configurations of a tunnel, as
+// returned by FromUAPI, into the changes between them.
+type StatsAggregator struct {
+	previous map[Key]PeerStats
+}
+
+// delta returns how much a counter grew from previous to current. A counter
+// that went backward was reset, such as by the tunnel restarting, so all of
+// it is new.
+func delta(previous, current Bytes) Bytes {
+	if current < previous {
+		return current
+	}
+	return current - previous
+}
+
+// Update takes the next snapshot and returns the stats of the peers that
+// changed since the previous one, in the order of the configuration, followed
+// by those that were removed, ordered by fingerprint. Passing nil, such as
+// when the tunnel is not running, forgets the previous snapshot, so that the
+// next one is reported in full.
+func (a *StatsAggregator) Update(config *Config) []PeerStats {
+	if config == nil {
+		a.previous = nil
+		return nil
+	}
+	var changes []PeerStats
+	current := make(map[Key]PeerStats, len(config.Peers))
+	for i := range config.Peers {
+		peer := &config.Peers[i]
+		stats := PeerStats{
+			Fingerprint:       peer.PublicKey.Fingerprint(),
+			Name:              peer.Name,
+			Endpoint:          peer.Endpoint,
+			RxBytes:           peer.RxBytes,
+			TxBytes:           peer.TxBytes,
+			LastHandshakeTime: peer.LastHandshakeTime,
+		}
+		previous, seen := a.previous[peer.PublicKey]
+		stats.RxDelta = delta(previous.RxBytes, stats.RxBytes)
+		stats.TxDelta = delta(previous.TxBytes, stats.TxBytes)
+		current[peer.PublicKey] = stats
+		if !seen || stats.RxDelta > 0 || stats.TxDelta > 0 || stats.Endpoint != previous.Endpoint || stats.LastHandshakeTime != previous.LastHandshakeTime || stats.Name != previous.Name {
+			changes = append(changes, stats)
+		}
+	}
+	for i := range config.Peers {
+		delete(a.previous, config.Peers[i].PublicKey)
+	}
+	var removed []PeerStats
+	for _, stats := range a.previous {
+		removed = append(removed, PeerStats{Fingerprint: stats.Fingerprint, Name: stats.Name, Removed: true})
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].Fingerprint < removed[j].Fingerprint })
+	a.previous = current
+	return append(changes, removed...)
+}
@@ -0,0 +1,83 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStatsAggregator(t *testing.T) {
+	stored, err := FromWgQuick(testInput, "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.Peers[0].Name = "office"
+	first, second := &stored.Peers[0].PublicKey, &stored.Peers[1].PublicKey
+
+	// snapshot returns the runtime configuration the tunnel would report
+	// with the given peers, each a public key, an endpoint, the bytes
+	// received and sent and the seconds of the latest handshake.
+	type peerState struct {
+		key               *Key
+		endpoint          string
+		rx, tx, handshake uint64
+	}
+	snapshot := func(peers ...peerState) *Config {
+		uapi := "private_key=" + stored.Interface.PrivateKey.HexString() + "\nlisten_port=51820\n"
+		for _, peer := range peers {
+			uapi += fmt.Sprintf("public_key=%s\nendpoint=%s\nrx_bytes=%d\ntx_bytes=%d\nlast_handshake_time_sec=%d\nlast_handshake_time_nsec=0\n", peer.key.HexString(), peer.endpoint, peer.rx, peer.tx, peer.handshake)
+		}
+		config, err := FromUAPI(uapi+"errno=0\n", stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return config
+	}
+
+	var aggregator StatsAggregator
+	changes := aggregator.Update(snapshot(peerState{first, "192.95.5.67:1234", 100, 50, 1000}, peerState{second, "[2607:5300:60:6b0::c05f:543]:2468", 0, 0, 0}))
+	if lenTest(t, changes, 2) {
+		equal(t, PeerStats{first.Fingerprint(), "office", Endpoint{"192.95.5.67", 1234}, 100, 50, 100, 50, HandshakeTime(1000 * 1e9), false}, changes[0])
+		equal(t, second.Fingerprint(), changes[1].Fingerprint)
+	}
+
+	// Only what changed is reported, such as a peer that roamed.
+	changes = aggregator.Update(snapshot(peerState{first, "192.95.5.67:1234", 150, 50, 1000}, peerState{second, "[2607:5300:60:6b0::c05f:543]:2468", 0, 0, 0}))
+	if lenTest(t, changes, 1) {
+		equal(t, Bytes(50), changes[0].RxDelta)
+		equal(t, Bytes(0), changes[0].TxDelta)
+		equal(t, Bytes(150), changes[0].RxBytes)
+	}
+	changes = aggregator.Update(snapshot(peerState{first, "192.95.5.67:1234", 150, 50, 1000}, peerState{second, "198.51.100.7:2468", 0, 0, 0}))
+	if lenTest(t, changes, 1) {
+		equal(t, Endpoint{"198.51.100.7", 2468}, changes[0].Endpoint)
+	}
+	lenTest(t, aggregator.Update(snapshot(peerState{first, "192.95.5.67:1234", 150, 50, 1000}, peerState{second, "198.51.100.7:2468", 0, 0, 0})), 0)
+
+	// Removed peers are reported as such, and counters that went backward
+	// were reset.
+	changes = aggregator.Update(snapshot(peerState{first, "192.95.5.67:1234", 10, 5, 2000}))
+	if lenTest(t, changes, 2) {
+		equal(t, Bytes(10), changes[0].RxDelta)
+		equal(t, Bytes(5), changes[0].TxDelta)
+		equal(t, true, changes[1].Removed)
+		equal(t, second.Fingerprint(), changes[1].Fingerprint)
+	}
+
+	// After the tunnel stops, the next snapshot is reported in full.
+	lenTest(t, aggregator.Update(nil), 0)
+	lenTest(t, aggregator.Update(snapshot(peerState{first, "192.95.5.67:1234", 10, 5, 2000})), 1)
+
+	for _, stats := range changes {
+		for i := range stored.Peers {
+			if text := fmt.Sprintf("%+v", stats); strings.Contains(text, stored.Peers[i].PublicKey.String()) || strings.Contains(text, stored.Peers[i].PublicKey.HexString()) {
+				t.Errorf("Stats contain a public key: %s", text)
+			}
+		}
+	}
+}
@@ -6,20 +6,147 @@
 package conf
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
-
-	"golang.zx2c4.com/wireguard/windows/conf/dpapi"
+	"time"
 )
 
 const configFileSuffix = ".conf.dpapi"
 const configFileUnencryptedSuffix = ".conf"
+const configFileJSONSuffix = ".json"
+const templateFileUnencryptedSuffix = ".template"
+const templateValuesFileUnencryptedSuffix = ".values"
+const revisionFileUnencryptedSuffix = ".revision"
+const encryptedFileSuffix = ".dpapi"
+const historyDirectory = "history"
+
+// Store holds the configurations of tunnels by name, along with the templates
+// that some of them were made from.
+type Store interface {
+	ListConfigNames() ([]string, error)
+	Load(name string) (*Config, error)
+	// Save stores the configuration under its name, replacing any existing
+	// one, and updates config.Document to the text that was stored. Each save
+	// is also kept as a revision attributed to author, pruning the oldest
+	// beyond MaxRevisions.
+	Save(config *Config, author string) error
+	// Delete removes the configuration, its template and its revisions.
+	Delete(name string) error
+	// Rename moves the configuration, its template and its revisions to
+	// newName. It fails if another tunnel has that name, ignoring case.
+	Rename(oldName, newName string) error
+	SaveTemplate(name string, t *TunnelTemplate) error
+	LoadTemplate(name string) (*TunnelTemplate, error)
+	// Revisions lists the kept revisions of the configuration, newest first.
+	Revisions(name string) ([]Revision, error)
+	LoadRevision(name string, number uint64) (*Config, error)
+	// PurgeRevisions removes every kept revision but the newest, so that
+	// keys replaced by a rotation are no longer kept.
+	PurgeRevisions(name string) error
+	// Path returns the file that holds the configuration, which is what the
+	// tunnel service is started with. It fails for stores that keep
+	// configurations other than in files.
+	Path(name string) (string, error)
+}
+
+// CheckNameAvailable returns an error if a tunnel other than current has a
+// name that differs from name only by case, as Windows would consider the two
+// the same.
+func CheckNameAvailable(store Store, name string, current string) error {
+	names, err := store.ListConfigNames()
+	if err != nil {
+		return err
+	}
+	for _, existing := range names {
+		if strings.EqualFold(existing, name) && existing != current {
+			return fmt.Errorf("Another tunnel already exists with the name ‘%s’", existing)
+		}
+	}
+	return nil
+}
+
+type directoryStore struct {
+	dir       func() (string, error)
+	encrypted bool
+}
+
+// NewDirectoryStore returns a store that keeps unencrypted configurations in
+// dir, which is created if needed, as files named after the tunnels.
+func NewDirectoryStore(dir string) Store {
+	return &directoryStore{func() (string, error) { return dir, os.MkdirAll(dir, 0700) }, false}
+}
+
+func (s *directoryStore) suffix(unencryptedSuffix string) string {
+	if s.encrypted {
+		return unencryptedSuffix + encryptedFileSuffix
+	}
+	return unencryptedSuffix
+}
+
+func (s *directoryStore) path(name string, unencryptedSuffix string) (string, error) {
+	if !TunnelNameIsValid(name) {
+		return "", errors.New("Tunnel name is not valid")
+	}
+	dir, err := s.dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+s.suffix(unencryptedSuffix)), nil
+}
+
+func (s *directoryStore) readFile(name string, unencryptedSuffix string) ([]byte, error) {
+	path, err := s.path(name, unencryptedSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return s.readPath(path, name)
+}
 
-func ListConfigNames() ([]string, error) {
-	configFileDir, err := tunnelConfigurationsDirectory()
+func (s *directoryStore) readPath(path string, name string) ([]byte, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil || !s.encrypted {
+		return bytes, err
+	}
+	return decrypt(bytes, name)
+}
+
+func (s *directoryStore) writeFile(name string, unencryptedSuffix string, data []byte) error {
+	path, err := s.path(name, unencryptedSuffix)
+	if err != nil {
+		return err
+	}
+	return s.writePath(path, name, data)
+}
+
+func (s *directoryStore) writePath(path string, name string, data []byte) error {
+	var err error
+	if s.encrypted {
+		data, err = encrypt(data, name)
+		if err != nil {
+			return err
+		}
+	}
+	err = ioutil.WriteFile(path+".tmp", data, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return nil
+}
+
+func (s *directoryStore) ListConfigNames() ([]string, error) {
+	configFileDir, err := s.dir()
 	if err != nil {
 		return nil, err
 	}
@@ -27,17 +154,18 @@ func ListConfigNames() ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	suffix := s.suffix(configFileUnencryptedSuffix)
 	configs := make([]string, len(files))
 	i := 0
 	for _, file := range files {
 		name := filepath.Base(file.Name())
-		if len(name) <= len(configFileSuffix) || !strings.HasSuffix(name, configFileSuffix) {
+		if len(name) <= len(suffix) || !strings.HasSuffix(name, suffix) {
 			continue
 		}
 		if !file.Mode().IsRegular() || file.Mode().Perm()&0444 == 0 {
 			continue
 		}
-		name = strings.TrimSuffix(name, configFileSuffix)
+		name = strings.TrimSuffix(name, suffix)
 		if !TunnelNameIsValid(name) {
 			continue
 		}
@@ -47,176 +175,368 @@ func ListConfigNames() ([]string, error) {
 	return configs[:i], nil
 }
 
-func MigrateUnencryptedConfigs() (int, []error) {
-	configFileDir, err := tunnelConfigurationsDirectory()
+func (s *directoryStore) Path(name string) (string, error) {
+	return s.path(name, configFileUnencryptedSuffix)
+}
+
+func (s *directoryStore) Load(name string) (*Config, error) {
+	bytes, err := s.readFile(name, configFileUnencryptedSuffix)
 	if err != nil {
-		return 0, []error{err}
+		return nil, err
 	}
-	files, err := ioutil.ReadDir(configFileDir)
+	return FromWgQuickWithUnknownEncoding(string(bytes), name)
+}
+
+func (s *directoryStore) Save(config *Config, author string) error {
+	text := config.ToWgQuick()
+	err := s.writeFile(config.Name, configFileUnencryptedSuffix, []byte(text))
 	if err != nil {
-		return 0, []error{err}
+		return err
 	}
-	errs := make([]error, len(files))
-	i := 0
-	e := 0
-	for _, file := range files {
-		path := filepath.Join(configFileDir, file.Name())
-		name := filepath.Base(file.Name())
-		if len(name) <= len(configFileUnencryptedSuffix) || !strings.HasSuffix(name, configFileUnencryptedSuffix) {
-			continue
-		}
-		if !file.Mode().IsRegular() || file.Mode().Perm()&0444 == 0 {
-			continue
+	config.Document = ParseDocument(text)
+	return s.addRevision(config.Name, text, author)
+}
+
+func (s *directoryStore) Delete(name string) error {
+	path, err := s.path(name, configFileUnencryptedSuffix)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil {
+		return err
+	}
+	for _, suffix := range []string{templateFileUnencryptedSuffix, templateValuesFileUnencryptedSuffix} {
+		path, _ = s.path(name, suffix)
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
 		}
+	}
+	historyDir, err := s.historyDir(name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(historyDir)
+}
 
-		// We don't use ioutil's ReadFile, because we actually want RDWR, so that we can take advantage
-		// of Windows file locking for ensuring the file is finished being written.
-		f, err := os.OpenFile(path, os.O_RDWR, 0)
+// renamedFile is a file written under the new name during a rename, along
+// with the file it replaces. The two paths are equal when only the case of
+// the name changed on a file system that ignores case.
+type renamedFile struct {
+	oldPath, newPath string
+}
+
+func (s *directoryStore) renameFile(oldPath, oldName, newPath, newName string, renamed *[]renamedFile) error {
+	data, err := s.readPath(oldPath, oldName)
+	if err != nil {
+		return err
+	}
+	if oldPath != newPath && isSameFile(oldPath, newPath) {
+		err = os.Rename(oldPath, newPath)
 		if err != nil {
-			errs[e] = err
-			e++
-			continue
+			return err
 		}
-		bytes, err := ioutil.ReadAll(f)
-		f.Close()
-		if err != nil {
-			errs[e] = err
-			e++
-			continue
+		oldPath = newPath
+	}
+	err = s.writePath(newPath, newName, data)
+	if err != nil {
+		return err
+	}
+	*renamed = append(*renamed, renamedFile{oldPath, newPath})
+	return nil
+}
+
+// isSameFile tells whether two paths name the same file, as when they differ
+// only by case on Windows.
+func isSameFile(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
+
+func (s *directoryStore) Rename(oldName, newName string) error {
+	if !TunnelNameIsValid(oldName) || !TunnelNameIsValid(newName) {
+		return errors.New("Tunnel name is not valid")
+	}
+	err := CheckNameAvailable(s, newName, oldName)
+	if err != nil {
+		return err
+	}
+	oldHistoryDir, _ := s.historyDir(oldName)
+	newHistoryDir, _ := s.historyDir(newName)
+	numbers, err := s.revisionNumbers(oldHistoryDir)
+	if err != nil {
+		return err
+	}
+
+	// Everything is decrypted and encrypted again, since the name is the
+	// description of each DPAPI blob. The old files are removed only once all
+	// of the new ones are written.
+	var renamed []renamedFile
+	err = func() error {
+		for _, suffix := range []string{configFileUnencryptedSuffix, templateFileUnencryptedSuffix, templateValuesFileUnencryptedSuffix} {
+			oldPath, _ := s.path(oldName, suffix)
+			newPath, _ := s.path(newName, suffix)
+			err := s.renameFile(oldPath, oldName, newPath, newName, &renamed)
+			if err != nil && (suffix == configFileUnencryptedSuffix || !os.IsNotExist(err)) {
+				return err
+			}
 		}
-		_, err = FromWgQuickWithUnknownEncoding(string(bytes), "input")
-		if err != nil {
-			errs[e] = err
-			e++
-			continue
+		if len(numbers) == 0 {
+			return nil
 		}
-
-		bytes, err = dpapi.Encrypt(bytes, strings.TrimSuffix(name, configFileUnencryptedSuffix))
+		if isSameFile(oldHistoryDir, newHistoryDir) {
+			err := os.Rename(oldHistoryDir, newHistoryDir)
+			if err != nil {
+				return err
+			}
+			oldHistoryDir = newHistoryDir
+		}
+		err := os.MkdirAll(newHistoryDir, 0700)
 		if err != nil {
-			errs[e] = err
-			e++
-			continue
+			return err
 		}
-		dstFile := strings.TrimSuffix(path, configFileUnencryptedSuffix) + configFileSuffix
-		if _, err = os.Stat(dstFile); err != nil && !os.IsNotExist(err) {
-			errs[e] = errors.New("Unable to migrate to " + dstFile + " as it already exists")
-			e++
-			continue
+		for _, number := range numbers {
+			err = s.renameFile(s.revisionPath(oldHistoryDir, number), oldName, s.revisionPath(newHistoryDir, number), newName, &renamed)
+			if err != nil {
+				return err
+			}
 		}
-		err = ioutil.WriteFile(dstFile, bytes, 0600)
-		if err != nil {
-			errs[e] = err
-			e++
-			continue
+		return nil
+	}()
+	if err != nil {
+		for _, file := range renamed {
+			if file.oldPath != file.newPath {
+				os.Remove(file.newPath)
+			}
 		}
-		err = os.Remove(path)
-		if err != nil && os.Remove(dstFile) == nil {
-			errs[e] = err
-			e++
-			continue
+		return err
+	}
+	for _, file := range renamed {
+		if file.oldPath != file.newPath {
+			os.Remove(file.oldPath)
 		}
-		i++
 	}
-	return i, errs[:e]
+	if oldHistoryDir != newHistoryDir {
+		os.Remove(oldHistoryDir)
+	}
+	return nil
 }
 
-func LoadFromName(name string) (*Config, error) {
-	configFileDir, err := tunnelConfigurationsDirectory()
+func (s *directoryStore) SaveTemplate(name string, t *TunnelTemplate) error {
+	err := s.writeFile(name, templateFileUnencryptedSuffix, []byte(t.Text))
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return LoadFromPath(filepath.Join(configFileDir, name+configFileSuffix))
+	return s.writeFile(name, templateValuesFileUnencryptedSuffix, []byte(t.Values.String()))
 }
 
-func LoadFromPath(path string) (*Config, error) {
-	tunnelConfigurationsDirectory() // Provoke migrations, if needed.
-
-	name, err := NameFromPath(path)
+func (s *directoryStore) LoadTemplate(name string) (*TunnelTemplate, error) {
+	text, err := s.readFile(name, templateFileUnencryptedSuffix)
 	if err != nil {
 		return nil, err
 	}
-	bytes, err := ioutil.ReadFile(path)
+	values, err := s.readFile(name, templateValuesFileUnencryptedSuffix)
 	if err != nil {
 		return nil, err
 	}
-	if strings.HasSuffix(path, configFileSuffix) {
-		bytes, err = dpapi.Decrypt(bytes, name)
-		if err != nil {
-			return nil, err
-		}
+	t := &TunnelTemplate{Text: string(text)}
+	t.Values, err = ParseTemplateValues(string(values))
+	if err != nil {
+		return nil, err
 	}
-	return FromWgQuickWithUnknownEncoding(string(bytes), name)
+	return t, nil
 }
 
-func PathIsEncrypted(path string) bool {
-	return strings.HasSuffix(filepath.Base(path), configFileSuffix)
+func (s *directoryStore) historyDir(name string) (string, error) {
+	if !TunnelNameIsValid(name) {
+		return "", errors.New("Tunnel name is not valid")
+	}
+	dir, err := s.dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, historyDirectory, name), nil
 }
 
-func NameFromPath(path string) (string, error) {
-	name := filepath.Base(path)
-	if !((len(name) > len(configFileSuffix) && strings.HasSuffix(name, configFileSuffix)) ||
-		(len(name) > len(configFileUnencryptedSuffix) && strings.HasSuffix(name, configFileUnencryptedSuffix))) {
-		return "", errors.New("Path must end in either " + configFileSuffix + " or " + configFileUnencryptedSuffix)
+// revisionNumbers returns the numbers of the kept revisions, in ascending
+// order.
+func (s *directoryStore) revisionNumbers(historyDir string) ([]uint64, error) {
+	files, err := ioutil.ReadDir(historyDir)
+	if os.IsNotExist(err) {
+		return nil, nil
 	}
-	if strings.HasSuffix(path, configFileSuffix) {
-		name = strings.TrimSuffix(name, configFileSuffix)
-	} else {
-		name = strings.TrimSuffix(name, configFileUnencryptedSuffix)
+	if err != nil {
+		return nil, err
 	}
-	if !TunnelNameIsValid(name) {
-		return "", errors.New("Tunnel name is not valid")
+	suffix := s.suffix(revisionFileUnencryptedSuffix)
+	var numbers []uint64
+	for _, file := range files {
+		if !file.Mode().IsRegular() || !strings.HasSuffix(file.Name(), suffix) {
+			continue
+		}
+		number, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), suffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		numbers = append(numbers, number)
 	}
-	return name, nil
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers, nil
 }
 
-func (config *Config) Save() error {
-	if !TunnelNameIsValid(config.Name) {
-		return errors.New("Tunnel name is not valid")
+func (s *directoryStore) revisionPath(historyDir string, number uint64) string {
+	return filepath.Join(historyDir, strconv.FormatUint(number, 10)+s.suffix(revisionFileUnencryptedSuffix))
+}
+
+// addRevision keeps text as the newest revision, written like the
+// configuration itself, and so encrypted in the same way, since it holds the
+// same keys.
+func (s *directoryStore) addRevision(name string, text string, author string) error {
+	historyDir, err := s.historyDir(name)
+	if err != nil {
+		return err
 	}
-	configFileDir, err := tunnelConfigurationsDirectory()
+	err = os.MkdirAll(historyDir, 0700)
 	if err != nil {
 		return err
 	}
-	filename := filepath.Join(configFileDir, config.Name+configFileSuffix)
-	bytes := []byte(config.ToWgQuick())
-	bytes, err = dpapi.Encrypt(bytes, config.Name)
+	numbers, err := s.revisionNumbers(historyDir)
 	if err != nil {
 		return err
 	}
-	err = ioutil.WriteFile(filename+".tmp", bytes, 0600)
+	next := uint64(1)
+	if len(numbers) > 0 {
+		next = numbers[len(numbers)-1] + 1
+	}
+	data, err := json.Marshal(&storedRevision{time.Now(), author, text})
 	if err != nil {
 		return err
 	}
-	err = os.Rename(filename+".tmp", filename)
+	err = s.writePath(s.revisionPath(historyDir, next), name, data)
 	if err != nil {
-		os.Remove(filename + ".tmp")
 		return err
 	}
+	for len(numbers) >= MaxRevisions {
+		os.Remove(s.revisionPath(historyDir, numbers[0]))
+		numbers = numbers[1:]
+	}
 	return nil
 }
 
-func (config *Config) Path() (string, error) {
-	if !TunnelNameIsValid(config.Name) {
-		return "", errors.New("Tunnel name is not valid")
+func (s *directoryStore) PurgeRevisions(name string) error {
+	historyDir, err := s.historyDir(name)
+	if err != nil {
+		return err
 	}
-	configFileDir, err := tunnelConfigurationsDirectory()
+	numbers, err := s.revisionNumbers(historyDir)
 	if err != nil {
-		return "", err
+		return err
 	}
-	return filepath.Join(configFileDir, config.Name+configFileSuffix), nil
+	for len(numbers) > 1 {
+		err = os.Remove(s.revisionPath(historyDir, numbers[0]))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		numbers = numbers[1:]
+	}
+	return nil
 }
 
-func DeleteName(name string) error {
-	if !TunnelNameIsValid(name) {
-		return errors.New("Tunnel name is not valid")
+func (s *directoryStore) readRevision(name string, number uint64) (*storedRevision, error) {
+	historyDir, err := s.historyDir(name)
+	if err != nil {
+		return nil, err
 	}
-	configFileDir, err := tunnelConfigurationsDirectory()
+	data, err := s.readPath(s.revisionPath(historyDir, number), name)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	var revision storedRevision
+	err = json.Unmarshal(data, &revision)
+	if err != nil {
+		return nil, err
 	}
-	return os.Remove(filepath.Join(configFileDir, name+configFileSuffix))
+	return &revision, nil
 }
 
-func (config *Config) Delete() error {
-	return DeleteName(config.Name)
+func (s *directoryStore) Revisions(name string) ([]Revision, error) {
+	historyDir, err := s.historyDir(name)
+	if err != nil {
+		return nil, err
+	}
+	numbers, err := s.revisionNumbers(historyDir)
+	if err != nil {
+		return nil, err
+	}
+	revisions := make([]Revision, 0, len(numbers))
+	for i := len(numbers) - 1; i >= 0; i-- {
+		revision, err := s.readRevision(name, numbers[i])
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, Revision{numbers[i], revision.Time, revision.Author})
+	}
+	return revisions, nil
+}
+
+func (s *directoryStore) LoadRevision(name string, number uint64) (*Config, error) {
+	revision, err := s.readRevision(name, number)
+	if err != nil {
+		return nil, err
+	}
+	return FromWgQuick(revision.Text, name)
+}
+
+func LoadFromPath(path string) (*Config, error) {
+	tunnelConfigurationsDirectory() // Provoke migrations, if needed.
+
+	name, err := NameFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, configFileSuffix) {
+		bytes, err = decrypt(bytes, name)
+		if err != nil {
+			return nil, err
+		}
+	} else if strings.HasSuffix(path, configFileJSONSuffix) {
+		return FromJSON(bytes, name)
+	}
+	return FromWgQuickWithUnknownEncoding(string(bytes), name)
+}
+
+func PathIsEncrypted(path string) bool {
+	return strings.HasSuffix(filepath.Base(path), configFileSuffix)
+}
+
+func NameFromPath(path string) (string, error) {
+	name := filepath.Base(path)
+	if !((len(name) > len(configFileSuffix) && strings.HasSuffix(name, configFileSuffix)) ||
+		(len(name) > len(configFileUnencryptedSuffix) && strings.HasSuffix(name, configFileUnencryptedSuffix)) ||
+		(len(name) > len(configFileJSONSuffix) && strings.HasSuffix(name, configFileJSONSuffix))) {
+		return "", errors.New("Path must end in either " + configFileSuffix + ", " + configFileUnencryptedSuffix + " or " + configFileJSONSuffix)
+	}
+	if strings.HasSuffix(path, configFileSuffix) {
+		name = strings.TrimSuffix(name, configFileSuffix)
+	} else if strings.HasSuffix(path, configFileJSONSuffix) {
+		name = strings.TrimSuffix(name, configFileJSONSuffix)
+	} else {
+		name = strings.TrimSuffix(name, configFileUnencryptedSuffix)
+	}
+	if !TunnelNameIsValid(name) {
+		return "", errors.New("Tunnel name is not valid")
+	}
+	return name, nil
 }
@@ -0,0 +1,26 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"errors"
+)
+
+// This isn't a Linux program, yes, but having the conf package work across platforms is quite helpful for testing.
+
+// DefaultStore keeps configurations unencrypted in the tunnel configurations
+// directory, as there is no DPAPI to encrypt them with.
+var DefaultStore Store = &directoryStore{tunnelConfigurationsDirectory, false}
+
+var errNoDPAPI = errors.New("Encrypted configurations are only supported on Windows")
+
+func encrypt(data []byte, name string) ([]byte, error) {
+	return nil, errNoDPAPI
+}
+
+func decrypt(data []byte, name string) ([]byte, error) {
+	return nil, errNoDPAPI
+}
@@ -6,23 +6,50 @@
 package conf
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
 	"testing"
 )
 
+// forEachStore runs test against each kind of store, with the directory
+// stores in a fresh temporary directory. Encrypted stores are only tested on
+// Windows, where there is DPAPI to encrypt with.
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	dir, err := ioutil.TempDir("", "wireguard-store-test")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	if runtime.GOOS == "windows" {
+		encryptedDir := filepath.Join(dir, "encrypted")
+		t.Run("Encrypted", func(t *testing.T) {
+			test(t, &directoryStore{func() (string, error) { return encryptedDir, os.MkdirAll(encryptedDir, 0700) }, true})
+		})
+	}
+	t.Run("Directory", func(t *testing.T) { test(t, NewDirectoryStore(filepath.Join(dir, "configs"))) })
+	t.Run("Memory", func(t *testing.T) { test(t, NewMemoryStore()) })
+}
+
 func TestStorage(t *testing.T) {
+	forEachStore(t, testStorage)
+}
+
+func testStorage(t *testing.T, store Store) {
 	c, err := FromWgQuick(testInput, "golangTest")
 	if err != nil {
 		t.Errorf("Unable to parse test config: %s", err.Error())
 		return
 	}
 
-	err = c.Save()
+	err = store.Save(c, "")
 	if err != nil {
 		t.Errorf("Unable to save config: %s", err.Error())
 	}
 
-	configs, err := ListConfigNames()
+	configs, err := store.ListConfigNames()
 	if err != nil {
 		t.Errorf("Unable to list configs: %s", err.Error())
 	}
@@ -38,7 +65,7 @@ func TestStorage(t *testing.T) {
 		t.Error("Unable to find saved config in list")
 	}
 
-	loaded, err := LoadFromName("golangTest")
+	loaded, err := store.Load("golangTest")
 	if err != nil {
 		t.Errorf("Unable to load config: %s", err.Error())
 		return
@@ -54,12 +81,12 @@ func TestStorage(t *testing.T) {
 	}
 	c.Interface.PrivateKey = *k
 
-	err = c.Save()
+	err = store.Save(c, "")
 	if err != nil {
 		t.Errorf("Unable to save config a second time: %s", err.Error())
 	}
 
-	loaded, err = LoadFromName("golangTest")
+	loaded, err = store.Load("golangTest")
 	if err != nil {
 		t.Errorf("Unable to load config a second time: %s", err.Error())
 		return
@@ -69,12 +96,12 @@ func TestStorage(t *testing.T) {
 		t.Error("Second loaded config is not the same as second saved config")
 	}
 
-	err = DeleteName("golangTest")
+	err = store.Delete("golangTest")
 	if err != nil {
 		t.Errorf("Unable to delete config: %s", err.Error())
 	}
 
-	configs, err = ListConfigNames()
+	configs, err = store.ListConfigNames()
 	if err != nil {
 		t.Errorf("Unable to list configs: %s", err.Error())
 	}
@@ -89,3 +116,140 @@ func TestStorage(t *testing.T) {
 		t.Error("Config wasn't actually deleted")
 	}
 }
+
+func TestDirectoryStoreFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wireguard-store-test")
+	if !noError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	store := NewDirectoryStore(dir)
+	c, err := FromWgQuick(testInput, "golangTest")
+	if !noError(t, err) || !noError(t, store.Save(c, "")) {
+		return
+	}
+	text, err := ioutil.ReadFile(filepath.Join(dir, "golangTest.conf"))
+	if noError(t, err) {
+		equal(t, c.ToWgQuick(), string(text))
+	}
+	path, err := store.Path("golangTest")
+	if noError(t, err) {
+		equal(t, filepath.Join(dir, "golangTest.conf"), path)
+	}
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "ignored.txt"), nil, 0600))
+	configs, err := store.ListConfigNames()
+	if noError(t, err) {
+		equal(t, []string{"golangTest"}, configs)
+	}
+	if _, err = store.Load("missing"); !os.IsNotExist(err) {
+		t.Errorf("Loading a missing config should fail as not existing, not with %v", err)
+	}
+	if err = store.Save(&Config{Name: "not/valid"}, ""); err == nil {
+		t.Error("Saving a config with an invalid name should fail")
+	}
+
+	// A configuration that can't be written leaves no revision behind.
+	noError(t, os.Mkdir(filepath.Join(dir, "golangBlocked.conf"), 0700))
+	c.Name = "golangBlocked"
+	if err = store.Save(c, ""); err == nil {
+		t.Error("Saving over a directory should fail")
+	}
+	revisions, err := store.Revisions("golangBlocked")
+	if noError(t, err) {
+		lenTest(t, revisions, 0)
+	}
+}
+
+func TestMemoryStoreIsolation(t *testing.T) {
+	store := NewMemoryStore()
+	c, err := FromWgQuick(testInput, "golangTest")
+	if !noError(t, err) || !noError(t, store.Save(c, "")) {
+		return
+	}
+	c.Peers = nil
+	loaded, err := store.Load("golangTest")
+	if noError(t, err) {
+		lenTest(t, loaded.Peers, 3)
+	}
+	template := &TunnelTemplate{"[Interface]\n", TemplateValues{"Key": "Value"}}
+	noError(t, store.SaveTemplate("golangTest", template))
+	template.Values["Key"] = "Changed"
+	loadedTemplate, err := store.LoadTemplate("golangTest")
+	if noError(t, err) {
+		equal(t, "Value", loadedTemplate.Values["Key"])
+	}
+	if err = store.Delete("missing"); !os.IsNotExist(err) {
+		t.Errorf("Deleting a missing config should fail as not existing, not with %v", err)
+	}
+	if _, err = store.Path("golangTest"); err == nil {
+		t.Error("Configurations kept in memory should have no path")
+	}
+}
+
+func TestRename(t *testing.T) {
+	forEachStore(t, testRename)
+}
+
+func testRename(t *testing.T, store Store) {
+	defer store.Delete("golangRenamed")
+	defer store.Delete("golangRenameOther")
+	c, err := FromWgQuick(testInput, "golangRenameTest")
+	if !noError(t, err) || !noError(t, store.Save(c, "someone")) || !noError(t, store.Save(c, "someone")) {
+		return
+	}
+	template := &TunnelTemplate{testTemplate, TemplateValues{"Address": "10.0.0.42/32"}}
+	noError(t, store.SaveTemplate("golangRenameTest", template))
+	other, err := FromWgQuick(testInput, "golangRenameOther")
+	if !noError(t, err) || !noError(t, store.Save(other, "")) {
+		return
+	}
+
+	if err = store.Rename("golangRenameTest", "GOLANGRENAMEOTHER"); err == nil {
+		t.Error("Renaming onto another tunnel's name in a different case should fail")
+	}
+	if !noError(t, store.Rename("golangRenameTest", "golangRenamed")) {
+		return
+	}
+	if _, err = store.Load("golangRenameTest"); !os.IsNotExist(err) {
+		t.Errorf("Old name should no longer exist, but loading it gave %v", err)
+	}
+	loaded, err := store.Load("golangRenamed")
+	if noError(t, err) {
+		equal(t, "golangRenamed", loaded.Name)
+		equal(t, c.Interface.PrivateKey, loaded.Interface.PrivateKey)
+	}
+	loadedTemplate, err := store.LoadTemplate("golangRenamed")
+	if noError(t, err) {
+		equal(t, template, loadedTemplate)
+	}
+	revisions, err := store.Revisions("golangRenamed")
+	if !noError(t, err) || !lenTest(t, revisions, 2) {
+		return
+	}
+	oldRevisions, err := store.Revisions("golangRenameTest")
+	if noError(t, err) {
+		lenTest(t, oldRevisions, 0)
+	}
+
+	// Changing only the case of the name is allowed.
+	if !noError(t, store.Rename("golangRenamed", "GolangRenamed")) {
+		return
+	}
+	configs, err := store.ListConfigNames()
+	if noError(t, err) {
+		found := false
+		for _, name := range configs {
+			if name == "golangRenamed" {
+				t.Error("Old case of the name is still listed")
+			}
+			found = found || name == "GolangRenamed"
+		}
+		if !found {
+			t.Error("New case of the name is not listed")
+		}
+	}
+	if noError(t, store.Rename("GolangRenamed", "golangRenamed")) {
+		_, err = store.LoadRevision("golangRenamed", revisions[0].Number)
+		noError(t, err)
+	}
+}
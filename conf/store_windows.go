@@ -0,0 +1,101 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/windows/conf/dpapi"
+)
+
+// DefaultStore keeps configurations encrypted with DPAPI in the tunnel
+// configurations directory, migrating unencrypted ones placed there.
+var DefaultStore Store = &directoryStore{tunnelConfigurationsDirectory, true}
+
+func encrypt(data []byte, name string) ([]byte, error) {
+	return dpapi.Encrypt(data, name)
+}
+
+func decrypt(data []byte, name string) ([]byte, error) {
+	return dpapi.Decrypt(data, name)
+}
+
+func MigrateUnencryptedConfigs() (int, []error) {
+	configFileDir, err := tunnelConfigurationsDirectory()
+	if err != nil {
+		return 0, []error{err}
+	}
+	files, err := ioutil.ReadDir(configFileDir)
+	if err != nil {
+		return 0, []error{err}
+	}
+	errs := make([]error, len(files))
+	i := 0
+	e := 0
+	for _, file := range files {
+		path := filepath.Join(configFileDir, file.Name())
+		name := filepath.Base(file.Name())
+		if len(name) <= len(configFileUnencryptedSuffix) || !strings.HasSuffix(name, configFileUnencryptedSuffix) {
+			continue
+		}
+		if !file.Mode().IsRegular() || file.Mode().Perm()&0444 == 0 {
+			continue
+		}
+
+		// We don't use ioutil's ReadFile, because we actually want RDWR, so that we can take advantage
+		// of Windows file locking for ensuring the file is finished being written.
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			errs[e] = err
+			e++
+			continue
+		}
+		bytes, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			errs[e] = err
+			e++
+			continue
+		}
+		_, err = FromWgQuickWithUnknownEncoding(string(bytes), "input")
+		if err != nil {
+			errs[e] = err
+			e++
+			continue
+		}
+
+		bytes, err = encrypt(bytes, strings.TrimSuffix(name, configFileUnencryptedSuffix))
+		if err != nil {
+			errs[e] = err
+			e++
+			continue
+		}
+		dstFile := strings.TrimSuffix(path, configFileUnencryptedSuffix) + configFileSuffix
+		if _, err = os.Stat(dstFile); err != nil && !os.IsNotExist(err) {
+			errs[e] = errors.New("Unable to migrate to " + dstFile + " as it already exists")
+			e++
+			continue
+		}
+		err = ioutil.WriteFile(dstFile, bytes, 0600)
+		if err != nil {
+			errs[e] = err
+			e++
+			continue
+		}
+		err = os.Remove(path)
+		if err != nil && os.Remove(dstFile) == nil {
+			errs[e] = err
+			e++
+			continue
+		}
+		i++
+	}
+	return i, errs[:e]
+}
@@ -0,0 +1,11 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+// Changes to the configurations directory aren't watched for here, so
+// callbacks are only called when they are registered.
+func startWatchingConfigDir() {
+}
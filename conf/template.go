@@ -0,0 +1,226 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const maxTemplateIncludeDepth = 8
+
+// TunnelTemplate is a configuration in wg-quick format written as a Go text
+// template, together with the values of one instance of it. Values are
+// available to the template as {{.Key}}, and the functions NewPrivateKey,
+// NewPresharedKey and PublicKey generate and derive keys.
+type TunnelTemplate struct {
+	Text   string
+	Values TemplateValues
+}
+
+// TemplateValues holds the values of one instance of a template, read from
+// and written as "Key = Value" lines.
+type TemplateValues map[string]string
+
+// TemplateIncluder returns the text of a file named by an %include line.
+type TemplateIncluder func(name string) (string, error)
+
+func isTemplateValueKey(key string) bool {
+	if len(key) == 0 {
+		return false
+	}
+	for i, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseTemplateValues reads instance values, one "Key = Value" per line.
+// Blank lines and lines starting with '#' are ignored.
+func ParseTemplateValues(s string) (TemplateValues, error) {
+	values := make(TemplateValues)
+	for _, line := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		equals := strings.IndexByte(line, '=')
+		if equals < 0 {
+			return nil, &ParseError{"Value lines must be of the form 'Key = Value'", line}
+		}
+		key, value := strings.TrimSpace(line[:equals]), strings.TrimSpace(line[equals+1:])
+		if !isTemplateValueKey(key) {
+			return nil, &ParseError{"Invalid value name", key}
+		}
+		if _, ok := values[key]; ok {
+			return nil, &ParseError{"Value given more than once", key}
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// String returns the values in the form read by ParseTemplateValues, sorted
+// by key.
+func (values TemplateValues) String() string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var output strings.Builder
+	for _, key := range keys {
+		output.WriteString(fmt.Sprintf("%s = %s\n", key, values[key]))
+	}
+	return output.String()
+}
+
+// DirectoryIncluder returns an includer that reads files relative to dir.
+func DirectoryIncluder(dir string) TemplateIncluder {
+	return func(name string) (string, error) {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		bytes, err := ioutil.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		return string(bytes), nil
+	}
+}
+
+func includeName(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed != "%include" && !strings.HasPrefix(trimmed, "%include ") && !strings.HasPrefix(trimmed, "%include\t") {
+		return "", false
+	}
+	name := strings.TrimSpace(trimmed[len("%include"):])
+	if len(name) >= 2 && name[0] == '"' && name[len(name)-1] == '"' {
+		name = name[1 : len(name)-1]
+	}
+	return name, true
+}
+
+// ResolveTemplateIncludes replaces each "%include name" line of the template
+// with the text of the named file, recursively, so that the result stands on
+// its own.
+func ResolveTemplateIncludes(text string, include TemplateIncluder) (string, error) {
+	return resolveTemplateIncludes(text, include, nil)
+}
+
+func resolveTemplateIncludes(text string, include TemplateIncluder, stack []string) (string, error) {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		name, ok := includeName(line)
+		if !ok {
+			continue
+		}
+		if len(name) == 0 {
+			return "", &ParseError{"An include must name a file", line}
+		}
+		if include == nil {
+			return "", &ParseError{"Includes are not available here", name}
+		}
+		for _, outer := range stack {
+			if outer == name {
+				return "", &ParseError{"File includes itself", name}
+			}
+		}
+		if len(stack) >= maxTemplateIncludeDepth {
+			return "", &ParseError{"Includes are nested too deeply", name}
+		}
+		included, err := include(name)
+		if err != nil {
+			return "", fmt.Errorf("Unable to include %q: %v", name, err)
+		}
+		included, err = resolveTemplateIncludes(strings.TrimSuffix(strings.ReplaceAll(included, "\r\n", "\n"), "\n"), include, append(stack, name))
+		if err != nil {
+			return "", err
+		}
+		lines[i] = included
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// keyFunc returns a template function that yields the key stored under the
+// value named by its argument, generating and storing one if there is none,
+// so that expanding the template again gives the same key.
+func (values TemplateValues) keyFunc(generate func() (*Key, error)) func(string) (string, error) {
+	return func(name string) (string, error) {
+		if !isTemplateValueKey(name) {
+			return "", &ParseError{"Invalid value name", name}
+		}
+		if existing, ok := values[name]; ok {
+			k, err := parseKeyBase64(existing)
+			if err != nil {
+				return "", err
+			}
+			return k.String(), nil
+		}
+		k, err := generate()
+		if err != nil {
+			return "", err
+		}
+		values[name] = k.String()
+		return values[name], nil
+	}
+}
+
+// Expand turns the template into the configuration of the tunnel called name.
+// Includes must already have been resolved. Keys made by NewPrivateKey and
+// NewPresharedKey are added to t.Values, which should be stored along with
+// the template so that later expansions are identical.
+func (t *TunnelTemplate) Expand(name string) (*Config, error) {
+	text, err := t.expandText(name)
+	if err != nil {
+		return nil, err
+	}
+	return FromWgQuick(text, name)
+}
+
+func (t *TunnelTemplate) expandText(name string) (string, error) {
+	text, err := ResolveTemplateIncludes(t.Text, nil)
+	if err != nil {
+		return "", err
+	}
+	if t.Values == nil {
+		t.Values = make(TemplateValues)
+	}
+	for key, value := range t.Values {
+		if !isTemplateValueKey(key) {
+			return "", &ParseError{"Invalid value name", key}
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return "", &ParseError{fmt.Sprintf("Invalid value for %s", key), value}
+		}
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(template.FuncMap{
+		"NewPrivateKey":   t.Values.keyFunc(NewPrivateKey),
+		"NewPresharedKey": t.Values.keyFunc(NewPresharedKey),
+		"PublicKey": func(private string) (string, error) {
+			k, err := parseKeyBase64(private)
+			if err != nil {
+				return "", err
+			}
+			return k.Public().String(), nil
+		},
+	}).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var output strings.Builder
+	err = tmpl.Execute(&output, map[string]string(t.Values))
+	if err != nil {
+		return "", err
+	}
+	return output.String(), nil
+}
@@ -0,0 +1,149 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package conf
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testTemplate = `[Interface]
+PrivateKey = {{NewPrivateKey "InterfaceKey"}}
+Address = {{.Address}}
+DNS = 10.0.0.1
+
+%include "hub.peer"`
+
+var testIncludes = map[string]string{
+	"hub.peer": "[Peer]\r\nPublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=\r\nPresharedKey = {{NewPresharedKey \"HubPSK\"}}\r\n%include routes\r\nEndpoint = {{.Endpoint}}\r\n",
+	"routes":   "AllowedIPs = 10.0.0.0/8",
+	"loop":     "%include \"loop\"",
+}
+
+func mapIncluder(files map[string]string) TemplateIncluder {
+	return func(name string) (string, error) {
+		text, ok := files[name]
+		if !ok {
+			return "", errors.New("no such file")
+		}
+		return text, nil
+	}
+}
+
+func TestTemplateValues(t *testing.T) {
+	values, err := ParseTemplateValues("# Instance 42\r\nEndpoint = vpn.example.com:51820\r\n\r\nAddress=10.0.0.42/32\r\n")
+	if !noError(t, err) {
+		return
+	}
+	equal(t, TemplateValues{"Address": "10.0.0.42/32", "Endpoint": "vpn.example.com:51820"}, values)
+	equal(t, "Address = 10.0.0.42/32\nEndpoint = vpn.example.com:51820\n", values.String())
+	reparsed, err := ParseTemplateValues(values.String())
+	if noError(t, err) {
+		equal(t, values, reparsed)
+	}
+	for _, invalid := range []string{"Address", "1Address = x", "Add-ress = x", "A = 1\nA = 2"} {
+		if _, err = ParseTemplateValues(invalid); err == nil {
+			t.Errorf("Error was expected for %q", invalid)
+		}
+	}
+}
+
+func TestTemplateIncludes(t *testing.T) {
+	text, err := ResolveTemplateIncludes(testTemplate, mapIncluder(testIncludes))
+	if !noError(t, err) {
+		return
+	}
+	if strings.Contains(text, "%include") || !strings.Contains(text, "\nAllowedIPs = 10.0.0.0/8\nEndpoint") {
+		t.Errorf("Includes not resolved:\n%s", text)
+	}
+	for _, invalid := range []string{"%include loop", "%include missing", "%include"} {
+		if _, err = ResolveTemplateIncludes(invalid, mapIncluder(testIncludes)); err == nil {
+			t.Errorf("Error was expected for %q", invalid)
+		}
+	}
+	if _, err = ResolveTemplateIncludes(testTemplate, nil); err == nil {
+		t.Error("Error was expected for an include without an includer")
+	}
+	text, err = ResolveTemplateIncludes("%includes are not directives", nil)
+	if noError(t, err) {
+		equal(t, "%includes are not directives", text)
+	}
+}
+
+func TestTemplateExpand(t *testing.T) {
+	text, err := ResolveTemplateIncludes(testTemplate, mapIncluder(testIncludes))
+	if !noError(t, err) {
+		return
+	}
+	template := &TunnelTemplate{text, TemplateValues{"Address": "10.0.0.42/32", "Endpoint": "192.0.2.1:51820"}}
+	c, err := template.Expand("instance42")
+	if !noError(t, err) {
+		return
+	}
+	equal(t, "instance42", c.Name)
+	equal(t, "10.0.0.42/32", c.Interface.Addresses[0].String())
+	equal(t, "192.0.2.1:51820", c.Peers[0].Endpoint.String())
+	equal(t, c.Interface.PrivateKey.String(), template.Values["InterfaceKey"])
+	equal(t, c.Peers[0].PresharedKey.String(), template.Values["HubPSK"])
+
+	// Expanding again, such as from stored values, gives the same result.
+	stored, err := ParseTemplateValues(template.Values.String())
+	if !noError(t, err) {
+		return
+	}
+	again, err := (&TunnelTemplate{text, stored}).Expand("instance42")
+	if noError(t, err) {
+		equal(t, c.ToWgQuick(), again.ToWgQuick())
+	}
+
+	other, err := (&TunnelTemplate{text, TemplateValues{"Address": "10.0.0.43/32", "Endpoint": "192.0.2.1:51820"}}).Expand("instance43")
+	if noError(t, err) && reflect.DeepEqual(other.Interface.PrivateKey, c.Interface.PrivateKey) {
+		t.Error("Different instances got the same generated key")
+	}
+
+	public, err := (&TunnelTemplate{`{{PublicKey "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="}}`, nil}).expandText("x")
+	if noError(t, err) {
+		equal(t, "HIgo9xNzJMWLKASShiTqIybxZ0U3wGLiUeJ1PKf8ykw=", public)
+	}
+
+	for _, values := range []TemplateValues{
+		{"Address": "10.0.0.42/32"},
+		{"Address": "10.0.0.42/32\n[Peer]", "Endpoint": "192.0.2.1:51820"},
+		{"Address": "10.0.0.42/32", "Endpoint": "192.0.2.1:51820", "InterfaceKey": "not a key"},
+		{"Address": "not an address", "Endpoint": "192.0.2.1:51820"},
+	} {
+		if _, err = (&TunnelTemplate{text, values}).Expand("instance"); err == nil {
+			t.Errorf("Error was expected for %v", values)
+		}
+	}
+}
+
+func TestTemplateStorage(t *testing.T) {
+	forEachStore(t, testTemplateStorage)
+}
+
+func testTemplateStorage(t *testing.T, store Store) {
+	template := &TunnelTemplate{testTemplate, TemplateValues{"Address": "10.0.0.42/32"}}
+	err := store.SaveTemplate("golangTemplateTest", template)
+	if !noError(t, err) {
+		return
+	}
+	loaded, err := store.LoadTemplate("golangTemplateTest")
+	if noError(t, err) {
+		equal(t, template, loaded)
+	}
+	c, err := FromWgQuick(testInput, "golangTemplateTest")
+	if !noError(t, err) {
+		return
+	}
+	noError(t, store.Save(c, ""))
+	noError(t, store.Delete("golangTemplateTest"))
+	if _, err = store.LoadTemplate("golangTemplateTest"); err == nil {
+		t.Error("Template should be deleted along with the tunnel")
+	}
+}
@@ -6,69 +6,213 @@
 package conf
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 )
 
-func (conf *Config) ToWgQuick() string {
-	var output strings.Builder
-	output.WriteString("[Interface]\n")
+type fieldKind int
 
-	output.WriteString(fmt.Sprintf("PrivateKey = %s\n", conf.Interface.PrivateKey.String()))
+const (
+	fieldSingle fieldKind = iota
+	fieldList
+	fieldRepeated
+)
 
-	if conf.Interface.ListenPort > 0 {
-		output.WriteString(fmt.Sprintf("ListenPort = %d\n", conf.Interface.ListenPort))
-	}
+type field struct {
+	key             string
+	kind            fieldKind
+	annotation      bool
+	secret          bool
+	interfaceValues func(*Interface) []string
+	peerValues      func(*Peer) []string
+}
 
-	if len(conf.Interface.Addresses) > 0 {
-		addrStrings := make([]string, len(conf.Interface.Addresses))
-		for i, address := range conf.Interface.Addresses {
-			addrStrings[i] = address.String()
-		}
-		output.WriteString(fmt.Sprintf("Address = %s\n", strings.Join(addrStrings[:], ", ")))
+func cidrStrings(cidrs []IPCidr) []string {
+	out := make([]string, len(cidrs))
+	for i := range cidrs {
+		out[i] = cidrs[i].String()
 	}
+	return out
+}
 
-	if len(conf.Interface.DNS) > 0 {
-		addrStrings := make([]string, len(conf.Interface.DNS))
-		for i, address := range conf.Interface.DNS {
-			addrStrings[i] = address.String()
-		}
-		output.WriteString(fmt.Sprintf("DNS = %s\n", strings.Join(addrStrings[:], ", ")))
+func nonEmpty(s string) []string {
+	if len(s) == 0 {
+		return nil
 	}
+	return []string{s}
+}
 
-	if conf.Interface.MTU > 0 {
-		output.WriteString(fmt.Sprintf("MTU = %d\n", conf.Interface.MTU))
+func nonZero(i uint64) []string {
+	if i == 0 {
+		return nil
 	}
+	return []string{strconv.FormatUint(i, 10)}
+}
 
-	for _, peer := range conf.Peers {
-		output.WriteString("\n[Peer]\n")
-
-		output.WriteString(fmt.Sprintf("PublicKey = %s\n", peer.PublicKey.String()))
+var interfaceFields = []field{
+	{key: "PrivateKey", secret: true, interfaceValues: func(iface *Interface) []string { return []string{iface.PrivateKey.String()} }},
+	{key: "ListenPort", interfaceValues: func(iface *Interface) []string { return nonZero(uint64(iface.ListenPort)) }},
+	{key: "Address", kind: fieldList, interfaceValues: func(iface *Interface) []string { return cidrStrings(iface.Addresses) }},
+	{key: "DNS", kind: fieldList, interfaceValues: func(iface *Interface) []string {
+		out := make([]string, len(iface.DNS), len(iface.DNS)+len(iface.DNSSearch))
+		for i := range iface.DNS {
+			out[i] = iface.DNS[i].String()
+		}
+		return append(out, iface.DNSSearch...)
+	}},
+	{key: "Resolver", annotation: true, interfaceValues: func(iface *Interface) []string { return nonEmpty(iface.Resolver) }},
+	{key: "MTU", interfaceValues: func(iface *Interface) []string { return nonZero(uint64(iface.MTU)) }},
+	{key: "Table", interfaceValues: func(iface *Interface) []string { return nonEmpty(iface.Table) }},
+	{key: "FwMark", interfaceValues: func(iface *Interface) []string { return nonZero(uint64(iface.FwMark)) }},
+	{key: "SaveConfig", interfaceValues: func(iface *Interface) []string {
+		if !iface.SaveConfig {
+			return nil
+		}
+		return []string{"true"}
+	}},
+	{key: "PreUp", kind: fieldRepeated, interfaceValues: func(iface *Interface) []string { return iface.PreUp }},
+	{key: "PostUp", kind: fieldRepeated, interfaceValues: func(iface *Interface) []string { return iface.PostUp }},
+	{key: "PreDown", kind: fieldRepeated, interfaceValues: func(iface *Interface) []string { return iface.PreDown }},
+	{key: "PostDown", kind: fieldRepeated, interfaceValues: func(iface *Interface) []string { return iface.PostDown }},
+}
 
-		if !peer.PresharedKey.IsZero() {
-			output.WriteString(fmt.Sprintf("PresharedKey = %s\n", peer.PresharedKey.String()))
+var peerFields = []field{
+	{key: "PublicKey", peerValues: func(peer *Peer) []string { return []string{peer.PublicKey.String()} }},
+	{key: "PresharedKey", secret: true, peerValues: func(peer *Peer) []string {
+		if peer.PresharedKey.IsZero() {
+			return nil
+		}
+		return []string{peer.PresharedKey.String()}
+	}},
+	{key: "AllowedIPs", kind: fieldList, peerValues: func(peer *Peer) []string { return cidrStrings(peer.AllowedIPs) }},
+	{key: "ExcludedIPs", kind: fieldList, annotation: true, peerValues: func(peer *Peer) []string { return cidrStrings(peer.ExcludedIPs) }},
+	{key: "Endpoint", peerValues: func(peer *Peer) []string {
+		if peer.Endpoint.IsEmpty() {
+			return nil
 		}
+		return []string{peer.Endpoint.String()}
+	}},
+	{key: "PersistentKeepalive", peerValues: func(peer *Peer) []string { return nonZero(uint64(peer.PersistentKeepalive)) }},
+}
 
-		if len(peer.AllowedIPs) > 0 {
-			addrStrings := make([]string, len(peer.AllowedIPs))
-			for i, address := range peer.AllowedIPs {
-				addrStrings[i] = address.String()
+var annotationReplacer = strings.NewReplacer("\r", " ", "\n", " ")
+
+// peerAnnotationFields returns the fields for the name and for each metadata
+// key of any of the given peers, with metadata keys compared case-insensitively.
+func peerAnnotationFields(peers ...*Peer) []field {
+	fields := []field{{key: "Name", annotation: true, peerValues: func(peer *Peer) []string {
+		return nonEmpty(annotationReplacer.Replace(peer.Name))
+	}}}
+	seen := make(map[string]bool)
+	var keys []string
+	for _, peer := range peers {
+		for key := range peer.Metadata {
+			if lower := strings.ToLower(key); !seen[lower] && isMetadataKey(key) {
+				seen[lower] = true
+				keys = append(keys, key)
 			}
-			output.WriteString(fmt.Sprintf("AllowedIPs = %s\n", strings.Join(addrStrings[:], ", ")))
 		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		lower := strings.ToLower(key)
+		fields = append(fields, field{key: MetadataPrefix + key, annotation: true, peerValues: func(peer *Peer) []string {
+			for k, v := range peer.Metadata {
+				if strings.ToLower(k) == lower {
+					return nonEmpty(annotationReplacer.Replace(v))
+				}
+			}
+			return nil
+		}})
+	}
+	return fields
+}
 
-		if !peer.Endpoint.IsEmpty() {
-			output.WriteString(fmt.Sprintf("Endpoint = %s\n", peer.Endpoint.String()))
+// redacted replaces the values of secret fields with placeholders when
+// redact is set.
+func (f *field) redacted(values []string, redact bool) []string {
+	if !redact || !f.secret {
+		return values
+	}
+	out := make([]string, len(values))
+	for i, value := range values {
+		k, err := parseKeyBase64(value)
+		if err != nil {
+			out[i] = "(redacted)"
+			continue
 		}
+		out[i] = k.Redacted()
+	}
+	return out
+}
+
+func writePeer(output *strings.Builder, peer *Peer, redact bool) {
+	writeFields(output, append(peerAnnotationFields(peer), peerFields...), func(f *field) []string { return f.redacted(f.peerValues(peer), redact) })
+}
 
-		if peer.PersistentKeepalive > 0 {
-			output.WriteString(fmt.Sprintf("PersistentKeepalive = %d\n", peer.PersistentKeepalive))
+func writeFields(output *strings.Builder, fields []field, values func(*field) []string) {
+	for i := range fields {
+		f := &fields[i]
+		v := values(f)
+		if len(v) == 0 {
+			continue
+		}
+		if f.kind == fieldList {
+			v = []string{strings.Join(v, ", ")}
+		}
+		for _, value := range v {
+			if f.annotation {
+				output.WriteString("# ")
+			}
+			output.WriteString(fmt.Sprintf("%s = %s\n", f.key, value))
 		}
 	}
+}
+
+// ToWgQuick returns the configuration in wg-quick format. If the configuration
+// was parsed from a document, the document is updated in place of generating
+// new text, so that comments and formatting are kept.
+func (conf *Config) ToWgQuick() string {
+	return conf.toWgQuick(false)
+}
+
+// ToWgQuickRedacted is like ToWgQuick, but replaces private and preshared
+// keys with a placeholder holding their fingerprint, so that the output can
+// be shared without leaking secrets.
+func (conf *Config) ToWgQuickRedacted() string {
+	return conf.toWgQuick(true)
+}
+
+func (conf *Config) toWgQuick(redact bool) string {
+	if conf.Document != nil {
+		return conf.Document.update(conf, redact).String()
+	}
+	var output strings.Builder
+	output.WriteString("[Interface]\n")
+	writeFields(&output, interfaceFields, func(f *field) []string { return f.redacted(f.interfaceValues(&conf.Interface), redact) })
+	for i := range conf.Peers {
+		output.WriteString("\n[Peer]\n")
+		writePeer(&output, &conf.Peers[i], redact)
+	}
 	return output.String()
 }
 
 func (conf *Config) ToUAPI() (uapi string, dnsErr error) {
+	resolver, dnsErr := NewResolver(conf.Interface.Resolver)
+	if dnsErr != nil {
+		return
+	}
+	resolved, dnsErr := (&EndpointResolver{Resolver: resolver}).ResolveConfig(context.Background(), conf)
+	if dnsErr != nil {
+		return
+	}
+	return resolved.toUAPI(), nil
+}
+
+func (conf *Config) toUAPI() string {
 	var output strings.Builder
 	output.WriteString(fmt.Sprintf("private_key=%s\n", conf.Interface.PrivateKey.HexString()))
 
@@ -76,6 +220,10 @@ func (conf *Config) ToUAPI() (uapi string, dnsErr error) {
 		output.WriteString(fmt.Sprintf("listen_port=%d\n", conf.Interface.ListenPort))
 	}
 
+	if conf.Interface.FwMark > 0 {
+		output.WriteString(fmt.Sprintf("fwmark=%d\n", conf.Interface.FwMark))
+	}
+
 	if len(conf.Peers) > 0 {
 		output.WriteString("replace_peers=true\n")
 	}
@@ -88,23 +236,17 @@ func (conf *Config) ToUAPI() (uapi string, dnsErr error) {
 		}
 
 		if !peer.Endpoint.IsEmpty() {
-			var resolvedIP string
-			resolvedIP, dnsErr = resolveHostname(peer.Endpoint.Host)
-			if dnsErr != nil {
-				return
-			}
-			resolvedEndpoint := Endpoint{resolvedIP, peer.Endpoint.Port}
-			output.WriteString(fmt.Sprintf("endpoint=%s\n", resolvedEndpoint.String()))
+			output.WriteString(fmt.Sprintf("endpoint=%s\n", peer.Endpoint.String()))
 		}
 
 		output.WriteString(fmt.Sprintf("persistent_keepalive_interval=%d\n", peer.PersistentKeepalive))
 
-		if len(peer.AllowedIPs) > 0 {
+		if allowedIPs := peer.EffectiveAllowedIPs(); len(allowedIPs) > 0 {
 			output.WriteString("replace_allowed_ips=true\n")
-			for _, address := range peer.AllowedIPs {
+			for _, address := range allowedIPs {
 				output.WriteString(fmt.Sprintf("allowed_ip=%s\n", address.String()))
 			}
 		}
 	}
-	return output.String(), nil
+	return output.String()
 }
@@ -14,6 +14,7 @@ import (
 
 	"golang.org/x/sys/windows"
 
+	"golang.zx2c4.com/wireguard/windows/conf"
 	"golang.zx2c4.com/wireguard/windows/elevate"
 	"golang.zx2c4.com/wireguard/windows/manager"
 	"golang.zx2c4.com/wireguard/windows/ringlogger"
@@ -31,6 +32,7 @@ var flags = [...]string{
 	"/tunnelservice CONFIG_PATH",
 	"/ui CMD_READ_HANDLE CMD_WRITE_HANDLE CMD_EVENT_HANDLE LOG_MAPPING_HANDLE",
 	"/dumplog OUTPUT_PATH",
+	"/lint CONFIG_PATH",
 }
 
 func fatal(v ...interface{}) {
@@ -154,7 +156,7 @@ func main() {
 		if len(os.Args) != 2 {
 			usage()
 		}
-		err := manager.Run()
+		err := manager.Run(conf.DefaultStore)
 		if err != nil {
 			fatal(err)
 		}
@@ -181,7 +183,7 @@ func main() {
 		if len(os.Args) != 3 {
 			usage()
 		}
-		err := tunnel.Run(os.Args[2])
+		err := tunnel.Run(os.Args[2], conf.DefaultStore)
 		if err != nil {
 			fatal(err)
 		}
@@ -210,6 +212,7 @@ func main() {
 		if err != nil {
 			fatal(err)
 		}
+		ringlogger.Global.SetFilter(conf.RedactKeys)
 		manager.InitializeIPCClient(readPipe, writePipe, eventPipe)
 		ui.RunUI()
 		return
@@ -227,6 +230,28 @@ func main() {
 			fatal(err)
 		}
 		return
+	case "/lint":
+		if len(os.Args) != 3 {
+			usage()
+		}
+		config, err := conf.LoadFromPath(os.Args[2])
+		if err != nil {
+			fatal(err)
+		}
+		findings := conf.Lint(config)
+		if len(findings) == 0 {
+			info("Lint", "No problems were found in ‘%s’.", config.Name)
+			return
+		}
+		builder := strings.Builder{}
+		for i := range findings {
+			builder.WriteString(fmt.Sprintf("%s\n", findings[i].String()))
+		}
+		info("Lint", "Problems were found in ‘%s’:\n\n%s", config.Name, builder.String())
+		if conf.HasErrorFindings(findings) {
+			os.Exit(1)
+		}
+		return
 	}
 	usage()
 }
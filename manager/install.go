@@ -152,6 +152,9 @@ func InstallTunnel(configPath string) error {
 		}
 	}
 
+	// A failure left by an earlier run would otherwise be blamed on this one.
+	services.TakeFailure(name)
+
 	config := mgr.Config{
 		ServiceType:  windows.SERVICE_WIN32_OWN_PROCESS,
 		StartType:    mgr.StartAutomatic,
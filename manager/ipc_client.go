@@ -10,8 +10,11 @@ import (
 	"errors"
 	"net/rpc"
 	"os"
+	"time"
 
 	"golang.zx2c4.com/wireguard/windows/conf"
+	"golang.zx2c4.com/wireguard/windows/metrics"
+	"golang.zx2c4.com/wireguard/windows/services"
 	"golang.zx2c4.com/wireguard/windows/updater"
 )
 
@@ -19,6 +22,64 @@ type Tunnel struct {
 	Name string
 }
 
+// TemplateRequest asks for a tunnel called Name to be made from a template,
+// whose includes must already be resolved.
+type TemplateRequest struct {
+	Name     string
+	Template conf.TunnelTemplate
+}
+
+// ImportRequest asks for the configurations in Files to be imported, settling
+// name conflicts according to Policy.
+type ImportRequest struct {
+	Files  []conf.ImportFile
+	Policy conf.ConflictPolicy
+}
+
+// RenameRequest asks for the tunnel called Name to be renamed or duplicated
+// as NewName.
+type RenameRequest struct {
+	Name    string
+	NewName string
+}
+
+// RevisionRequest names one revision of a tunnel's configuration.
+type RevisionRequest struct {
+	Name   string
+	Number uint64
+}
+
+// RevisionDiffRequest asks for the differences between two revisions of a
+// tunnel's configuration.
+type RevisionDiffRequest struct {
+	Name     string
+	From, To uint64
+}
+
+// StatsSubscriptionRequest asks for the peer stats of the tunnel called Name
+// to be sent every Interval, or no longer if Interval is zero.
+type StatsSubscriptionRequest struct {
+	Name     string
+	Interval time.Duration
+}
+
+// HistoryRequest asks for the peer history of the tunnel called Name over
+// Period.
+type HistoryRequest struct {
+	Name   string
+	Period metrics.Period
+}
+
+// KeyRotationRequest asks for new keys for the tunnel called Name, applied
+// to it right away if Live is set and it is running. If PurgeRevisions is set,
+// the earlier revisions, which hold the replaced keys, are removed.
+type KeyRotationRequest struct {
+	Name           string
+	Rotation       conf.KeyRotation
+	Live           bool
+	PurgeRevisions bool
+}
+
 type TunnelState int
 
 const (
@@ -37,6 +98,7 @@ const (
 	ManagerStoppingNotificationType
 	UpdateFoundNotificationType
 	UpdateProgressNotificationType
+	PeerStatsNotificationType
 )
 
 var rpcClient *rpc.Client
@@ -71,6 +133,12 @@ type UpdateProgressCallback struct {
 
 var updateProgressCallbacks = make(map[*UpdateProgressCallback]bool)
 
+type PeerStatsCallback struct {
+	cb func(tunnel *Tunnel, stats []conf.PeerStats)
+}
+
+var peerStatsCallbacks = make(map[*PeerStatsCallback]bool)
+
 func InitializeIPCClient(reader *os.File, writer *os.File, events *os.File) {
 	rpcClient = rpc.NewClient(&pipeRWC{reader, writer})
 	go func() {
@@ -103,8 +171,15 @@ func InitializeIPCClient(reader *os.File, writer *os.File, events *os.File) {
 				if err != nil {
 					continue
 				}
+				var failure services.Failure
+				err = decoder.Decode(&failure)
+				if err != nil {
+					continue
+				}
 				var retErr error
-				if len(errStr) > 0 {
+				if failure.Code != services.ErrorSuccess {
+					retErr = &failure
+				} else if len(errStr) > 0 {
 					retErr = errors.New(errStr)
 				}
 				if state == TunnelUnknown {
@@ -160,6 +235,21 @@ func InitializeIPCClient(reader *os.File, writer *os.File, events *os.File) {
 				for cb := range updateProgressCallbacks {
 					cb.cb(dp)
 				}
+			case PeerStatsNotificationType:
+				var tunnel string
+				err := decoder.Decode(&tunnel)
+				if err != nil || len(tunnel) == 0 {
+					continue
+				}
+				var stats []conf.PeerStats
+				err = decoder.Decode(&stats)
+				if err != nil {
+					continue
+				}
+				t := &Tunnel{tunnel}
+				for cb := range peerStatsCallbacks {
+					cb.cb(t, stats)
+				}
 			}
 		}
 	}()
@@ -175,6 +265,66 @@ func (t *Tunnel) RuntimeConfig() (c conf.Config, err error) {
 	return
 }
 
+func (t *Tunnel) StoredTemplate() (template conf.TunnelTemplate, err error) {
+	err = rpcClient.Call("ManagerService.StoredTemplate", t.Name, &template)
+	return
+}
+
+// Rename gives the tunnel a new name, restarting it if it is running.
+func (t *Tunnel) Rename(newName string) (tunnel Tunnel, err error) {
+	err = rpcClient.Call("ManagerService.Rename", RenameRequest{t.Name, newName}, &tunnel)
+	return
+}
+
+// Save stores config as the tunnel's configuration, restarting the tunnel if
+// it is running.
+func (t *Tunnel) Save(config *conf.Config) error {
+	return rpcClient.Call("ManagerService.Save", *config, nil)
+}
+
+func (t *Tunnel) Duplicate(newName string) (tunnel Tunnel, err error) {
+	err = rpcClient.Call("ManagerService.Duplicate", RenameRequest{t.Name, newName}, &tunnel)
+	return
+}
+
+func (t *Tunnel) Revisions() (revisions []conf.Revision, err error) {
+	err = rpcClient.Call("ManagerService.Revisions", t.Name, &revisions)
+	return
+}
+
+func (t *Tunnel) DiffRevisions(from, to uint64) (diff string, err error) {
+	err = rpcClient.Call("ManagerService.DiffRevisions", RevisionDiffRequest{t.Name, from, to}, &diff)
+	return
+}
+
+// Rollback saves the configuration of an earlier revision as the newest one.
+func (t *Tunnel) Rollback(number uint64) error {
+	return rpcClient.Call("ManagerService.Rollback", RevisionRequest{t.Name, number}, nil)
+}
+
+func (t *Tunnel) RotateKeys(rotation conf.KeyRotation, live bool, purgeRevisions bool) (rotated conf.RotatedKeys, err error) {
+	err = rpcClient.Call("ManagerService.RotateKeys", KeyRotationRequest{t.Name, rotation, live, purgeRevisions}, &rotated)
+	return
+}
+
+// SubscribeStats has the changes in the tunnel's peer stats sent every
+// interval while it runs, to the callbacks registered with
+// IPCClientRegisterPeerStats.
+func (t *Tunnel) SubscribeStats(interval time.Duration) error {
+	return rpcClient.Call("ManagerService.SubscribeStats", StatsSubscriptionRequest{t.Name, interval}, nil)
+}
+
+func (t *Tunnel) UnsubscribeStats() error {
+	return rpcClient.Call("ManagerService.SubscribeStats", StatsSubscriptionRequest{t.Name, 0}, nil)
+}
+
+// History returns what each peer of the tunnel transferred, and when it last
+// completed a handshake, over the last hour, day or month.
+func (t *Tunnel) History(period metrics.Period) (histories []metrics.PeerHistory, err error) {
+	err = rpcClient.Call("ManagerService.History", HistoryRequest{t.Name, period}, &histories)
+	return
+}
+
 func (t *Tunnel) Start() error {
 	return rpcClient.Call("ManagerService.Start", t.Name, nil)
 }
@@ -215,6 +365,16 @@ func IPCClientNewTunnel(conf *conf.Config) (Tunnel, error) {
 	return tunnel, rpcClient.Call("ManagerService.Create", *conf, &tunnel)
 }
 
+func IPCClientNewTunnelFromTemplate(name string, template *conf.TunnelTemplate) (Tunnel, error) {
+	var tunnel Tunnel
+	return tunnel, rpcClient.Call("ManagerService.CreateFromTemplate", TemplateRequest{name, *template}, &tunnel)
+}
+
+func IPCClientImport(files []conf.ImportFile, policy conf.ConflictPolicy) ([]conf.ImportResult, error) {
+	var results []conf.ImportResult
+	return results, rpcClient.Call("ManagerService.Import", ImportRequest{files, policy}, &results)
+}
+
 func IPCClientTunnels() ([]Tunnel, error) {
 	var tunnels []Tunnel
 	return tunnels, rpcClient.Call("ManagerService.Tunnels", uintptr(0), &tunnels)
@@ -279,3 +439,11 @@ func IPCClientRegisterUpdateProgress(cb func(dp updater.DownloadProgress)) *Upda
 func (cb *UpdateProgressCallback) Unregister() {
 	delete(updateProgressCallbacks, cb)
 }
+func IPCClientRegisterPeerStats(cb func(tunnel *Tunnel, stats []conf.PeerStats)) *PeerStatsCallback {
+	s := &PeerStatsCallback{cb}
+	peerStatsCallbacks[s] = true
+	return s
+}
+func (cb *PeerStatsCallback) Unregister() {
+	delete(peerStatsCallbacks, cb)
+}
@@ -8,11 +8,14 @@ package manager
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/rpc"
 	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -22,6 +25,7 @@ import (
 	"golang.zx2c4.com/wireguard/ipc/winpipe"
 
 	"golang.zx2c4.com/wireguard/windows/conf"
+	"golang.zx2c4.com/wireguard/windows/metrics"
 	"golang.zx2c4.com/wireguard/windows/services"
 	"golang.zx2c4.com/wireguard/windows/updater"
 )
@@ -34,10 +38,15 @@ var quitManagersChan = make(chan struct{}, 1)
 type ManagerService struct {
 	events        *os.File
 	elevatedToken windows.Token
+	store         conf.Store
+	author        string
+
+	statsSubscriptions map[string]chan struct{}
+	statsLock          sync.Mutex
 }
 
 func (s *ManagerService) StoredConfig(tunnelName string, config *conf.Config) error {
-	c, err := conf.LoadFromName(tunnelName)
+	c, err := s.store.Load(tunnelName)
 	if err != nil {
 		return err
 	}
@@ -45,80 +54,130 @@ func (s *ManagerService) StoredConfig(tunnelName string, config *conf.Config) er
 	return nil
 }
 
-func (s *ManagerService) RuntimeConfig(tunnelName string, config *conf.Config) error {
-	storedConfig, err := conf.LoadFromName(tunnelName)
+// dialTunnel connects to the UAPI pipe of the running tunnel called
+// tunnelName.
+func dialTunnel(tunnelName string) (net.Conn, error) {
+	pipePath, err := services.PipePathOfTunnel(tunnelName)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	pipePath, err := services.PipePathOfTunnel(storedConfig.Name)
+	return winpipe.DialPipe(pipePath, nil)
+}
+
+// tunnelUAPI sends one UAPI operation to the running tunnel called
+// tunnelName, returning its response.
+func tunnelUAPI(tunnelName string, operation string) (string, error) {
+	pipe, err := dialTunnel(tunnelName)
 	if err != nil {
-		return err
-	}
-	pipe, err := winpipe.DialPipe(pipePath, nil)
-	if err != nil {
-		return err
+		return "", err
 	}
 	defer pipe.Close()
 	pipe.SetWriteDeadline(time.Now().Add(time.Second * 2))
-	_, err = pipe.Write([]byte("get=1\n\n"))
+	_, err = pipe.Write([]byte(operation))
 	if err != nil {
-		return err
+		return "", err
 	}
 	pipe.SetReadDeadline(time.Now().Add(time.Second * 2))
 	resp, err := ioutil.ReadAll(pipe)
+	if err != nil {
+		return "", err
+	}
+	return string(resp), nil
+}
+
+// setTunnelUAPI applies a UAPI set operation to the running tunnel called
+// tunnelName.
+func setTunnelUAPI(tunnelName string, uapi string) error {
+	resp, err := tunnelUAPI(tunnelName, "set=1\n"+uapi+"\n")
 	if err != nil {
 		return err
 	}
-	runtimeConfig, err := conf.FromUAPI(string(resp), storedConfig)
+	errno := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(resp), "errno="))
+	if errno != "0" {
+		return fmt.Errorf("Tunnel refused the configuration (error %s)", errno)
+	}
+	return nil
+}
+
+// runtimeConfig returns the configuration of the running tunnel called
+// tunnelName, including its transfer counters and handshake times.
+func runtimeConfig(store conf.Store, tunnelName string) (*conf.Config, error) {
+	storedConfig, err := store.Load(tunnelName)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := tunnelUAPI(storedConfig.Name, "get=1\n\n")
+	if err != nil {
+		return nil, err
+	}
+	return conf.FromUAPI(resp, storedConfig)
+}
+
+func (s *ManagerService) RuntimeConfig(tunnelName string, config *conf.Config) error {
+	c, err := runtimeConfig(s.store, tunnelName)
 	if err != nil {
 		return err
 	}
-	*config = *runtimeConfig
+	*config = *c
 	return nil
 }
 
+// startLock keeps two tunnels from being checked for conflicts with what is
+// running and then both started.
+var startLock sync.Mutex
+
 func (s *ManagerService) Start(tunnelName string, unused *uintptr) error {
-	// For now, enforce only one tunnel at a time. Later we'll remove this silly restriction.
+	c, err := s.store.Load(tunnelName)
+	if err != nil {
+		return err
+	}
+
+	startLock.Lock()
+	defer startLock.Unlock()
+
+	// Tunnels run alongside each other, unless they would fight over a port
+	// or over where packets go.
 	trackedTunnelsLock.Lock()
-	tt := make([]string, 0, len(trackedTunnels))
-	var inTransition string
+	others := make([]string, 0, len(trackedTunnels))
 	for t, state := range trackedTunnels {
-		tt = append(tt, t)
-		if len(t) > 0 && (state == TunnelStarting || state == TunnelUnknown) {
-			inTransition = t
-			break
+		if t != tunnelName && state != TunnelStopped && state != TunnelStopping {
+			others = append(others, t)
 		}
 	}
 	trackedTunnelsLock.Unlock()
-	if len(inTransition) != 0 {
-		return fmt.Errorf("Please allow the tunnel ‘%s’ to finish activating", inTransition)
-	}
-	go func() {
-		for _, t := range tt {
-			s.Stop(t, unused)
+	for _, t := range others {
+		other, err := s.store.Load(t)
+		if err != nil {
+			continue
 		}
-		for _, t := range tt {
-			var state TunnelState
-			var unused uintptr
-			if s.State(t, &state) == nil && (state == TunnelStarted || state == TunnelStarting) {
-				log.Printf("[%s] Trying again to stop zombie tunnel", t)
-				s.Stop(t, &unused)
-				time.Sleep(time.Millisecond * 100)
-			}
+		if err = conf.TunnelConflict(c, other); err != nil {
+			return err
 		}
-	}()
+	}
 	go cleanupStaleAdapters()
 
-	// After that process is started -- it's somewhat asynchronous -- we install the new one.
-	c, err := conf.LoadFromName(tunnelName)
+	path, err := s.store.Path(c.Name)
 	if err != nil {
 		return err
 	}
-	path, err := c.Path()
+	// The service is only tracked once it is installed, so until then the
+	// tunnel is marked as starting, for the next Start to check against.
+	trackedTunnelsLock.Lock()
+	if _, found := trackedTunnels[tunnelName]; !found {
+		trackedTunnels[tunnelName] = TunnelStarting
+		startingTunnels[tunnelName] = true
+	}
+	trackedTunnelsLock.Unlock()
+	err = InstallTunnel(path)
 	if err != nil {
-		return err
+		trackedTunnelsLock.Lock()
+		if startingTunnels[tunnelName] {
+			delete(startingTunnels, tunnelName)
+			delete(trackedTunnels, tunnelName)
+		}
+		trackedTunnelsLock.Unlock()
 	}
-	return InstallTunnel(path)
+	return err
 }
 
 func (s *ManagerService) Stop(tunnelName string, _ *uintptr) error {
@@ -126,7 +185,7 @@ func (s *ManagerService) Stop(tunnelName string, _ *uintptr) error {
 
 	err := UninstallTunnel(tunnelName)
 	if err == windows.ERROR_SERVICE_DOES_NOT_EXIST {
-		_, notExistsError := conf.LoadFromName(tunnelName)
+		_, notExistsError := s.store.Load(tunnelName)
 		if notExistsError == nil {
 			return nil
 		}
@@ -159,7 +218,16 @@ func (s *ManagerService) Delete(tunnelName string, _ *uintptr) error {
 	if err != nil {
 		return err
 	}
-	return conf.DeleteName(tunnelName)
+	err = s.store.Delete(tunnelName)
+	if err != nil {
+		return err
+	}
+	if history, err := historyStore(); err == nil {
+		if err = history.Delete(tunnelName); err != nil {
+			log.Printf("[%s] Unable to delete peer history: %v", tunnelName, err)
+		}
+	}
+	return nil
 }
 
 func (s *ManagerService) State(tunnelName string, state *TunnelState) error {
@@ -203,18 +271,296 @@ func (s *ManagerService) GlobalState(_ uintptr, state *TunnelState) error {
 }
 
 func (s *ManagerService) Create(tunnelConfig conf.Config, tunnel *Tunnel) error {
-	err := tunnelConfig.Save()
+	err := conf.CheckNameAvailable(s.store, tunnelConfig.Name, "")
+	if err != nil {
+		return err
+	}
+	err = s.store.Save(&tunnelConfig, s.author)
 	if err != nil {
 		return err
 	}
 	*tunnel = Tunnel{tunnelConfig.Name}
 	return nil
-	// TODO: handle already existing situation
-	// TODO: handle already running and existing situation
+}
+
+// Save stores a new configuration for an existing tunnel, restarting the
+// tunnel if it is running so that the configuration takes effect.
+func (s *ManagerService) Save(tunnelConfig conf.Config, _ *uintptr) error {
+	_, err := s.store.Load(tunnelConfig.Name)
+	if err != nil {
+		return err
+	}
+	return s.whileStopped(tunnelConfig.Name, func() (string, error) {
+		return tunnelConfig.Name, s.store.Save(&tunnelConfig, s.author)
+	})
+}
+
+func (s *ManagerService) CreateFromTemplate(request TemplateRequest, tunnel *Tunnel) error {
+	config, err := request.Template.Expand(request.Name)
+	if err != nil {
+		return err
+	}
+	err = conf.CheckNameAvailable(s.store, config.Name, "")
+	if err != nil {
+		return err
+	}
+	err = s.store.Save(config, s.author)
+	if err != nil {
+		return err
+	}
+	err = s.store.SaveTemplate(config.Name, &request.Template)
+	if err != nil {
+		// Deleting the tunnel also removes whatever part of the template
+		// was written.
+		s.store.Delete(config.Name)
+		return err
+	}
+	*tunnel = Tunnel{config.Name}
+	return nil
+}
+
+func (s *ManagerService) Import(request ImportRequest, results *[]conf.ImportResult) error {
+	r, err := conf.Import(s.store, request.Files, request.Policy, s.author)
+	if err != nil {
+		return err
+	}
+	*results = r
+	return nil
+}
+
+// whileStopped stops the named tunnel if it is running, makes a change that
+// leaves it under the name change returns, and then starts it again under
+// that name. Should the change fail, the tunnel is started again as it was.
+func (s *ManagerService) whileStopped(tunnelName string, change func() (string, error)) error {
+	var state TunnelState
+	err := s.State(tunnelName, &state)
+	if err != nil {
+		return err
+	}
+	wasRunning := state == TunnelStarted || state == TunnelStarting
+	if wasRunning {
+		err = s.Stop(tunnelName, nil)
+		if err != nil {
+			return err
+		}
+		err = s.WaitForStop(tunnelName, nil)
+		if err != nil {
+			s.Start(tunnelName, nil)
+			return err
+		}
+	}
+	newName, err := change()
+	if err != nil {
+		if wasRunning {
+			s.Start(tunnelName, nil)
+		}
+		return err
+	}
+	if wasRunning {
+		return s.Start(newName, nil)
+	}
+	return nil
+}
+
+func (s *ManagerService) Rename(request RenameRequest, tunnel *Tunnel) error {
+	err := conf.CheckNameAvailable(s.store, request.NewName, request.Name)
+	if err != nil {
+		return err
+	}
+	// The service is named after the tunnel, so it must be stopped and
+	// installed again under the new name.
+	return s.whileStopped(request.Name, func() (string, error) {
+		err := s.store.Rename(request.Name, request.NewName)
+		if err != nil {
+			return "", err
+		}
+		if history, err := historyStore(); err == nil {
+			if err = history.Rename(request.Name, request.NewName); err != nil {
+				log.Printf("[%s] Unable to rename peer history: %v", request.NewName, err)
+			}
+		}
+		*tunnel = Tunnel{request.NewName}
+		return request.NewName, nil
+	})
+}
+
+func (s *ManagerService) Duplicate(request RenameRequest, tunnel *Tunnel) error {
+	err := conf.CheckNameAvailable(s.store, request.NewName, "")
+	if err != nil {
+		return err
+	}
+	config, err := s.store.Load(request.Name)
+	if err != nil {
+		return err
+	}
+	config.Name = request.NewName
+	template, err := s.store.LoadTemplate(request.Name)
+	if err == nil {
+		err = s.store.SaveTemplate(request.NewName, template)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = s.store.Save(config, s.author)
+	if err != nil {
+		return err
+	}
+	*tunnel = Tunnel{request.NewName}
+	return nil
+}
+
+func (s *ManagerService) StoredTemplate(tunnelName string, template *conf.TunnelTemplate) error {
+	t, err := s.store.LoadTemplate(tunnelName)
+	if err != nil {
+		return err
+	}
+	*template = *t
+	return nil
+}
+
+func (s *ManagerService) Revisions(tunnelName string, revisions *[]conf.Revision) error {
+	r, err := s.store.Revisions(tunnelName)
+	if err != nil {
+		return err
+	}
+	*revisions = r
+	return nil
+}
+
+func (s *ManagerService) DiffRevisions(request RevisionDiffRequest, diff *string) error {
+	from, err := s.store.LoadRevision(request.Name, request.From)
+	if err != nil {
+		return err
+	}
+	to, err := s.store.LoadRevision(request.Name, request.To)
+	if err != nil {
+		return err
+	}
+	*diff = conf.DiffText(from.ToWgQuick(), to.ToWgQuick())
+	return nil
+}
+
+func (s *ManagerService) Rollback(request RevisionRequest, _ *uintptr) error {
+	config, err := s.store.LoadRevision(request.Name, request.Number)
+	if err != nil {
+		return err
+	}
+	return s.store.Save(config, s.author)
+}
+
+// keyRotationLock makes rotations, which read, change and save a
+// configuration, happen one at a time.
+var keyRotationLock sync.Mutex
+
+// RotateKeys generates new keys for a tunnel and saves them as a new revision.
+// If the tunnel is running and request.Live is set, the keys are applied to it
+// first, and put back should saving fail, so that the running tunnel and the
+// stored configuration agree. Otherwise the keys take effect when the tunnel
+// is next started. The earlier revisions are purged if request.PurgeRevisions
+// is set, which is only logged should it fail, as the keys are rotated by then.
+func (s *ManagerService) RotateKeys(request KeyRotationRequest, rotated *conf.RotatedKeys) error {
+	keyRotationLock.Lock()
+	defer keyRotationLock.Unlock()
+
+	config, err := s.store.Load(request.Name)
+	if err != nil {
+		return err
+	}
+	original, err := s.store.Load(request.Name)
+	if err != nil {
+		return err
+	}
+	var state TunnelState
+	err = s.State(config.Name, &state)
+	if err != nil {
+		return err
+	}
+	running := state != TunnelStopped
+	if running && state != TunnelStarted {
+		return fmt.Errorf("Please allow the tunnel ‘%s’ to finish activating or deactivating", config.Name)
+	}
+	if running && !request.Live && config.Interface.SaveConfig {
+		return fmt.Errorf("The tunnel ‘%s’ saves its configuration when it stops, which would undo the rotation. Rotate its keys while it is stopped, or apply them to it now", config.Name)
+	}
+
+	r, err := config.RotateKeys(request.Rotation)
+	if err != nil {
+		return err
+	}
+	live := running && request.Live
+	if live {
+		err = setTunnelUAPI(config.Name, config.KeysToUAPI(request.Rotation))
+		if err != nil {
+			return fmt.Errorf("Unable to apply new keys to the running tunnel: %v", err)
+		}
+	}
+	err = s.store.Save(config, s.author)
+	if err != nil {
+		if live {
+			if revertErr := setTunnelUAPI(config.Name, original.KeysToUAPI(request.Rotation)); revertErr != nil {
+				log.Printf("[%s] Unable to restore previous keys after failing to save new ones: %v", config.Name, revertErr)
+			}
+		}
+		return err
+	}
+	if live {
+		log.Printf("[%s] Rotated keys and applied them to the running tunnel", config.Name)
+	} else {
+		log.Printf("[%s] Rotated keys", config.Name)
+	}
+	if request.PurgeRevisions {
+		err = s.store.PurgeRevisions(config.Name)
+		if err != nil {
+			log.Printf("[%s] Unable to remove revisions holding the previous keys: %v", config.Name, err)
+		}
+	}
+	*rotated = *r
+	return nil
+}
+
+// SubscribeStats sends the changes in the peer stats of a tunnel while it
+// runs, as a peer stats notification, every request.Interval, or stops
+// sending them if that is zero.
+func (s *ManagerService) SubscribeStats(request StatsSubscriptionRequest, _ *uintptr) error {
+	if !conf.TunnelNameIsValid(request.Name) {
+		return errors.New("Tunnel name is not valid")
+	}
+	if request.Interval < 0 {
+		return errors.New("Interval must not be negative")
+	}
+	s.subscribeStats(request.Name, request.Interval)
+	return nil
+}
+
+// History returns what each peer of a tunnel transferred, and when it last
+// completed a handshake, over the last hour, day or month.
+func (s *ManagerService) History(request HistoryRequest, histories *[]metrics.PeerHistory) error {
+	config, err := s.store.Load(request.Name)
+	if err != nil {
+		return err
+	}
+	history, err := historyStore()
+	if err != nil {
+		return err
+	}
+	peerHistories, err := history.Query(config.Name, request.Period, time.Now())
+	if err != nil {
+		return err
+	}
+	for i := range peerHistories {
+		for j := range config.Peers {
+			if config.Peers[j].PublicKey.Fingerprint() == peerHistories[i].Fingerprint {
+				peerHistories[i].Name = config.Peers[j].Name
+				break
+			}
+		}
+	}
+	*histories = peerHistories
+	return nil
 }
 
 func (s *ManagerService) Tunnels(_ uintptr, tunnels *[]Tunnel) error {
-	names, err := conf.ListConfigNames()
+	names, err := s.store.ListConfigNames()
 	if err != nil {
 		return err
 	}
@@ -239,7 +585,7 @@ func (s *ManagerService) Quit(stopTunnelsOnQuit bool, alreadyQuit *bool) error {
 	managerServicesLock.Unlock()
 
 	if stopTunnelsOnQuit {
-		names, err := conf.ListConfigNames()
+		names, err := s.store.ListConfigNames()
 		if err != nil {
 			return err
 		}
@@ -271,10 +617,12 @@ func (s *ManagerService) Update(_ uintptr, _ *uintptr) error {
 	return nil
 }
 
-func IPCServerListen(reader *os.File, writer *os.File, events *os.File, elevatedToken windows.Token) error {
+func IPCServerListen(reader *os.File, writer *os.File, events *os.File, elevatedToken windows.Token, store conf.Store, author string) error {
 	service := &ManagerService{
 		events:        events,
 		elevatedToken: elevatedToken,
+		store:         store,
+		author:        author,
 	}
 
 	server := rpc.NewServer()
@@ -291,42 +639,70 @@ func IPCServerListen(reader *os.File, writer *os.File, events *os.File, elevated
 		managerServicesLock.Lock()
 		delete(managerServices, service)
 		managerServicesLock.Unlock()
+		service.unsubscribeAllStats()
 
 	}()
 	return nil
 }
 
-func notifyAll(notificationType NotificationType, ifaces ...interface{}) {
-	if len(managerServices) == 0 {
-		return
-	}
-
+func encodeNotification(notificationType NotificationType, ifaces ...interface{}) ([]byte, error) {
 	var buf bytes.Buffer
 	encoder := gob.NewEncoder(&buf)
 	err := encoder.Encode(notificationType)
 	if err != nil {
-		return
+		return nil, err
 	}
 	for _, iface := range ifaces {
 		err = encoder.Encode(iface)
 		if err != nil {
-			return
+			return nil, err
 		}
 	}
+	return buf.Bytes(), nil
+}
+
+func notifyAll(notificationType NotificationType, ifaces ...interface{}) {
+	if len(managerServices) == 0 {
+		return
+	}
+
+	buf, err := encodeNotification(notificationType, ifaces...)
+	if err != nil {
+		return
+	}
 
 	managerServicesLock.RLock()
 	for m := range managerServices {
 		m.events.SetWriteDeadline(time.Now().Add(time.Second))
-		m.events.Write(buf.Bytes())
+		m.events.Write(buf)
+	}
+	managerServicesLock.RUnlock()
+}
+
+// notify sends a notification to this client alone, if it is still there.
+func (s *ManagerService) notify(notificationType NotificationType, ifaces ...interface{}) {
+	buf, err := encodeNotification(notificationType, ifaces...)
+	if err != nil {
+		return
+	}
+
+	managerServicesLock.RLock()
+	if managerServices[s] {
+		s.events.SetWriteDeadline(time.Now().Add(time.Second))
+		s.events.Write(buf)
 	}
 	managerServicesLock.RUnlock()
 }
 
 func IPCServerNotifyTunnelChange(name string, state TunnelState, err error) {
+	var failure services.Failure
+	if f, ok := err.(*services.Failure); ok {
+		failure = *f
+	}
 	if err == nil {
-		notifyAll(TunnelChangeNotificationType, name, state, trackedTunnelsGlobalState(), "")
+		notifyAll(TunnelChangeNotificationType, name, state, trackedTunnelsGlobalState(), "", failure)
 	} else {
-		notifyAll(TunnelChangeNotificationType, name, state, trackedTunnelsGlobalState(), err.Error())
+		notifyAll(TunnelChangeNotificationType, name, state, trackedTunnelsGlobalState(), err.Error(), failure)
 	}
 }
 
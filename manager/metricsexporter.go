@@ -0,0 +1,70 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package manager
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+	"golang.zx2c4.com/wireguard/windows/metrics"
+)
+
+// exporterPortPolicy names the DWORD an administrator sets to the port on
+// which to serve metrics to Prometheus, which is off unless set.
+const exporterPortPolicy = "PrometheusExporterPort"
+
+// serveMetrics serves the metrics of the tunnels in store over HTTP on the
+// loopback interface, if the administrator has opted in.
+func serveMetrics(store conf.Store) {
+	defer printPanic()
+
+	port := conf.AdminInteger(exporterPortPolicy)
+	if port == 0 {
+		return
+	}
+	if port > 65535 {
+		log.Printf("Not serving metrics, because %s is not a valid port", exporterPortPolicy)
+		return
+	}
+	address := net.JoinHostPort("127.0.0.1", strconv.FormatUint(port, 10))
+	exporter := &metrics.Exporter{
+		Tunnels: func() ([]metrics.ExportedTunnel, error) {
+			names, err := store.ListConfigNames()
+			if err != nil {
+				return nil, err
+			}
+			tunnels := make([]metrics.ExportedTunnel, 0, len(names))
+			trackedTunnelsLock.Lock()
+			for _, name := range names {
+				state, ok := trackedTunnels[name]
+				if !ok {
+					state = TunnelStopped
+				}
+				tunnels = append(tunnels, metrics.ExportedTunnel{Name: name, State: int(state), Running: state == TunnelStarted})
+			}
+			trackedTunnelsLock.Unlock()
+			return tunnels, nil
+		},
+		RuntimeConfig: func(tunnelName string) (*conf.Config, error) {
+			return runtimeConfig(store, tunnelName)
+		},
+		UpdateState: func() int { return int(updateState) },
+		Host:        address,
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Printf("Unable to serve metrics: %v", err)
+		return
+	}
+	log.Printf("Serving metrics on http://%s/metrics", listener.Addr())
+	server := &http.Server{Handler: exporter, ReadHeaderTimeout: time.Second * 5}
+	err = server.Serve(listener)
+	log.Printf("Stopped serving metrics: %v", err)
+}
@@ -0,0 +1,105 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package manager
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+	"golang.zx2c4.com/wireguard/windows/metrics"
+)
+
+// historyInterval is how often the peers of running tunnels are sampled for
+// their history.
+const historyInterval = time.Minute
+
+var cachedHistoryStore *metrics.Store
+var historyStoreLock sync.Mutex
+
+func historyStore() (*metrics.Store, error) {
+	historyStoreLock.Lock()
+	defer historyStoreLock.Unlock()
+	if cachedHistoryStore != nil {
+		return cachedHistoryStore, nil
+	}
+	root, err := conf.RootDirectory()
+	if err != nil {
+		return nil, err
+	}
+	cachedHistoryStore = metrics.NewStore(filepath.Join(root, "Metrics"))
+	return cachedHistoryStore, nil
+}
+
+// recordHistory samples the peers of running tunnels every historyInterval,
+// adding what they transferred since the previous sample and their latest
+// handshakes to their history.
+func recordHistory(store conf.Store) {
+	history, err := historyStore()
+	if err != nil {
+		log.Printf("Unable to open peer history: %v", err)
+		return
+	}
+	aggregators := make(map[string]*conf.StatsAggregator)
+	first := true
+	ticker := time.NewTicker(historyInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		var running []string
+		trackedTunnelsLock.Lock()
+		for tunnelName, state := range trackedTunnels {
+			if state == TunnelStarted {
+				running = append(running, tunnelName)
+			}
+		}
+		trackedTunnelsLock.Unlock()
+
+		// Aggregators are kept for as long as their tunnels run, even when
+		// they can't be sampled for a while, as a new one would count what was
+		// transferred since the tunnel started all over again.
+		isRunning := make(map[string]bool, len(running))
+		for _, tunnelName := range running {
+			isRunning[tunnelName] = true
+			config, err := runtimeConfig(store, tunnelName)
+			if err != nil {
+				continue
+			}
+			aggregator, ok := aggregators[tunnelName]
+			if !ok {
+				aggregator = &conf.StatsAggregator{}
+				aggregators[tunnelName] = aggregator
+			}
+			transferred := make(map[string]*conf.PeerStats)
+			changes := aggregator.Update(config)
+			for i := range changes {
+				transferred[changes[i].Fingerprint] = &changes[i]
+			}
+			for i := range config.Peers {
+				peer := &config.Peers[i]
+				fingerprint := peer.PublicKey.Fingerprint()
+				var rx, tx conf.Bytes
+				// At the first sample, the counters of running tunnels may
+				// hold what they transferred before the manager started,
+				// which was recorded then.
+				if stats, ok := transferred[fingerprint]; ok && !first {
+					rx, tx = stats.RxDelta, stats.TxDelta
+				}
+				err = history.Record(tunnelName, fingerprint, now, rx, tx, peer.LastHandshakeTime)
+				if err != nil {
+					log.Printf("[%s] Unable to record peer history: %v", tunnelName, err)
+				}
+			}
+		}
+		for tunnelName := range aggregators {
+			if !isRunning[tunnelName] {
+				delete(aggregators, tunnelName)
+			}
+		}
+		first = false
+	}
+}
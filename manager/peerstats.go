@@ -0,0 +1,75 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package manager
+
+import (
+	"time"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+)
+
+// minStatsInterval keeps subscribers from having the tunnel polled more
+// often than is useful for showing it.
+const minStatsInterval = time.Second / 4
+
+// subscribeStats starts sending the peer stats of the tunnel called
+// tunnelName to this client every interval, replacing any earlier
+// subscription to it, or stops sending them if interval is zero.
+func (s *ManagerService) subscribeStats(tunnelName string, interval time.Duration) {
+	s.statsLock.Lock()
+	defer s.statsLock.Unlock()
+	if stop, ok := s.statsSubscriptions[tunnelName]; ok {
+		close(stop)
+		delete(s.statsSubscriptions, tunnelName)
+	}
+	if interval == 0 {
+		return
+	}
+	if interval < minStatsInterval {
+		interval = minStatsInterval
+	}
+	if s.statsSubscriptions == nil {
+		s.statsSubscriptions = make(map[string]chan struct{})
+	}
+	stop := make(chan struct{})
+	s.statsSubscriptions[tunnelName] = stop
+	go s.sendStats(tunnelName, interval, stop)
+}
+
+// unsubscribeAllStats stops every subscription of this client, such as when
+// it goes away.
+func (s *ManagerService) unsubscribeAllStats() {
+	s.statsLock.Lock()
+	defer s.statsLock.Unlock()
+	for tunnelName, stop := range s.statsSubscriptions {
+		close(stop)
+		delete(s.statsSubscriptions, tunnelName)
+	}
+}
+
+func (s *ManagerService) sendStats(tunnelName string, interval time.Duration, stop <-chan struct{}) {
+	var aggregator conf.StatsAggregator
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		trackedTunnelsLock.Lock()
+		state := trackedTunnels[tunnelName]
+		trackedTunnelsLock.Unlock()
+		var config conf.Config
+		if state == TunnelStarted && s.RuntimeConfig(tunnelName, &config) == nil {
+			if changes := aggregator.Update(&config); len(changes) > 0 {
+				s.notify(PeerStatsNotificationType, tunnelName, changes)
+			}
+		} else {
+			aggregator.Update(nil)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
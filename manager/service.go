@@ -28,7 +28,9 @@ import (
 	"golang.zx2c4.com/wireguard/windows/version"
 )
 
-type managerService struct{}
+type managerService struct {
+	store conf.Store
+}
 
 func printPanic() {
 	if x := recover(); x != nil {
@@ -61,6 +63,7 @@ func (service *managerService) Execute(args []string, r <-chan svc.ChangeRequest
 		serviceError = services.ErrorRingloggerOpen
 		return
 	}
+	ringlogger.Global.SetFilter(conf.RedactKeys)
 	defer printPanic()
 
 	log.Println("Starting", version.UserAgent())
@@ -77,7 +80,7 @@ func (service *managerService) Execute(args []string, r <-chan svc.ChangeRequest
 		return
 	}
 
-	err = trackExistingTunnels()
+	err = trackExistingTunnels(service.store)
 	if err != nil {
 		serviceError = services.ErrorTrackTunnels
 		return
@@ -171,7 +174,7 @@ func (service *managerService) Execute(args []string, r <-chan svc.ChangeRequest
 				return
 			}
 			ourEvents, theirEvents, theirEventStr, err := inheritableEvents()
-			err = IPCServerListen(ourReader, ourWriter, ourEvents, elevatedToken)
+			err = IPCServerListen(ourReader, ourWriter, ourEvents, elevatedToken, service.store, fmt.Sprintf("%s@%s", username, domain))
 			if err != nil {
 				log.Printf("Unable to listen on IPC pipes: %v", err)
 				return
@@ -249,6 +252,8 @@ func (service *managerService) Execute(args []string, r <-chan svc.ChangeRequest
 
 	go cleanupStaleAdapters()
 	go checkForUpdates()
+	go recordHistory(service.store)
+	go serveMetrics(service.store)
 
 	var sessionsPointer *windows.WTS_SESSION_INFO
 	var count uint32
@@ -343,6 +348,6 @@ loop:
 	return
 }
 
-func Run() error {
-	return svc.Run("WireGuardManager", &managerService{})
+func Run(store conf.Store) error {
+	return svc.Run("WireGuardManager", &managerService{store})
 }
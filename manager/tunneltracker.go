@@ -21,12 +21,12 @@ import (
 	"golang.zx2c4.com/wireguard/windows/services"
 )
 
-func trackExistingTunnels() error {
+func trackExistingTunnels(store conf.Store) error {
 	m, err := serviceManager()
 	if err != nil {
 		return err
 	}
-	names, err := conf.ListConfigNames()
+	names, err := store.ListConfigNames()
 	if err != nil {
 		return err
 	}
@@ -51,6 +51,10 @@ var serviceTrackerCallbackPtr = windows.NewCallback(func(notifier *windows.SERVI
 var trackedTunnels = make(map[string]TunnelState)
 var trackedTunnelsLock = sync.Mutex{}
 
+// startingTunnels are those in trackedTunnels that Start marked as starting,
+// which trackTunnelService takes over rather than leaving to another tracker.
+var startingTunnels = make(map[string]bool)
+
 func svcStateToTunState(s svc.State) TunnelState {
 	switch s {
 	case svc.StartPending:
@@ -66,17 +70,20 @@ func svcStateToTunState(s svc.State) TunnelState {
 	}
 }
 
+// trackedTunnelsGlobalState summarizes the states of all tunnels: started
+// while any tunnel is up, even as others come and go, and otherwise starting
+// or stopping while any tunnel is.
 func trackedTunnelsGlobalState() (state TunnelState) {
 	state = TunnelStopped
 	trackedTunnelsLock.Lock()
 	defer trackedTunnelsLock.Unlock()
 	for _, s := range trackedTunnels {
-		if s == TunnelStarting {
-			return TunnelStarting
-		} else if s == TunnelStopping {
-			return TunnelStopping
-		} else if s == TunnelStarted || s == TunnelUnknown {
-			state = TunnelStarted
+		if s == TunnelStarted || s == TunnelUnknown {
+			return TunnelStarted
+		} else if s == TunnelStarting {
+			state = TunnelStarting
+		} else if s == TunnelStopping && state != TunnelStarting {
+			state = TunnelStopping
 		}
 	}
 	return
@@ -89,10 +96,11 @@ func trackTunnelService(tunnelName string, service *mgr.Service) {
 	}()
 
 	trackedTunnelsLock.Lock()
-	if _, found := trackedTunnels[tunnelName]; found {
+	if _, found := trackedTunnels[tunnelName]; found && !startingTunnels[tunnelName] {
 		trackedTunnelsLock.Unlock()
 		return
 	}
+	delete(startingTunnels, tunnelName)
 	trackedTunnels[tunnelName] = TunnelUnknown
 	trackedTunnelsLock.Unlock()
 	defer func() {
@@ -178,6 +186,12 @@ func trackTunnelService(tunnelName string, service *mgr.Service) {
 					tunnelError = syscall.Errno(notifier.ServiceStatus.Win32ExitCode)
 				}
 			}
+			failure, err := services.TakeFailure(tunnelName)
+			if err != nil {
+				log.Printf("[%s] Unable to read tunnel failure: %v", tunnelName, err)
+			} else if failure != nil && tunnelError != nil {
+				tunnelError = failure
+			}
 		}
 		if state != lastState {
 			trackedTunnelsLock.Lock()
@@ -0,0 +1,190 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+)
+
+// ExportedTunnel is a tunnel the exporter reports on. State is the manager's
+// TunnelState.
+type ExportedTunnel struct {
+	Name    string
+	State   int
+	Running bool
+}
+
+// Exporter serves the state of tunnels, the counters and handshakes of the
+// peers of those that are running, and the state of the update check, in the
+// Prometheus text exposition format. Peers are told apart by the fingerprint
+// of their public key, so no key material is served. Scrapes within
+// snapshotLifetime of each other are served the same snapshot.
+type Exporter struct {
+	// Tunnels returns the tunnels to report on.
+	Tunnels func() ([]ExportedTunnel, error)
+	// RuntimeConfig returns the configuration of a running tunnel, with the
+	// counters and handshakes of its peers, as the manager's RuntimeConfig.
+	RuntimeConfig func(tunnelName string) (*conf.Config, error)
+	// UpdateState returns the manager's UpdateState.
+	UpdateState func() int
+	// Host, if set, is the only Host header answered, so that a web page can't
+	// read the metrics by rebinding the name of its site to the loopback
+	// address.
+	Host string
+
+	now func() time.Time
+
+	snapshotLock sync.Mutex
+	snapshot     []byte
+	snapshotTime time.Time
+}
+
+// snapshotLifetime is how long a snapshot of the metrics is served before the
+// tunnels are asked again, so that frequent scrapes stay cheap.
+const snapshotLifetime = time.Second * 5
+
+const exporterContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricFamily is the samples of one metric, which the format requires to be
+// written together, after its HELP and TYPE lines.
+type metricFamily struct {
+	name, help, kind string
+	samples          []string
+}
+
+func (family *metricFamily) add(labels string, value string) {
+	family.samples = append(family.samples, fmt.Sprintf("%s%s %s", family.name, labels, value))
+}
+
+func (family *metricFamily) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", family.name, family.help, family.name, family.kind)
+	for _, sample := range family.samples {
+		fmt.Fprintln(w, sample)
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats pairs of label names and values.
+func labels(pairs ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", pairs[i], labelValueEscaper.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func (e *Exporter) currentTime() time.Time {
+	if e.now != nil {
+		return e.now()
+	}
+	return time.Now()
+}
+
+// WriteMetrics writes the metrics in the text exposition format.
+func (e *Exporter) WriteMetrics(w io.Writer) error {
+	tunnels, err := e.Tunnels()
+	if err != nil {
+		return err
+	}
+	sort.Slice(tunnels, func(i, j int) bool { return tunnels[i].Name < tunnels[j].Name })
+	now := e.currentTime()
+
+	state := &metricFamily{name: "wireguard_tunnel_state", kind: "gauge",
+		help: "State of the tunnel: 0 unknown, 1 started, 2 stopped, 3 starting, 4 stopping."}
+	up := &metricFamily{name: "wireguard_tunnel_scrape_success", kind: "gauge",
+		help: "Whether the statistics of the running tunnel could be read."}
+	rx := &metricFamily{name: "wireguard_peer_receive_bytes_total", kind: "counter",
+		help: "Bytes received from the peer."}
+	tx := &metricFamily{name: "wireguard_peer_transmit_bytes_total", kind: "counter",
+		help: "Bytes sent to the peer."}
+	handshake := &metricFamily{name: "wireguard_peer_last_handshake_age_seconds", kind: "gauge",
+		help: "Seconds since the latest handshake with the peer completed."}
+	for _, tunnel := range tunnels {
+		tunnelLabels := labels("tunnel", tunnel.Name)
+		state.add(tunnelLabels, fmt.Sprint(tunnel.State))
+		if !tunnel.Running {
+			continue
+		}
+		config, err := e.RuntimeConfig(tunnel.Name)
+		if err != nil {
+			up.add(tunnelLabels, "0")
+			continue
+		}
+		up.add(tunnelLabels, "1")
+		for i := range config.Peers {
+			peer := &config.Peers[i]
+			peerLabels := labels("tunnel", config.Name, "peer", peer.PublicKey.Fingerprint(), "name", peer.Name)
+			rx.add(peerLabels, fmt.Sprint(uint64(peer.RxBytes)))
+			tx.add(peerLabels, fmt.Sprint(uint64(peer.TxBytes)))
+			if !peer.LastHandshakeTime.IsEmpty() {
+				age := now.Sub(time.Unix(0, 0).Add(time.Duration(peer.LastHandshakeTime)))
+				handshake.add(peerLabels, fmt.Sprintf("%.3f", age.Seconds()))
+			}
+		}
+	}
+	update := &metricFamily{name: "wireguard_update_state", kind: "gauge",
+		help: "State of the update check: 0 unknown, 1 update found, 2 updates disabled for an unofficial build."}
+	update.add("", fmt.Sprint(e.UpdateState()))
+
+	for _, family := range []*metricFamily{state, up, rx, tx, handshake, update} {
+		family.writeTo(w)
+	}
+	return nil
+}
+
+// metrics returns the metrics in the text exposition format, taking a new
+// snapshot if the last one is too old.
+func (e *Exporter) metrics() ([]byte, error) {
+	e.snapshotLock.Lock()
+	defer e.snapshotLock.Unlock()
+	now := e.currentTime()
+	if e.snapshot != nil && !now.Before(e.snapshotTime) && now.Sub(e.snapshotTime) < snapshotLifetime {
+		return e.snapshot, nil
+	}
+	var buf bytes.Buffer
+	err := e.WriteMetrics(&buf)
+	if err != nil {
+		return nil, err
+	}
+	e.snapshot, e.snapshotTime = buf.Bytes(), now
+	return e.snapshot, nil
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(e.Host) > 0 && r.Host != e.Host {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if r.URL.Path != "/metrics" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := e.metrics()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", exporterContentType)
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(body)
+}
@@ -0,0 +1,208 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+)
+
+const exporterTestConfig = `[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+
+[Peer]
+# Name = office "north"
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 10.0.0.0/8
+
+[Peer]
+PublicKey = TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=
+AllowedIPs = 192.168.0.0/16
+`
+
+var (
+	metricNamePattern = `[a-zA-Z_:][a-zA-Z0-9_:]*`
+	commentLine       = regexp.MustCompile(`^# (HELP|TYPE) (` + metricNamePattern + `) (.*)$`)
+	sampleLine        = regexp.MustCompile(`^(` + metricNamePattern + `)(\{(?:[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\[\\"n])*",?)*\})? (\S+)( -?[0-9]+)?$`)
+)
+
+// checkExposition checks text against the Prometheus text exposition format,
+// version 0.0.4, returning the value of each sample by its name and labels.
+func checkExposition(t *testing.T, text string) map[string]string {
+	samples := make(map[string]string)
+	if !strings.HasSuffix(text, "\n") {
+		t.Error("Exposition must end with a line feed")
+	}
+	typed := make(map[string]string)
+	finished := make(map[string]bool)
+	current := ""
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if comment := commentLine.FindStringSubmatch(line); comment != nil {
+			name := comment[2]
+			if comment[1] == "TYPE" {
+				if _, ok := typed[name]; ok || finished[name] || name == current {
+					t.Errorf("TYPE of %s must come once, before its samples", name)
+				}
+				switch comment[3] {
+				case "counter", "gauge", "histogram", "summary", "untyped":
+				default:
+					t.Errorf("Unknown type %q of %s", comment[3], name)
+				}
+				typed[name] = comment[3]
+			}
+			continue
+		} else if strings.HasPrefix(line, "#") {
+			continue
+		}
+		sample := sampleLine.FindStringSubmatch(line)
+		if sample == nil {
+			t.Errorf("Malformed sample line: %q", line)
+			continue
+		}
+		name := sample[1]
+		if name != current {
+			if finished[name] {
+				t.Errorf("Samples of %s must be together", name)
+			}
+			if len(current) > 0 {
+				finished[current] = true
+			}
+			current = name
+		}
+		if typed[name] == "counter" && !strings.HasSuffix(name, "_total") {
+			t.Errorf("Counter %s should end with _total", name)
+		}
+		switch sample[3] {
+		case "+Inf", "-Inf", "NaN":
+		default:
+			if _, err := strconv.ParseFloat(sample[3], 64); err != nil {
+				t.Errorf("Value of %q is not a number", line)
+			}
+		}
+		key := name + sample[2]
+		if _, ok := samples[key]; ok {
+			t.Errorf("Duplicate sample %s", key)
+		}
+		samples[key] = sample[3]
+	}
+	return samples
+}
+
+func TestExporter(t *testing.T) {
+	stored, err := conf.FromWgQuick(exporterTestConfig, "corp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1572566400, 0)
+	uapi := fmt.Sprintf("private_key=%s\nlisten_port=51820\npublic_key=%s\nrx_bytes=1234\ntx_bytes=5678\nlast_handshake_time_sec=%d\nlast_handshake_time_nsec=500000000\npublic_key=%s\nrx_bytes=0\ntx_bytes=0\nlast_handshake_time_sec=0\nlast_handshake_time_nsec=0\nerrno=0\n\n",
+		stored.Interface.PrivateKey.HexString(), stored.Peers[0].PublicKey.HexString(), now.Unix()-90, stored.Peers[1].PublicKey.HexString())
+
+	running, err := conf.FromUAPI(uapi, stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := 0
+	exporter := &Exporter{
+		Tunnels: func() ([]ExportedTunnel, error) {
+			return []ExportedTunnel{{"lab", 2, false}, {"corp", 1, true}, {"broken", 1, true}}, nil
+		},
+		RuntimeConfig: func(tunnelName string) (*conf.Config, error) {
+			queries++
+			if tunnelName != "corp" {
+				return nil, errors.New("The system cannot find the file specified.")
+			}
+			return running, nil
+		},
+		UpdateState: func() int { return 1 },
+		Host:        "127.0.0.1:9586",
+		now:         func() time.Time { return now },
+	}
+	scrape := func(method, target string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, target, nil)
+		request.Host = exporter.Host
+		exporter.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := scrape(http.MethodGet, "/metrics")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != exporterContentType {
+		t.Errorf("Unexpected content type %q", contentType)
+	}
+	text := recorder.Body.String()
+	samples := checkExposition(t, text)
+
+	office := fmt.Sprintf(`{tunnel="corp",peer="%s",name="office \"north\""}`, stored.Peers[0].PublicKey.Fingerprint())
+	quiet := fmt.Sprintf(`{tunnel="corp",peer="%s",name=""}`, stored.Peers[1].PublicKey.Fingerprint())
+	for key, value := range map[string]string{
+		`wireguard_tunnel_state{tunnel="broken"}`:            "1",
+		`wireguard_tunnel_state{tunnel="corp"}`:              "1",
+		`wireguard_tunnel_state{tunnel="lab"}`:               "2",
+		`wireguard_tunnel_scrape_success{tunnel="corp"}`:     "1",
+		`wireguard_tunnel_scrape_success{tunnel="broken"}`:   "0",
+		"wireguard_peer_receive_bytes_total" + office:        "1234",
+		"wireguard_peer_transmit_bytes_total" + office:       "5678",
+		"wireguard_peer_receive_bytes_total" + quiet:         "0",
+		"wireguard_peer_last_handshake_age_seconds" + office: "89.500",
+		"wireguard_update_state":                             "1",
+	} {
+		if samples[key] != value {
+			t.Errorf("Expected %s to be %s, not %q", key, value, samples[key])
+		}
+	}
+	if _, ok := samples["wireguard_peer_last_handshake_age_seconds"+quiet]; ok {
+		t.Error("Peers without a handshake should have no handshake age")
+	}
+	if _, ok := samples[`wireguard_tunnel_scrape_success{tunnel="lab"}`]; ok {
+		t.Error("Stopped tunnels should not be queried")
+	}
+	for _, key := range []*conf.Key{&stored.Interface.PrivateKey, &stored.Peers[0].PublicKey} {
+		if strings.Contains(text, key.String()) || strings.Contains(text, key.HexString()) {
+			t.Errorf("Key material was served:\n%s", text)
+		}
+	}
+
+	recorder = scrape(http.MethodGet, "/")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Only /metrics should be served, not / with status %d", recorder.Code)
+	}
+	recorder = scrape(http.MethodPost, "/metrics")
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Posting should not be allowed, got status %d", recorder.Code)
+	}
+	recorder = httptest.NewRecorder()
+	exporter.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://rebound.example:9586/metrics", nil))
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Requests for other hosts should be forbidden, got status %d", recorder.Code)
+	}
+
+	// Scrapes soon after another are served the same snapshot.
+	expectQueries := func(expected, actual int) {
+		if expected != actual {
+			t.Errorf("Expected %d queries of running tunnels, not %d", expected, actual)
+		}
+	}
+	expectQueries(2, queries)
+	if recorder = scrape(http.MethodGet, "/metrics"); recorder.Body.String() != text {
+		t.Errorf("Snapshot should be served again:\n%s", recorder.Body.String())
+	}
+	expectQueries(2, queries)
+	now = now.Add(snapshotLifetime)
+	scrape(http.MethodGet, "/metrics")
+	expectQueries(4, queries)
+}
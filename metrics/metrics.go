@@ -0,0 +1,296 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package metrics keeps the history of how much each peer of a tunnel
+// transferred and when it last completed a handshake, in files of a fixed
+// size, like the ring logger does for log lines.
+package metrics
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+)
+
+// Period is how far back a query goes.
+type Period int
+
+const (
+	PeriodHour Period = iota
+	PeriodDay
+	PeriodMonth
+)
+
+// ring is the part of a file that covers one period, in slots of resolution
+// each. A sample goes to the slot its time falls in, modulo the number of
+// slots, so a slot is overwritten once the period has passed.
+type ring struct {
+	resolution time.Duration
+	slots      int64
+}
+
+var rings = [...]ring{
+	PeriodHour:  {time.Minute, 60},
+	PeriodDay:   {time.Minute * 5, 24 * 12},
+	PeriodMonth: {time.Hour, 31 * 24},
+}
+
+const (
+	magic      = 0xbadcafe
+	headerSize = 8
+	bucketSize = 32
+)
+
+// Bucket is what a peer transferred during the slot that starts at Start,
+// and the latest handshake seen during it.
+type Bucket struct {
+	Start             time.Time
+	RxBytes           conf.Bytes
+	TxBytes           conf.Bytes
+	LastHandshakeTime conf.HandshakeTime
+}
+
+// PeerHistory is the history of the peer whose public key has the given
+// fingerprint, oldest bucket first.
+type PeerHistory struct {
+	Fingerprint string
+	Name        string
+	Buckets     []Bucket
+}
+
+// Store keeps the history of every peer of every tunnel in a directory, with
+// a subdirectory for each tunnel and a file for each peer.
+type Store struct {
+	dir  string
+	lock sync.Mutex
+}
+
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+func fileSize() int64 {
+	size := int64(headerSize)
+	for _, r := range rings {
+		size += r.slots * bucketSize
+	}
+	return size
+}
+
+func ringOffset(period Period) int64 {
+	offset := int64(headerSize)
+	for _, r := range rings[:period] {
+		offset += r.slots * bucketSize
+	}
+	return offset
+}
+
+func (s *Store) tunnelDir(tunnelName string) (string, error) {
+	if !conf.TunnelNameIsValid(tunnelName) {
+		return "", errors.New("Tunnel name is not valid")
+	}
+	return filepath.Join(s.dir, tunnelName), nil
+}
+
+func (s *Store) peerPath(tunnelName string, fingerprint string) (string, error) {
+	dir, err := s.tunnelDir(tunnelName)
+	if err != nil {
+		return "", err
+	}
+	if len(fingerprint) == 0 || strings.ContainsAny(fingerprint, `/\.:`) {
+		return "", errors.New("Peer fingerprint is not valid")
+	}
+	return filepath.Join(dir, fingerprint+".bin"), nil
+}
+
+func readBucket(file *os.File, offset int64) (Bucket, error) {
+	var buf [bucketSize]byte
+	_, err := file.ReadAt(buf[:], offset)
+	if err != nil {
+		return Bucket{}, err
+	}
+	start := int64(binary.LittleEndian.Uint64(buf[0:]))
+	bucket := Bucket{
+		RxBytes:           conf.Bytes(binary.LittleEndian.Uint64(buf[8:])),
+		TxBytes:           conf.Bytes(binary.LittleEndian.Uint64(buf[16:])),
+		LastHandshakeTime: conf.HandshakeTime(binary.LittleEndian.Uint64(buf[24:])),
+	}
+	if start != 0 {
+		bucket.Start = time.Unix(start, 0)
+	}
+	return bucket, nil
+}
+
+func writeBucket(file *os.File, offset int64, bucket *Bucket) error {
+	var buf [bucketSize]byte
+	if !bucket.Start.IsZero() {
+		binary.LittleEndian.PutUint64(buf[0:], uint64(bucket.Start.Unix()))
+	}
+	binary.LittleEndian.PutUint64(buf[8:], uint64(bucket.RxBytes))
+	binary.LittleEndian.PutUint64(buf[16:], uint64(bucket.TxBytes))
+	binary.LittleEndian.PutUint64(buf[24:], uint64(bucket.LastHandshakeTime))
+	_, err := file.WriteAt(buf[:], offset)
+	return err
+}
+
+// openPeerFile opens the file of a peer, starting it over if it is new or
+// was not written by this version.
+func openPeerFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	var header [headerSize]byte
+	_, err = file.ReadAt(header[:], 0)
+	info, statErr := file.Stat()
+	if err != nil || statErr != nil || binary.LittleEndian.Uint32(header[:]) != magic || info.Size() != fileSize() {
+		binary.LittleEndian.PutUint32(header[:], magic)
+		err = file.Truncate(0)
+		if err == nil {
+			err = file.Truncate(fileSize())
+		}
+		if err == nil {
+			_, err = file.WriteAt(header[:], 0)
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return file, nil
+}
+
+// Record adds what a peer transferred since the previous sample, and its
+// latest handshake, to the slot of each period that now falls in.
+func (s *Store) Record(tunnelName string, fingerprint string, now time.Time, rx, tx conf.Bytes, lastHandshakeTime conf.HandshakeTime) error {
+	path, err := s.peerPath(tunnelName, fingerprint)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err = os.MkdirAll(filepath.Dir(path), os.ModeDir|0700)
+	if err != nil {
+		return err
+	}
+	file, err := openPeerFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for period, r := range rings {
+		start := now.Truncate(r.resolution)
+		offset := ringOffset(Period(period)) + start.Unix()/int64(r.resolution/time.Second)%r.slots*bucketSize
+		bucket, err := readBucket(file, offset)
+		if err != nil {
+			return err
+		}
+		if !bucket.Start.Equal(start) {
+			bucket = Bucket{Start: start}
+		}
+		bucket.RxBytes += rx
+		bucket.TxBytes += tx
+		if lastHandshakeTime > bucket.LastHandshakeTime {
+			bucket.LastHandshakeTime = lastHandshakeTime
+		}
+		err = writeBucket(file, offset, &bucket)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query returns the history of every peer of the tunnel over the period
+// before now, leaving out slots without samples.
+func (s *Store) Query(tunnelName string, period Period, now time.Time) ([]PeerHistory, error) {
+	if period < 0 || int(period) >= len(rings) {
+		return nil, errors.New("Period is not valid")
+	}
+	dir, err := s.tunnelDir(tunnelName)
+	if err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	paths, err := filepath.Glob(filepath.Join(dir, "*.bin"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	r := rings[period]
+	newest := now.Truncate(r.resolution)
+	oldest := newest.Add(-r.resolution * time.Duration(r.slots-1))
+	var histories []PeerHistory
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		var header [headerSize]byte
+		_, err = file.ReadAt(header[:], 0)
+		if err != nil || binary.LittleEndian.Uint32(header[:]) != magic {
+			file.Close()
+			continue
+		}
+		history := PeerHistory{Fingerprint: strings.TrimSuffix(filepath.Base(path), ".bin")}
+		for i := int64(0); i < r.slots; i++ {
+			bucket, err := readBucket(file, ringOffset(period)+i*bucketSize)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				file.Close()
+				return nil, err
+			}
+			if bucket.Start.IsZero() || bucket.Start.Before(oldest) || bucket.Start.After(newest) {
+				continue
+			}
+			history.Buckets = append(history.Buckets, bucket)
+		}
+		file.Close()
+		sort.Slice(history.Buckets, func(i, j int) bool { return history.Buckets[i].Start.Before(history.Buckets[j].Start) })
+		histories = append(histories, history)
+	}
+	return histories, nil
+}
+
+// Rename moves the history of a tunnel to its new name.
+func (s *Store) Rename(tunnelName string, newName string) error {
+	from, err := s.tunnelDir(tunnelName)
+	if err != nil {
+		return err
+	}
+	to, err := s.tunnelDir(newName)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	os.RemoveAll(to)
+	err = os.Rename(from, to)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Delete removes the history of a tunnel.
+func (s *Store) Delete(tunnelName string) error {
+	dir, err := s.tunnelDir(tunnelName)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return os.RemoveAll(dir)
+}
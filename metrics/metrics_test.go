@@ -0,0 +1,111 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package metrics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := NewStore(dir)
+
+	start := time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC)
+	handshake := conf.HandshakeTime(start.Sub(time.Unix(0, 0)))
+	// One sample a minute for two days, during which the second peer stops
+	// handshaking after the first day.
+	for minute := 0; minute < 2*24*60; minute++ {
+		now := start.Add(time.Duration(minute) * time.Minute)
+		if err := store.Record("office", "aaaaaaaa", now, 100, 10, handshake+conf.HandshakeTime(time.Duration(minute)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if minute < 24*60 {
+			if err := store.Record("office", "bbbbbbbb", now, 1, 1, handshake+conf.HandshakeTime(time.Duration(minute)*time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	end := start.Add(2*24*time.Hour - time.Minute)
+
+	histories, err := store.Query("office", PeriodHour, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(histories) != 2 || histories[0].Fingerprint != "aaaaaaaa" || histories[1].Fingerprint != "bbbbbbbb" {
+		t.Fatalf("Expected the history of both peers, not %+v", histories)
+	}
+	hour := histories[0].Buckets
+	if len(hour) != 60 || !hour[0].Start.Equal(end.Add(-59*time.Minute)) || !hour[59].Start.Equal(end) || hour[59].RxBytes != 100 || hour[59].TxBytes != 10 {
+		t.Errorf("Wrong hour of history: %d buckets, from %v to %v", len(hour), hour[0].Start, hour[len(hour)-1].Start)
+	}
+	if len(histories[1].Buckets) != 0 {
+		t.Errorf("A peer without samples in the last hour should have no buckets, not %d", len(histories[1].Buckets))
+	}
+
+	histories, err = store.Query("office", PeriodDay, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := histories[0].Buckets
+	if len(day) != 24*12 || day[1].RxBytes != 5*100 {
+		t.Errorf("Wrong day of history: %d buckets, %d bytes in the second", len(day), day[1].RxBytes)
+	}
+
+	histories, err = store.Query("office", PeriodMonth, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(histories[0].Buckets) != 2*24 || histories[0].Buckets[0].RxBytes != 60*100 {
+		t.Errorf("Wrong month of history: %d buckets", len(histories[0].Buckets))
+	}
+	stopped := histories[1].Buckets
+	if len(stopped) != 24 || stopped[23].LastHandshakeTime != handshake+conf.HandshakeTime(time.Duration(24*60-1)*time.Minute) {
+		t.Errorf("The month should show when the second peer last handshook, not %+v", stopped[len(stopped)-1])
+	}
+
+	// A month later, the slots have been overwritten or are too old.
+	later := end.Add(31 * 24 * time.Hour)
+	if err := store.Record("office", "aaaaaaaa", later, 1, 2, handshake); err != nil {
+		t.Fatal(err)
+	}
+	histories, err = store.Query("office", PeriodMonth, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(histories[0].Buckets) != 1 || histories[0].Buckets[0].RxBytes != 1 || len(histories[1].Buckets) != 0 {
+		t.Errorf("Old slots should not show up a month later: %+v", histories)
+	}
+
+	if err := store.Rename("office", "home"); err != nil {
+		t.Fatal(err)
+	}
+	if histories, err = store.Query("home", PeriodMonth, later); err != nil || len(histories) != 2 {
+		t.Errorf("History should follow the tunnel to its new name: %v", err)
+	}
+	if err := store.Delete("home"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "home")); !os.IsNotExist(err) {
+		t.Error("History of a deleted tunnel should be removed")
+	}
+
+	if err := store.Record("office", "../evil", start, 1, 1, 0); err == nil {
+		t.Error("Fingerprints should not be able to name other files")
+	}
+	if err := store.Record("../evil", "aaaaaaaa", start, 1, 1, 0); err == nil {
+		t.Error("Tunnel names should not be able to name other directories")
+	}
+}
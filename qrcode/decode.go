@@ -0,0 +1,549 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"  // for image.Decode
+	_ "image/jpeg" // for image.Decode
+	_ "image/png"  // for image.Decode
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+)
+
+// ErrNotFound is returned when no QR code can be seen in an image.
+var ErrNotFound = errors.New("No QR code found in image")
+
+// maxDecodePixels is the most pixels an image read by DecodeReader may have,
+// so that a small file claiming to be huge can't exhaust memory.
+const maxDecodePixels = 4096 * 4096
+
+// DecodeReader decodes the QR code in a PNG, JPEG or GIF file, refusing images
+// of more than maxDecodePixels before decoding them.
+func DecodeReader(r io.Reader) ([]byte, error) {
+	file, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(file))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > maxDecodePixels {
+		return nil, errors.New("Image is too large to look for a QR code in")
+	}
+	img, _, err := image.Decode(bytes.NewReader(file))
+	if err != nil {
+		return nil, err
+	}
+	return Decode(img)
+}
+
+// Decode returns the data of the QR code in img. The code may be scaled
+// and turned, but must otherwise be flat and sharp, as in a screenshot or an
+// image made by Code.Image, rather than a photograph.
+func Decode(img image.Image) ([]byte, error) {
+	b := newBitmap(img)
+	if b == nil {
+		return nil, ErrNotFound
+	}
+	finders := b.findFinderPatterns()
+	err := ErrNotFound
+	for _, corners := range arrangeFinderPatterns(finders) {
+		var data []byte
+		data, err = b.decodeAt(corners)
+		if err == nil {
+			return data, nil
+		}
+	}
+	return nil, err
+}
+
+// bitmap is an image reduced to dark and light pixels.
+type bitmap struct {
+	width, height int
+	dark          []bool
+}
+
+func newBitmap(img image.Image) *bitmap {
+	bounds := img.Bounds()
+	b := &bitmap{width: bounds.Dx(), height: bounds.Dy()}
+	luminance := make([]uint32, b.width*b.height)
+	lowest, highest := uint32(math.MaxUint32), uint32(0)
+	for y := 0; y < b.height; y++ {
+		for x := 0; x < b.width; x++ {
+			// Transparent pixels count as light, as they usually are when shown.
+			r, g, bl, a := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			l := (19595*(r+0xffff-a) + 38470*(g+0xffff-a) + 7471*(bl+0xffff-a)) >> 16
+			luminance[y*b.width+x] = l
+			if l < lowest {
+				lowest = l
+			}
+			if l > highest {
+				highest = l
+			}
+		}
+	}
+	if highest-lowest < 0x2000 {
+		return nil
+	}
+	threshold := (lowest + highest) / 2
+	b.dark = make([]bool, len(luminance))
+	for i, l := range luminance {
+		b.dark[i] = l < threshold
+	}
+	return b
+}
+
+// at returns 1 for a dark pixel, 0 for a light one and -1 outside the image.
+func (b *bitmap) at(x, y int) int {
+	if x < 0 || y < 0 || x >= b.width || y >= b.height {
+		return -1
+	}
+	if b.dark[y*b.width+x] {
+		return 1
+	}
+	return 0
+}
+
+type point struct {
+	x, y float64
+}
+
+func (p point) sub(q point) point {
+	return point{p.x - q.x, p.y - q.y}
+}
+
+func (p point) length() float64 {
+	return math.Hypot(p.x, p.y)
+}
+
+// finderPattern is one of the three squares in the corners of a code, found
+// count times while scanning.
+type finderPattern struct {
+	center point
+	module float64
+	count  int
+}
+
+// isFinderRatio tells whether five runs of pixels, dark, light, dark, light
+// and dark, have the 1:1:3:1:1 ratio of a line through a finder pattern.
+func isFinderRatio(runs [5]int) bool {
+	total := 0
+	for _, run := range runs {
+		if run == 0 {
+			return false
+		}
+		total += run
+	}
+	if total < 7 {
+		return false
+	}
+	module := float64(total) / 7
+	variance := module / 2
+	for i, run := range runs {
+		expected := module
+		if i == 2 {
+			expected *= 3
+		}
+		if math.Abs(float64(run)-expected) >= variance*expected/module {
+			return false
+		}
+	}
+	return true
+}
+
+// crossCheck measures the runs of pixels along a line through pos, where
+// at(i) gives the pixel at position i on the line. It returns the position of
+// the middle of the centre run and the length of the five runs together.
+func crossCheck(at func(int) int, pos int) (float64, int, bool) {
+	if at(pos) != 1 {
+		return 0, 0, false
+	}
+	var runs [5]int
+	start := pos
+	for at(start-1) == 1 {
+		start--
+	}
+	end := pos + 1
+	for at(end) == 1 {
+		end++
+	}
+	runs[2] = end - start
+	count := func(i, step, colour int) (int, int) {
+		n := 0
+		for ; at(i) == colour; i += step {
+			n++
+		}
+		return n, i
+	}
+	var i int
+	runs[1], i = count(start-1, -1, 0)
+	runs[0], _ = count(i, -1, 1)
+	runs[3], i = count(end, 1, 0)
+	runs[4], _ = count(i, 1, 1)
+	if !isFinderRatio(runs) {
+		return 0, 0, false
+	}
+	return float64(start+end) / 2, runs[0] + runs[1] + runs[2] + runs[3] + runs[4], true
+}
+
+// maxFinderPatterns is the most candidate finder patterns collected from an
+// image. Each pattern seen is compared with every candidate found so far, so
+// without a bound an image crafted to be full of them takes minutes to scan,
+// whereas a real code needs only three.
+const maxFinderPatterns = 64
+
+// findFinderPatterns scans the rows of the bitmap for finder patterns,
+// merging those seen on several rows, and stops once it has found
+// maxFinderPatterns of them.
+func (b *bitmap) findFinderPatterns() []*finderPattern {
+	var found []*finderPattern
+	for y := 0; y < b.height && len(found) < maxFinderPatterns; y++ {
+		// The runs of alternating colour along the row, starting with light.
+		var starts []int
+		previous := 0
+		for x := 0; x < b.width; x++ {
+			if pixel := b.at(x, y); pixel != previous {
+				starts = append(starts, x)
+				previous = pixel
+			}
+		}
+		starts = append(starts, b.width)
+		for i := 0; i+5 < len(starts); i += 2 {
+			var runs [5]int
+			for j := range runs {
+				runs[j] = starts[i+j+1] - starts[i+j]
+			}
+			if !isFinderRatio(runs) {
+				continue
+			}
+			x := (starts[i+2] + starts[i+3]) / 2
+			cy, height, ok := crossCheck(func(i int) int { return b.at(x, i) }, y)
+			if !ok {
+				continue
+			}
+			row := int(cy)
+			cx, width, ok := crossCheck(func(i int) int { return b.at(i, row) }, x)
+			if !ok || width > 2*height || height > 2*width {
+				continue
+			}
+			pattern := &finderPattern{center: point{cx, cy}, module: float64(width+height) / 14, count: 1}
+			merged := false
+			for _, other := range found {
+				if other.center.sub(pattern.center).length() <= other.module*2 && math.Abs(other.module-pattern.module) <= other.module/2 {
+					n := float64(other.count)
+					other.center = point{(other.center.x*n + cx) / (n + 1), (other.center.y*n + cy) / (n + 1)}
+					other.module = (other.module*n + pattern.module) / (n + 1)
+					other.count++
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				found = append(found, pattern)
+				if len(found) == maxFinderPatterns {
+					break
+				}
+			}
+		}
+	}
+	return found
+}
+
+// arrangeFinderPatterns returns the likeliest sets of three finder patterns
+// that could be the top left, top right and bottom left corners of a code.
+func arrangeFinderPatterns(found []*finderPattern) [][3]*finderPattern {
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].count > found[j].count
+	})
+	if len(found) > 8 {
+		found = found[:8]
+	}
+	var arrangements [][3]*finderPattern
+	for i := 0; i < len(found); i++ {
+		for j := i + 1; j < len(found); j++ {
+			for k := j + 1; k < len(found); k++ {
+				a, b, c := found[i], found[j], found[k]
+				// The corner with the right angle is opposite the longest side.
+				ab, ac, bc := b.center.sub(a.center).length(), c.center.sub(a.center).length(), c.center.sub(b.center).length()
+				if ab > bc && ab > ac {
+					a, c = c, a
+				} else if ac > bc && ac > ab {
+					a, b = b, a
+				}
+				top, left := b.center.sub(a.center), c.center.sub(a.center)
+				if top.x*left.y-top.y*left.x < 0 {
+					b, c = c, b
+					top, left = left, top
+				}
+				sides := math.Max(top.length(), left.length())
+				if math.Abs(top.length()-left.length()) > sides/5 || math.Abs(top.x*left.x+top.y*left.y) > sides*sides/5 {
+					continue
+				}
+				modules := []float64{a.module, b.module, c.module}
+				sort.Float64s(modules)
+				if modules[2] > modules[0]*1.5 || sides < a.module*14 {
+					continue
+				}
+				arrangements = append(arrangements, [3]*finderPattern{a, b, c})
+			}
+		}
+	}
+	sort.SliceStable(arrangements, func(i, j int) bool {
+		score := func(corners [3]*finderPattern) int {
+			return corners[0].count + corners[1].count + corners[2].count
+		}
+		return score(arrangements[i]) > score(arrangements[j])
+	})
+	return arrangements
+}
+
+// moduleAlong measures the size of a module by walking from the centre of a
+// finder pattern towards another one until leaving its outer ring, three and
+// a half modules away. This works whichever way the code is turned, unlike the
+// runs found while scanning rows.
+func (b *bitmap) moduleAlong(from, to point) float64 {
+	direction := to.sub(from)
+	distance := direction.length()
+	previous, transitions := 1, 0
+	for t := 0.0; t < distance; t += 0.25 {
+		pixel := b.at(int(math.Floor(from.x+direction.x*t/distance)), int(math.Floor(from.y+direction.y*t/distance)))
+		if pixel != previous {
+			previous = pixel
+			transitions++
+			if transitions == 3 {
+				return t / 3.5
+			}
+		}
+	}
+	return 0
+}
+
+// decodeAt reads the code whose top left, top right and bottom left finder
+// patterns are corners, trying the versions closest to what the distance
+// between them suggests.
+func (b *bitmap) decodeAt(corners [3]*finderPattern) ([]byte, error) {
+	topLeft, topRight, bottomLeft := corners[0].center, corners[1].center, corners[2].center
+	top, left := topRight.sub(topLeft), bottomLeft.sub(topLeft)
+	across := (b.moduleAlong(topLeft, topRight) + b.moduleAlong(topRight, topLeft)) / 2
+	down := (b.moduleAlong(topLeft, bottomLeft) + b.moduleAlong(bottomLeft, topLeft)) / 2
+	if across == 0 || down == 0 {
+		return nil, ErrNotFound
+	}
+	dimension := (top.length()/across+left.length()/down)/2 + 7
+	estimate := int(math.Floor((dimension-17)/4 + 0.5))
+	err := ErrNotFound
+	for _, version := range []int{estimate, estimate - 1, estimate + 1} {
+		if version < minVersion || version > maxVersion {
+			continue
+		}
+		size := sizeOfVersion(version)
+		span := float64(size - 7)
+		dark := func(x, y int) bool {
+			u, v := (float64(x)-3)/span, (float64(y)-3)/span
+			return b.at(int(math.Floor(topLeft.x+u*top.x+v*left.x)), int(math.Floor(topLeft.y+u*top.y+v*left.y))) == 1
+		}
+		var data []byte
+		data, err = decodeGrid(version, dark)
+		if err == nil {
+			return data, nil
+		}
+	}
+	return nil, err
+}
+
+// decodeGrid decodes a code of version whose modules are given by dark.
+func decodeGrid(version int, dark func(x, y int) bool) ([]byte, error) {
+	g := newGrid(version)
+	first, second := g.formatPositions()
+	readFirst, readSecond := 0, 0
+	for i := 0; i < 15; i++ {
+		if dark(first[i][0], first[i][1]) {
+			readFirst |= 1 << uint(i)
+		}
+		if dark(second[i][0], second[i][1]) {
+			readSecond |= 1 << uint(i)
+		}
+	}
+	level, mask, best := Low, 0, 16
+	for l := Low; l <= High; l++ {
+		for m := 0; m < 8; m++ {
+			bits := formatInformation(l, m)
+			for _, read := range []int{readFirst, readSecond} {
+				if distance := bitCount(bits ^ read); distance < best {
+					level, mask, best = l, m, distance
+				}
+			}
+		}
+	}
+	if best > 3 {
+		return nil, errors.New("Unreadable QR code format")
+	}
+
+	codewords := make([]byte, rawCodewords(version))
+	i := 0
+	g.dataPositions(func(x, y int) {
+		if i < len(codewords)*8 && dark(x, y) != masked(mask, x, y) {
+			codewords[i/8] |= 0x80 >> uint(i%8)
+		}
+		i++
+	})
+
+	eccLength := eccCodewordsPerBlock[level][version]
+	lengths := blockLengths(version, level)
+	blocks := make([][]byte, len(lengths))
+	for j, length := range lengths {
+		blocks[j] = make([]byte, length+eccLength)
+	}
+	k := 0
+	for i := 0; i < lengths[len(lengths)-1]; i++ {
+		for j, length := range lengths {
+			if i < length {
+				blocks[j][i] = codewords[k]
+				k++
+			}
+		}
+	}
+	for i := 0; i < eccLength; i++ {
+		for j, length := range lengths {
+			blocks[j][length+i] = codewords[k]
+			k++
+		}
+	}
+	var data []byte
+	for j, block := range blocks {
+		if _, err := rsCorrect(block, eccLength); err != nil {
+			return nil, err
+		}
+		data = append(data, block[:lengths[j]]...)
+	}
+	return parseSegments(data, version)
+}
+
+func bitCount(n int) int {
+	count := 0
+	for ; n != 0; n &= n - 1 {
+		count++
+	}
+	return count
+}
+
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) remaining() int {
+	return len(r.data)*8 - r.pos
+}
+
+func (r *bitReader) read(count int) (int, bool) {
+	if count > r.remaining() {
+		return 0, false
+	}
+	value := 0
+	for i := 0; i < count; i++ {
+		value = value<<1 | int(r.data[r.pos/8]>>uint(7-r.pos%8)&1)
+		r.pos++
+	}
+	return value, true
+}
+
+const alphanumericCharacters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+var errTruncated = errors.New("QR code data is truncated")
+
+// parseSegments reads the numeric, alphanumeric and byte segments in the data
+// codewords of a code.
+func parseSegments(data []byte, version int) ([]byte, error) {
+	sizeClass := 0
+	if version >= 27 {
+		sizeClass = 2
+	} else if version >= 10 {
+		sizeClass = 1
+	}
+	r := &bitReader{data: data}
+	var result []byte
+	for r.remaining() >= 4 {
+		mode, _ := r.read(4)
+		var countBits int
+		switch mode {
+		case 0:
+			return result, nil
+		case 1:
+			countBits = [...]int{10, 12, 14}[sizeClass]
+		case 2:
+			countBits = [...]int{9, 11, 13}[sizeClass]
+		case 4:
+			countBits = [...]int{8, 16, 16}[sizeClass]
+		case 7:
+			// The character set is ignored, as the text is decoded later
+			// in whichever encoding it turns out to be in.
+			designator, ok := r.read(8)
+			if ok && designator&0x80 != 0 {
+				if designator&0x40 == 0 {
+					_, ok = r.read(8)
+				} else {
+					_, ok = r.read(16)
+				}
+			}
+			if !ok {
+				return nil, errTruncated
+			}
+			continue
+		default:
+			return nil, errors.New("QR code uses an unsupported mode")
+		}
+		count, ok := r.read(countBits)
+		if !ok {
+			return nil, errTruncated
+		}
+		switch mode {
+		case 1:
+			for ; count > 0 && ok; count -= 3 {
+				digits, bits := 3, 10
+				if count == 2 {
+					digits, bits = 2, 7
+				} else if count == 1 {
+					digits, bits = 1, 4
+				}
+				var value int
+				if value, ok = r.read(bits); ok {
+					for d := digits - 1; d >= 0; d-- {
+						result = append(result, byte('0'+value/int(math.Pow10(d))%10))
+					}
+				}
+			}
+		case 2:
+			for ; count > 0 && ok; count -= 2 {
+				var value int
+				if count == 1 {
+					if value, ok = r.read(6); ok && value < 45 {
+						result = append(result, alphanumericCharacters[value])
+					}
+				} else if value, ok = r.read(11); ok && value < 45*45 {
+					result = append(result, alphanumericCharacters[value/45], alphanumericCharacters[value%45])
+				}
+			}
+		case 4:
+			for ; count > 0 && ok; count-- {
+				var value int
+				if value, ok = r.read(8); ok {
+					result = append(result, byte(value))
+				}
+			}
+		}
+		if !ok {
+			return nil, errTruncated
+		}
+	}
+	return result, nil
+}
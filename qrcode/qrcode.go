@@ -0,0 +1,494 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package qrcode encodes data as QR codes and decodes them from images, for
+// moving tunnel configurations to and from phones.
+package qrcode
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// ErrorCorrectionLevel is how much of a QR code may be damaged while still
+// being readable: about 7%, 15%, 25% and 30% respectively.
+type ErrorCorrectionLevel int
+
+const (
+	Low ErrorCorrectionLevel = iota
+	Medium
+	Quartile
+	High
+)
+
+// formatBits are the two bits that stand for each level in the format
+// information.
+var formatBits = [...]int{Low: 1, Medium: 0, Quartile: 3, High: 2}
+
+const (
+	minVersion = 1
+	maxVersion = 40
+
+	// QuietZone is the width in modules of the light border around a code.
+	QuietZone = 4
+)
+
+// Error correction codewords per block and number of blocks, by level and
+// version, from ISO/IEC 18004 table 9.
+var eccCodewordsPerBlock = [4][maxVersion + 1]int{
+	Low:      {0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	Medium:   {0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	Quartile: {0, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	High:     {0, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var eccBlocks = [4][maxVersion + 1]int{
+	Low:      {0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	Medium:   {0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	Quartile: {0, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	High:     {0, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// ErrTooLong is returned when data does not fit in even the largest code.
+var ErrTooLong = errors.New("Data is too long for a QR code")
+
+// Code is a QR code, a square of modules that are either dark or light.
+type Code struct {
+	Version int
+	Level   ErrorCorrectionLevel
+	Mask    int
+	Size    int
+	modules []bool
+}
+
+// Dark tells whether the module at column x and row y is dark. Coordinates
+// outside of the code are in the quiet zone, which is light.
+func (c *Code) Dark(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+		return false
+	}
+	return c.modules[y*c.Size+x]
+}
+
+func sizeOfVersion(version int) int {
+	return version*4 + 17
+}
+
+// rawCodewords is the number of codewords that fit in a code of version, data
+// and error correction together.
+func rawCodewords(version int) int {
+	modules := (16*version+128)*version + 64
+	if version >= 2 {
+		alignments := version/7 + 2
+		modules -= (25*alignments-10)*alignments - 55
+		if version >= 7 {
+			modules -= 36
+		}
+	}
+	return modules / 8
+}
+
+func dataCodewords(version int, level ErrorCorrectionLevel) int {
+	return rawCodewords(version) - eccCodewordsPerBlock[level][version]*eccBlocks[level][version]
+}
+
+// byteCountBits is the width of the length of byte mode data.
+func byteCountBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+// alignmentPositions returns the rows, and columns, of the centres of the
+// alignment patterns.
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	count := version/7 + 2
+	step := (version*8 + count*3 + 5) / (count*4 - 4) * 2
+	positions := make([]int, count)
+	positions[0] = 6
+	for i, pos := count-1, sizeOfVersion(version)-7; i > 0; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+// formatInformation is the 15 bit BCH code of the level and mask, already
+// masked with 101010000010010.
+func formatInformation(level ErrorCorrectionLevel, mask int) int {
+	data := formatBits[level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// versionInformation is the 18 bit BCH code of a version of 7 or above.
+func versionInformation(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1f25
+	}
+	return version<<12 | rem
+}
+
+func masked(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// grid is a code being built or read, along with which of its modules belong
+// to function patterns rather than to data.
+type grid struct {
+	size     int
+	modules  []bool
+	function []bool
+}
+
+func newGrid(version int) *grid {
+	size := sizeOfVersion(version)
+	g := &grid{size: size, modules: make([]bool, size*size), function: make([]bool, size*size)}
+	g.drawFunctionPatterns(version)
+	return g
+}
+
+func (g *grid) set(x, y int, dark bool) {
+	g.modules[y*g.size+x] = dark
+	g.function[y*g.size+x] = true
+}
+
+func (g *grid) drawFunctionPatterns(version int) {
+	for i := 0; i < g.size; i++ {
+		g.set(6, i, i%2 == 0)
+		g.set(i, 6, i%2 == 0)
+	}
+	for _, corner := range [][2]int{{3, 3}, {g.size - 4, 3}, {3, g.size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := corner[0]+dx, corner[1]+dy
+				if x < 0 || y < 0 || x >= g.size || y >= g.size {
+					continue
+				}
+				distance := max(abs(dx), abs(dy))
+				g.set(x, y, distance != 2 && distance != 4)
+			}
+		}
+	}
+	positions := alignmentPositions(version)
+	for i, y := range positions {
+		for j, x := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == len(positions)-1) || (i == len(positions)-1 && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					g.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+	// Reserve the format information until the mask is known.
+	g.drawFormat(0)
+	if version >= 7 {
+		bits := versionInformation(version)
+		for i := 0; i < 18; i++ {
+			dark := bits>>uint(i)&1 != 0
+			a, b := g.size-11+i%3, i/3
+			g.set(a, b, dark)
+			g.set(b, a, dark)
+		}
+	}
+}
+
+// formatPositions lists where each bit of the format information goes, first
+// around the top left finder pattern and then split between the other two.
+func (g *grid) formatPositions() (first, second [15][2]int) {
+	for i := 0; i < 15; i++ {
+		switch {
+		case i < 6:
+			first[i] = [2]int{8, i}
+		case i < 8:
+			first[i] = [2]int{8, i + 1}
+		case i == 8:
+			first[i] = [2]int{7, 8}
+		default:
+			first[i] = [2]int{14 - i, 8}
+		}
+		if i < 8 {
+			second[i] = [2]int{g.size - 1 - i, 8}
+		} else {
+			second[i] = [2]int{8, g.size - 15 + i}
+		}
+	}
+	return
+}
+
+func (g *grid) drawFormat(bits int) {
+	first, second := g.formatPositions()
+	for i := 0; i < 15; i++ {
+		dark := bits>>uint(i)&1 != 0
+		g.set(first[i][0], first[i][1], dark)
+		g.set(second[i][0], second[i][1], dark)
+	}
+	g.set(8, g.size-8, true)
+}
+
+// dataPositions calls f with each module that holds data, in the order in
+// which the bits are placed: upwards and downwards in columns two modules wide,
+// starting from the bottom right.
+func (g *grid) dataPositions(f func(x, y int)) {
+	for right := g.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < g.size; vert++ {
+			y := vert
+			if upward {
+				y = g.size - 1 - vert
+			}
+			for x := right; x > right-2; x-- {
+				if !g.function[y*g.size+x] {
+					f(x, y)
+				}
+			}
+		}
+	}
+}
+
+func (g *grid) applyMask(mask int) {
+	for y := 0; y < g.size; y++ {
+		for x := 0; x < g.size; x++ {
+			if !g.function[y*g.size+x] && masked(mask, x, y) {
+				g.modules[y*g.size+x] = !g.modules[y*g.size+x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code is to read, following the rules of the
+// standard for picking a mask.
+func (g *grid) penalty() int {
+	score := 0
+	dark := 0
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			x, y = y, x
+		}
+		return g.modules[y*g.size+x]
+	}
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < g.size; y++ {
+			run := 0
+			var history uint // the last 11 modules, most recent lowest
+			for x := 0; x < g.size; x++ {
+				module := at(x, y, transpose)
+				if x > 0 && module == at(x-1, y, transpose) {
+					run++
+					if run == 5 {
+						score += 3
+					} else if run > 5 {
+						score++
+					}
+				} else {
+					run = 1
+				}
+				history = history << 1 & 0x7ff
+				if module {
+					history |= 1
+				}
+				if x >= 10 && (history == 0x5d0 || history == 0x05d) {
+					score += 40
+				}
+			}
+		}
+	}
+	for y := 0; y < g.size; y++ {
+		for x := 0; x < g.size; x++ {
+			module := g.modules[y*g.size+x]
+			if module {
+				dark++
+			}
+			if x > 0 && y > 0 && module == g.modules[y*g.size+x-1] && module == g.modules[(y-1)*g.size+x] && module == g.modules[(y-1)*g.size+x-1] {
+				score += 3
+			}
+		}
+	}
+	total := g.size * g.size
+	score += (abs(dark*20-total*10)+total-1)/total*10 - 10
+	return score
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Encode makes the smallest QR code holding data, in byte mode, with at least
+// the given error correction level. A higher level is used when it fits in
+// the same size.
+func Encode(data []byte, level ErrorCorrectionLevel) (*Code, error) {
+	version := minVersion
+	for ; ; version++ {
+		if version > maxVersion {
+			return nil, ErrTooLong
+		}
+		if 4+byteCountBits(version)+len(data)*8 <= dataCodewords(version, level)*8 {
+			break
+		}
+	}
+	for level < High && 4+byteCountBits(version)+len(data)*8 <= dataCodewords(version, level+1)*8 {
+		level++
+	}
+
+	capacity := dataCodewords(version, level)
+	bits := &bitWriter{}
+	bits.write(0x4, 4)
+	bits.write(len(data), byteCountBits(version))
+	for _, b := range data {
+		bits.write(int(b), 8)
+	}
+	terminator := capacity*8 - bits.length
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.write(0, terminator)
+	bits.write(0, (8-bits.length%8)%8)
+	for pad := 0xec; len(bits.bytes) < capacity; pad ^= 0xec ^ 0x11 {
+		bits.write(pad, 8)
+	}
+
+	codewords := interleave(bits.bytes, version, level)
+	g := newGrid(version)
+	i := 0
+	g.dataPositions(func(x, y int) {
+		if i < len(codewords)*8 {
+			g.modules[y*g.size+x] = codewords[i/8]>>uint(7-i%8)&1 != 0
+		}
+		i++
+	})
+
+	bestMask, bestPenalty := 0, 0
+	for mask := 0; mask < 8; mask++ {
+		g.applyMask(mask)
+		g.drawFormat(formatInformation(level, mask))
+		if penalty := g.penalty(); mask == 0 || penalty < bestPenalty {
+			bestMask, bestPenalty = mask, penalty
+		}
+		g.applyMask(mask)
+	}
+	g.applyMask(bestMask)
+	g.drawFormat(formatInformation(level, bestMask))
+
+	return &Code{Version: version, Level: level, Mask: bestMask, Size: g.size, modules: g.modules}, nil
+}
+
+type bitWriter struct {
+	bytes  []byte
+	length int
+}
+
+func (w *bitWriter) write(value int, count int) {
+	for i := count - 1; i >= 0; i-- {
+		if w.length%8 == 0 {
+			w.bytes = append(w.bytes, 0)
+		}
+		if value>>uint(i)&1 != 0 {
+			w.bytes[w.length/8] |= 0x80 >> uint(w.length%8)
+		}
+		w.length++
+	}
+}
+
+// blockLengths returns the number of data codewords in each block. Blocks at
+// the end are one codeword longer than those at the start when the codewords
+// don't divide evenly.
+func blockLengths(version int, level ErrorCorrectionLevel) []int {
+	blocks := eccBlocks[level][version]
+	data := dataCodewords(version, level)
+	lengths := make([]int, blocks)
+	for i := range lengths {
+		lengths[i] = data / blocks
+		if i >= blocks-data%blocks {
+			lengths[i]++
+		}
+	}
+	return lengths
+}
+
+// interleave splits data into blocks, adds error correction to each of them
+// and interleaves the result.
+func interleave(data []byte, version int, level ErrorCorrectionLevel) []byte {
+	eccLength := eccCodewordsPerBlock[level][version]
+	lengths := blockLengths(version, level)
+	var blocks, eccs [][]byte
+	for _, length := range lengths {
+		blocks = append(blocks, data[:length])
+		eccs = append(eccs, rsEncode(data[:length], eccLength))
+		data = data[length:]
+	}
+	result := make([]byte, 0, rawCodewords(version))
+	for i := 0; i < lengths[len(lengths)-1]; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < eccLength; i++ {
+		for _, ecc := range eccs {
+			result = append(result, ecc[i])
+		}
+	}
+	return result
+}
+
+// Image draws the code with each module scale pixels wide, surrounded by the
+// quiet zone.
+func (c *Code) Image(scale int) *image.Paletted {
+	if scale < 1 {
+		scale = 1
+	}
+	width := (c.Size + 2*QuietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, width, width), color.Palette{color.White, color.Black})
+	for y := 0; y < width; y++ {
+		for x := 0; x < width; x++ {
+			if c.Dark(x/scale-QuietZone, y/scale-QuietZone) {
+				img.Pix[y*img.Stride+x] = 1
+			}
+		}
+	}
+	return img
+}
+
+// WritePNG writes the image of the code as a PNG file.
+func (c *Code) WritePNG(w io.Writer, scale int) error {
+	return png.Encode(w, c.Image(scale))
+}
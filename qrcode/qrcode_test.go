@@ -0,0 +1,290 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package qrcode
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+const testConfig = `[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Address = 10.192.122.1/24, 10.10.0.1/16
+DNS = 8.8.8.8, 8.8.4.4
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 0.0.0.0/0, ::/0
+Endpoint = 192.95.5.67:1234
+PersistentKeepalive = 25
+`
+
+func TestCapacity(t *testing.T) {
+	// Data codewords from ISO/IEC 18004 table 7.
+	for _, test := range []struct {
+		version int
+		level   ErrorCorrectionLevel
+		data    int
+	}{
+		{1, Low, 19}, {1, High, 9}, {2, Medium, 28}, {7, Quartile, 88},
+		{10, Medium, 216}, {21, High, 406}, {33, Low, 2071}, {40, Low, 2956}, {40, High, 1276},
+	} {
+		if data := dataCodewords(test.version, test.level); data != test.data {
+			t.Errorf("Version %d level %d should hold %d data codewords, not %d", test.version, test.level, test.data, data)
+		}
+	}
+	for version := minVersion; version <= maxVersion; version++ {
+		for level := Low; level <= High; level++ {
+			total := 0
+			for _, length := range blockLengths(version, level) {
+				total += length
+			}
+			if total != dataCodewords(version, level) {
+				t.Errorf("Blocks of version %d level %d don't add up", version, level)
+			}
+		}
+	}
+}
+
+func TestInformationBits(t *testing.T) {
+	// Examples from ISO/IEC 18004 annexes C and D.
+	if bits := formatInformation(Medium, 5); bits != 0x40ce {
+		t.Errorf("Format information of M and mask 5 should be 0x40ce, not %#x", bits)
+	}
+	if bits := formatInformation(Low, 0); bits != 0x77c4 {
+		t.Errorf("Format information of L and mask 0 should be 0x77c4, not %#x", bits)
+	}
+	if bits := versionInformation(7); bits != 0x07c94 {
+		t.Errorf("Version information of version 7 should be 0x07c94, not %#x", bits)
+	}
+	positions := alignmentPositions(32)
+	if len(positions) != 6 || positions[0] != 6 || positions[1] != 34 || positions[5] != 138 {
+		t.Errorf("Wrong alignment pattern positions for version 32: %v", positions)
+	}
+}
+
+func TestReedSolomon(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, eccLength := range []int{7, 10, 22, 30} {
+		data := make([]byte, 50)
+		random.Read(data)
+		block := append(append([]byte(nil), data...), rsEncode(data, eccLength)...)
+		for errors := 0; errors <= eccLength/2; errors++ {
+			damaged := append([]byte(nil), block...)
+			for _, i := range random.Perm(len(block))[:errors] {
+				damaged[i] ^= byte(1 + random.Intn(255))
+			}
+			corrected, err := rsCorrect(damaged, eccLength)
+			if err != nil {
+				t.Errorf("%d errors with %d codewords of correction: %v", errors, eccLength, err)
+				continue
+			}
+			if corrected != errors || !bytes.Equal(damaged, block) {
+				t.Errorf("%d errors with %d codewords of correction were not corrected", errors, eccLength)
+			}
+		}
+	}
+}
+
+func roundTrip(t *testing.T, img image.Image, want []byte) {
+	t.Helper()
+	data, err := Decode(img)
+	if err != nil {
+		t.Errorf("Decoding failed: %v", err)
+	} else if !bytes.Equal(data, want) {
+		t.Errorf("Decoded %q instead of %q", data, want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(2))
+	for _, length := range []int{0, 1, 17, 100, 400, 1000, 2000, 2953} {
+		data := make([]byte, length)
+		random.Read(data)
+		for _, level := range []ErrorCorrectionLevel{Low, High} {
+			code, err := Encode(data, level)
+			if length > 1273 && level == High {
+				if err != ErrTooLong {
+					t.Errorf("%d bytes should not fit with high error correction", length)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("Encoding %d bytes: %v", length, err)
+				continue
+			}
+			if code.Level < level {
+				t.Errorf("Encoding %d bytes used level %d instead of at least %d", length, code.Level, level)
+			}
+			roundTrip(t, code.Image(2), data)
+		}
+	}
+	if _, err := Encode(make([]byte, 2954), Low); err != ErrTooLong {
+		t.Errorf("2954 bytes should be too long, not give %v", err)
+	}
+}
+
+func TestDecodeConfig(t *testing.T) {
+	code, err := Encode([]byte(testConfig), Medium)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = code.WritePNG(&buf, 3); err != nil {
+		t.Fatal(err)
+	}
+	data, err := DecodeReader(&buf)
+	if err != nil {
+		t.Fatalf("Decoding PNG failed: %v", err)
+	}
+	if string(data) != testConfig {
+		t.Errorf("Decoded %q", data)
+	}
+
+	// Within a larger picture, in colour, with an odd scale, offset and
+	// some modules damaged.
+	small := code.Image(3)
+	bounds := small.Bounds()
+	scaled := image.Rect(40, 30, 40+bounds.Dx()*5/3, 30+bounds.Dy()*5/3)
+	picture := image.NewRGBA(image.Rect(0, 0, scaled.Max.X+83, scaled.Max.Y+47))
+	draw.Draw(picture, picture.Bounds(), image.NewUniform(color.RGBA{0x20, 0x30, 0x60, 0xff}), image.Point{}, draw.Src)
+	for y := scaled.Min.Y; y < scaled.Max.Y; y++ {
+		for x := scaled.Min.X; x < scaled.Max.X; x++ {
+			if small.ColorIndexAt((x-scaled.Min.X)*3/5, (y-scaled.Min.Y)*3/5) == 1 {
+				picture.Set(x, y, color.RGBA{0x10, 0x10, 0x10, 0xff})
+			} else {
+				picture.Set(x, y, color.RGBA{0xf0, 0xf0, 0xe0, 0xff})
+			}
+		}
+	}
+	for i := 0; i < 5; i++ {
+		x, y := scaled.Min.X+(QuietZone+code.Size/2+i*2)*5, scaled.Min.Y+(QuietZone+code.Size/2)*5
+		draw.Draw(picture, image.Rect(x, y, x+5, y+5), image.Black, image.Point{}, draw.Src)
+	}
+	roundTrip(t, picture, []byte(testConfig))
+}
+
+// rotate turns img by angle degrees clockwise around its centre, with a light
+// background, sampling the nearest pixel.
+func rotate(img image.Image, angle float64) image.Image {
+	bounds := img.Bounds()
+	side := int(float64(bounds.Dx()) * 1.5)
+	rotated := image.NewGray(image.Rect(0, 0, side, side))
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			dx, dy := float64(x)-float64(side)/2, float64(y)-float64(side)/2
+			sx := int(math.Floor(cos*dx + sin*dy + float64(bounds.Dx())/2))
+			sy := int(math.Floor(-sin*dx + cos*dy + float64(bounds.Dy())/2))
+			if image.Pt(sx, sy).In(bounds) {
+				rotated.Set(x, y, img.At(sx, sy))
+			} else {
+				rotated.Set(x, y, color.White)
+			}
+		}
+	}
+	return rotated
+}
+
+func TestDecodeRotated(t *testing.T) {
+	code, err := Encode([]byte(testConfig), Medium)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, angle := range []float64{90, 180, 270, 10, 45} {
+		scale := 2
+		if angle != math.Trunc(angle/90)*90 {
+			scale = 6
+		}
+		data, err := Decode(rotate(code.Image(scale), angle))
+		if err != nil {
+			t.Errorf("Decoding a code turned by %v° failed: %v", angle, err)
+		} else if string(data) != testConfig {
+			t.Errorf("Decoding a code turned by %v° gave %q", angle, data)
+		}
+	}
+}
+
+func TestDecodeSegments(t *testing.T) {
+	// "01234567" in numeric mode then "AC-42" in alphanumeric mode, at version 1.
+	bits := &bitWriter{}
+	bits.write(1, 4)
+	bits.write(8, 10)
+	bits.write(12, 10)
+	bits.write(345, 10)
+	bits.write(67, 7)
+	bits.write(2, 4)
+	bits.write(5, 9)
+	bits.write(10*45+12, 11)
+	bits.write(41*45+4, 11)
+	bits.write(2, 6)
+	bits.write(0, 4)
+	data, err := parseSegments(bits.bytes, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "01234567AC-42" {
+		t.Errorf("Decoded %q", data)
+	}
+}
+
+func TestDecodeNothing(t *testing.T) {
+	blank := image.NewGray(image.Rect(0, 0, 100, 100))
+	if _, err := Decode(blank); err != ErrNotFound {
+		t.Errorf("Blank image should give ErrNotFound, not %v", err)
+	}
+	if _, err := DecodeReader(bytes.NewReader([]byte("not an image"))); err == nil {
+		t.Error("Decoding garbage should fail")
+	}
+
+	var huge bytes.Buffer
+	if err := png.Encode(&huge, image.NewGray(image.Rect(0, 0, 4097, 4096))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeReader(&huge); err == nil || err == ErrNotFound {
+		t.Errorf("Images over the pixel budget should be refused, not give %v", err)
+	}
+}
+
+func TestDecodeManyFinderPatterns(t *testing.T) {
+	// Tiles of a finder pattern with a light border, one pixel per module.
+	tiled := image.NewGray(image.Rect(0, 0, 1024, 1024))
+	draw.Draw(tiled, tiled.Bounds(), image.White, image.Point{}, draw.Src)
+	for y := 0; y < 1024; y++ {
+		for x := 0; x < 1024; x++ {
+			dx, dy := x%9-4, y%9-4
+			if dx < 0 {
+				dx = -dx
+			}
+			if dy < 0 {
+				dy = -dy
+			}
+			ring := dx
+			if dy > ring {
+				ring = dy
+			}
+			if ring == 3 || ring <= 1 {
+				tiled.SetGray(x, y, color.Gray{0})
+			}
+		}
+	}
+	b := newBitmap(tiled)
+	if b == nil {
+		t.Fatal("Tiled image should give a bitmap")
+	}
+	if found := b.findFinderPatterns(); len(found) != maxFinderPatterns {
+		t.Errorf("Scanning should stop at %d finder patterns, not %d", maxFinderPatterns, len(found))
+	}
+	if _, err := Decode(tiled); err != ErrNotFound {
+		t.Errorf("Tiled finder patterns should give ErrNotFound, not %v", err)
+	}
+}
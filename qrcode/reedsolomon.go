@@ -0,0 +1,195 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package qrcode
+
+import (
+	"errors"
+)
+
+// Arithmetic in GF(256) with the reducing polynomial x⁸+x⁴+x³+x²+1 and
+// generator 2, as used by QR codes.
+var gfExp, gfLog = func() (exp [512]byte, log [256]byte) {
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		log[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(exp); i++ {
+		exp[i] = exp[i-255]
+	}
+	return
+}()
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// gfPow returns 2ⁿ.
+func gfPow(n int) byte {
+	n %= 255
+	if n < 0 {
+		n += 255
+	}
+	return gfExp[n]
+}
+
+// Polynomials below are slices of coefficients, lowest degree first.
+func polyEval(p []byte, x byte) byte {
+	var y byte
+	for i := len(p) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ p[i]
+	}
+	return y
+}
+
+// rsGenerator returns (x-2⁰)(x-2¹)…(x-2ⁿ⁻¹), highest degree first and
+// without its leading coefficient, which is 1.
+func rsGenerator(n int) []byte {
+	g := make([]byte, n)
+	g[n-1] = 1
+	root := byte(1)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			g[j] = gfMul(g[j], root)
+			if j+1 < n {
+				g[j] ^= g[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return g
+}
+
+// rsEncode returns the n error correction codewords for data.
+func rsEncode(data []byte, n int) []byte {
+	g := rsGenerator(n)
+	ecc := make([]byte, n)
+	for _, b := range data {
+		factor := b ^ ecc[0]
+		copy(ecc, ecc[1:])
+		ecc[n-1] = 0
+		for i := range ecc {
+			ecc[i] ^= gfMul(g[i], factor)
+		}
+	}
+	return ecc
+}
+
+var errUncorrectable = errors.New("Too many errors in QR code")
+
+// rsCorrect corrects, in place, a block of data codewords followed by n error
+// correction codewords, returning the number of codewords it corrected.
+func rsCorrect(block []byte, n int) (int, error) {
+	// The codeword at index i is the coefficient of xᵏ with k = len(block)-1-i.
+	syndromes := make([]byte, n)
+	clean := true
+	for i := range syndromes {
+		var s byte
+		x := gfPow(i)
+		for _, b := range block {
+			s = gfMul(s, x) ^ b
+		}
+		syndromes[i] = s
+		if s != 0 {
+			clean = false
+		}
+	}
+	if clean {
+		return 0, nil
+	}
+
+	// Berlekamp-Massey finds the error locator polynomial.
+	locator := []byte{1}
+	previous := []byte{1}
+	errors, shift, lastDiscrepancy := 0, 1, byte(1)
+	for i := 0; i < n; i++ {
+		discrepancy := syndromes[i]
+		for j := 1; j <= errors && j < len(locator); j++ {
+			discrepancy ^= gfMul(locator[j], syndromes[i-j])
+		}
+		if discrepancy == 0 {
+			shift++
+			continue
+		}
+		scale := gfDiv(discrepancy, lastDiscrepancy)
+		updated := make([]byte, max(len(locator), len(previous)+shift))
+		copy(updated, locator)
+		for j, c := range previous {
+			updated[j+shift] ^= gfMul(c, scale)
+		}
+		if 2*errors <= i {
+			previous = locator
+			errors = i + 1 - errors
+			lastDiscrepancy = discrepancy
+			shift = 1
+		} else {
+			shift++
+		}
+		locator = updated
+	}
+	for len(locator) > 1 && locator[len(locator)-1] == 0 {
+		locator = locator[:len(locator)-1]
+	}
+	if errors != len(locator)-1 || 2*errors > n {
+		return 0, errUncorrectable
+	}
+
+	// The evaluator polynomial is syndromes × locator mod xⁿ.
+	evaluator := make([]byte, n)
+	for i, s := range syndromes {
+		for j, c := range locator {
+			if i+j < n {
+				evaluator[i+j] ^= gfMul(s, c)
+			}
+		}
+	}
+	// In characteristic 2 the formal derivative keeps the odd terms only.
+	derivative := make([]byte, len(locator))
+	for j := 1; j < len(locator); j += 2 {
+		derivative[j-1] = locator[j]
+	}
+
+	// Chien search for the roots, then Forney for the error values.
+	found := 0
+	for i := range block {
+		k := len(block) - 1 - i
+		inverse := gfPow(-k)
+		if polyEval(locator, inverse) != 0 {
+			continue
+		}
+		denominator := polyEval(derivative, inverse)
+		if denominator == 0 {
+			return 0, errUncorrectable
+		}
+		block[i] ^= gfMul(gfPow(k), gfDiv(polyEval(evaluator, inverse), denominator))
+		found++
+	}
+	if found != errors {
+		return 0, errUncorrectable
+	}
+	return found, nil
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -8,6 +8,7 @@ package ringlogger
 import (
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -80,3 +81,25 @@ func TestFollow(t *testing.T) {
 		time.Sleep(300 * time.Millisecond)
 	}
 }
+
+func TestFilter(t *testing.T) {
+	const filename = "ringlogger_filter_test.bin"
+	defer os.Remove(filename)
+	rl, err := NewRinglogger(filename, "FLT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	rl.SetFilter(func(line []byte) []byte {
+		return []byte(strings.Replace(string(line), "hunter2", "(redacted)", -1))
+	})
+	fmt.Fprintf(rl, "password=hunter2")
+	fmt.Fprintf(rl, "nothing to hide")
+	lines, _ := rl.FollowFromCursor(CursorAll)
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d", len(lines))
+	}
+	if lines[0].Line != "password=(redacted)" || lines[1].Line != "nothing to hide" {
+		t.Errorf("Lines not filtered: %q, %q", lines[0].Line, lines[1].Line)
+	}
+}
@@ -42,6 +42,7 @@ type Ringlogger struct {
 	mapping  windows.Handle
 	log      *logMem
 	readOnly bool
+	filter   func([]byte) []byte
 }
 
 func NewRinglogger(filename string, tag string) (*Ringlogger, error) {
@@ -102,6 +103,12 @@ func newRingloggerFromMappingHandle(mappingHandle windows.Handle, tag string, ac
 	return rl, nil
 }
 
+// SetFilter sets a function through which every line passes before it is
+// written to the log, such as one that redacts secrets.
+func (rl *Ringlogger) SetFilter(filter func([]byte) []byte) {
+	rl.filter = filter
+}
+
 func (rl *Ringlogger) Write(p []byte) (n int, err error) {
 	if rl.readOnly {
 		return 0, io.ErrShortWrite
@@ -124,7 +131,11 @@ func (rl *Ringlogger) Write(p []byte) (n int, err error) {
 		line.line[i] = 0
 	}
 
-	text := []byte(fmt.Sprintf("[%s] %s", rl.tag, bytes.TrimSpace(p)))
+	trimmed := bytes.TrimSpace(p)
+	if rl.filter != nil {
+		trimmed = rl.filter(trimmed)
+	}
+	text := []byte(fmt.Sprintf("[%s] %s", rl.tag, trimmed))
 	if len(text) > maxLogLineLength-1 {
 		text = text[:maxLogLineLength-1]
 	}
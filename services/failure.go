@@ -0,0 +1,98 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+)
+
+// Failure records why a tunnel service stopped with an error, so that the
+// manager can tell more than the exit code does. Phase is the step the
+// service was taking, Chain holds the message of the error and of each error
+// it wraps, outermost first, and Config is the part of the configuration
+// concerned, if any.
+type Failure struct {
+	Phase  string
+	Code   Error
+	Chain  []string
+	Time   time.Time
+	Config string
+}
+
+// NewFailure returns the failure of phase with err, keeping the messages of
+// err and the errors it wraps with any keys in them redacted.
+func NewFailure(phase string, code Error, err error, config string) *Failure {
+	failure := &Failure{Phase: phase, Code: code, Time: time.Now(), Config: config}
+	for ; err != nil; err = errors.Unwrap(err) {
+		failure.Chain = append(failure.Chain, string(conf.RedactKeys([]byte(err.Error()))))
+	}
+	return failure
+}
+
+func (f *Failure) Error() string {
+	if len(f.Chain) > 0 {
+		return f.Chain[0]
+	}
+	return f.Code.Error()
+}
+
+func failurePath(tunnelName string) (string, error) {
+	if !conf.TunnelNameIsValid(tunnelName) {
+		return "", errors.New("Tunnel name is not valid")
+	}
+	root, err := conf.RootDirectory()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(root, "Failures")
+	err = os.MkdirAll(dir, os.ModeDir|0700)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, tunnelName+".json"), nil
+}
+
+// WriteFailure leaves failure for the manager to pick up with TakeFailure.
+func WriteFailure(tunnelName string, failure *Failure) error {
+	path, err := failurePath(tunnelName)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(failure)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// TakeFailure returns and removes the failure last written for the tunnel, or
+// nil if there is none.
+func TakeFailure(tunnelName string) (*Failure, error) {
+	path, err := failurePath(tunnelName)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	os.Remove(path)
+	failure := &Failure{}
+	err = json.Unmarshal(data, failure)
+	if err != nil {
+		return nil, err
+	}
+	return failure, nil
+}
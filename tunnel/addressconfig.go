@@ -89,8 +89,13 @@ func configureInterface(family winipcfg.AddressFamily, conf *conf.Config, tun *t
 
 	foundDefault4 := false
 	foundDefault6 := false
-	for _, peer := range conf.Peers {
-		for _, allowedip := range peer.AllowedIPs {
+	routedPeers := conf.Peers
+	if conf.Interface.TableIsOff() {
+		log.Println("Not adding routes for allowed IPs, because Table = off")
+		routedPeers = nil
+	}
+	for _, peer := range routedPeers {
+		for _, allowedip := range peer.EffectiveAllowedIPs() {
 			if (allowedip.Bits() == 32 && firstGateway4 == nil) || (allowedip.Bits() == 128 && firstGateway6 == nil) {
 				continue
 			}
@@ -170,6 +175,11 @@ func configureInterface(family winipcfg.AddressFamily, conf *conf.Config, tun *t
 		return err
 	}
 
+	err = luid.SetDNSSearchList(conf.Interface.DNSSearch)
+	if err != nil {
+		return err
+	}
+
 	err = luid.SetDNSForFamily(family, conf.Interface.DNS)
 	if err != nil {
 		return err
@@ -179,21 +189,7 @@ func configureInterface(family winipcfg.AddressFamily, conf *conf.Config, tun *t
 }
 
 func enableFirewall(conf *conf.Config, tun *tun.NativeTun) error {
-	restrictAll := false
-	if len(conf.Peers) == 1 {
-	nextallowedip:
-		for _, allowedip := range conf.Peers[0].AllowedIPs {
-			if allowedip.Cidr == 0 {
-				for _, b := range allowedip.IP {
-					if b != 0 {
-						continue nextallowedip
-					}
-				}
-				restrictAll = true
-				break
-			}
-		}
-	}
+	restrictAll := conf.BlocksUntunneledTraffic()
 	if restrictAll && len(conf.Interface.DNS) == 0 {
 		log.Println("Warning: no DNS server specified, despite having an allowed IPs of 0.0.0.0/0 or ::/0. There may be connectivity issues.")
 	}
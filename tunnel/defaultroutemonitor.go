@@ -12,9 +12,41 @@ import (
 	"golang.zx2c4.com/wireguard/device"
 	"golang.zx2c4.com/wireguard/tun"
 
+	"golang.zx2c4.com/wireguard/windows/conf"
 	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
 )
 
+func hasDefaultRoute(family winipcfg.AddressFamily, ourLUID winipcfg.LUID) bool {
+	r, err := winipcfg.GetIPForwardTable2(family)
+	if err != nil {
+		return false
+	}
+	for i := range r {
+		if r[i].DestinationPrefix.PrefixLength != 0 || r[i].InterfaceLUID == ourLUID {
+			continue
+		}
+		ifrow, err := r[i].InterfaceLUID.Interface()
+		if err == nil && ifrow.OperStatus == winipcfg.IfOperStatusUp {
+			return true
+		}
+	}
+	return false
+}
+
+// preferredEndpointFamily prefers the address family of peer endpoints that
+// has a default route outside of the tunnel. When both or neither do, the
+// resolver's own order is kept.
+func preferredEndpointFamily(ourLUID winipcfg.LUID) conf.FamilyPreference {
+	ipv4 := hasDefaultRoute(windows.AF_INET, ourLUID)
+	ipv6 := hasDefaultRoute(windows.AF_INET6, ourLUID)
+	if ipv4 && !ipv6 {
+		return conf.PreferIPv4
+	} else if ipv6 && !ipv4 {
+		return conf.PreferIPv6
+	}
+	return conf.NoFamilyPreference
+}
+
 func bindSocketRoute(family winipcfg.AddressFamily, device *device.Device, ourLUID winipcfg.LUID, lastLUID *winipcfg.LUID, lastIndex *uint32) error {
 	r, err := winipcfg.GetIPForwardTable2(family)
 	if err != nil {
@@ -0,0 +1,177 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+)
+
+const (
+	endpointRefreshInterval = time.Minute * 2
+
+	// endpointStaleAfter matches the time after which the device gives up on
+	// a session. A peer without a handshake more recent than this, which is
+	// being sent to but isn't answering, is moved to its next candidate.
+	endpointStaleAfter = time.Second * 180
+)
+
+type refreshedPeer struct {
+	publicKey conf.Key
+	endpoint  conf.Endpoint // as configured, with a host name
+	current   conf.Endpoint // the address last given to the device
+	rxBytes   conf.Bytes
+	txBytes   conf.Bytes
+}
+
+// endpointRefresher periodically resolves the host names of peer endpoints
+// again, pointing the device at a new address when the one it has is no
+// longer among the results or has stopped answering.
+type endpointRefresher struct {
+	resolver   *conf.EndpointResolver
+	preference func() conf.FamilyPreference
+	runtime    func() (*conf.Config, error)
+	setUAPI    func(string) error
+	now        func() time.Time
+	peers      []refreshedPeer
+	ctx        context.Context
+	cancel     context.CancelFunc
+	running    sync.WaitGroup
+}
+
+// newEndpointRefresher returns nil if no peer has an endpoint given by name.
+// The resolved configuration holds the addresses the device started with.
+func newEndpointRefresher(config, resolved *conf.Config, resolver *conf.EndpointResolver) *endpointRefresher {
+	r := &endpointRefresher{resolver: resolver, now: time.Now}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	for i := range config.Peers {
+		endpoint := config.Peers[i].Endpoint
+		if endpoint.IsEmpty() || endpoint == resolved.Peers[i].Endpoint {
+			continue
+		}
+		r.peers = append(r.peers, refreshedPeer{
+			publicKey: config.Peers[i].PublicKey,
+			endpoint:  endpoint,
+			current:   resolved.Peers[i].Endpoint,
+		})
+	}
+	if len(r.peers) == 0 {
+		return nil
+	}
+	return r
+}
+
+func (r *endpointRefresher) isStale(peer *refreshedPeer, runtime *conf.Config) bool {
+	if runtime == nil {
+		return false
+	}
+	for i := range runtime.Peers {
+		p := &runtime.Peers[i]
+		if p.PublicKey != peer.publicKey {
+			continue
+		}
+		sending := p.TxBytes > peer.txBytes && p.RxBytes == peer.rxBytes
+		peer.rxBytes, peer.txBytes = p.RxBytes, p.TxBytes
+		handshake := time.Unix(0, 0).Add(time.Duration(p.LastHandshakeTime))
+		return sending && r.now().Sub(handshake) > endpointStaleAfter
+	}
+	return false
+}
+
+// nextEndpoint picks the address to use for the peer out of the candidates.
+func nextEndpoint(current conf.Endpoint, candidates []conf.Endpoint, stale bool) conf.Endpoint {
+	for i := range candidates {
+		if candidates[i] == current {
+			if stale {
+				return candidates[(i+1)%len(candidates)]
+			}
+			return current
+		}
+	}
+	return candidates[0]
+}
+
+func (r *endpointRefresher) refresh() {
+	if r.preference != nil {
+		r.resolver.Preference = r.preference()
+	}
+	var runtime *conf.Config
+	if r.runtime != nil {
+		var err error
+		runtime, err = r.runtime()
+		if err != nil {
+			log.Printf("Unable to get runtime configuration: %v", err)
+		}
+	}
+	var uapi strings.Builder
+	next := make([]conf.Endpoint, len(r.peers))
+	for i := range r.peers {
+		peer := &r.peers[i]
+		next[i] = peer.current
+		stale := r.isStale(peer, runtime)
+		candidates, err := r.resolver.Candidates(r.ctx, &peer.endpoint)
+		if r.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("Unable to resolve %s again: %v", peer.endpoint.Host, err)
+			continue
+		}
+		next[i] = nextEndpoint(peer.current, candidates, stale)
+		if next[i] == peer.current {
+			continue
+		}
+		log.Printf("%s: changing endpoint from %s to %s", abbreviatedPeer(&peer.publicKey), peer.current.String(), next[i].String())
+		uapi.WriteString(fmt.Sprintf("public_key=%s\nendpoint=%s\n", peer.publicKey.HexString(), next[i].String()))
+	}
+	if uapi.Len() == 0 {
+		return
+	}
+	err := r.setUAPI(uapi.String())
+	if err != nil {
+		log.Printf("Unable to set new endpoints: %v", err)
+		return
+	}
+	for i := range r.peers {
+		r.peers[i].current = next[i]
+	}
+}
+
+func (r *endpointRefresher) run(interval time.Duration) {
+	defer r.running.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.refresh()
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *endpointRefresher) start(interval time.Duration) {
+	r.running.Add(1)
+	go r.run(interval)
+}
+
+func (r *endpointRefresher) Start() {
+	r.start(endpointRefreshInterval)
+}
+
+// Destroy cancels any lookup in progress and waits for the refresher to stop,
+// after which it no longer touches the device.
+func (r *endpointRefresher) Destroy() {
+	r.cancel()
+	r.running.Wait()
+}
@@ -0,0 +1,136 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+)
+
+type fakeResolver map[string][]string
+
+func (f fakeResolver) LookupHost(ctx context.Context, name string) ([]net.IPAddr, error) {
+	addresses, ok := f[name]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	var addrs []net.IPAddr
+	for _, address := range addresses {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(address)})
+	}
+	return addrs, nil
+}
+
+func TestEndpointRefresher(t *testing.T) {
+	alice, _ := conf.NewPrivateKeyFromString("xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=")
+	bob, _ := conf.NewPrivateKeyFromString("TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=")
+	config := &conf.Config{Peers: []conf.Peer{
+		{PublicKey: *alice, Endpoint: conf.Endpoint{Host: "vpn.example", Port: 51820}},
+		{PublicKey: *bob, Endpoint: conf.Endpoint{Host: "192.0.2.9", Port: 51820}},
+	}}
+	dns := fakeResolver{"vpn.example": {"192.0.2.1", "192.0.2.2"}}
+	resolver := &conf.EndpointResolver{Resolver: dns}
+	resolved, err := resolver.ResolveConfig(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if newEndpointRefresher(&conf.Config{Peers: config.Peers[1:]}, &conf.Config{Peers: resolved.Peers[1:]}, resolver) != nil {
+		t.Error("Expected no refresher without named endpoints")
+	}
+	r := newEndpointRefresher(config, resolved, resolver)
+	if r == nil || len(r.peers) != 1 {
+		t.Fatal("Expected a refresher for the named endpoint only")
+	}
+	var sets []string
+	r.setUAPI = func(uapi string) error {
+		sets = append(sets, uapi)
+		return nil
+	}
+	now := time.Unix(1000000, 0)
+	r.now = func() time.Time { return now }
+	runtime := &conf.Config{Peers: []conf.Peer{{PublicKey: *alice}}}
+	r.runtime = func() (*conf.Config, error) { return runtime, nil }
+	expectSet := func(expected string) {
+		t.Helper()
+		r.refresh()
+		if len(expected) == 0 && len(sets) > 0 {
+			t.Errorf("Unexpected endpoint change: %q", sets)
+		} else if len(expected) > 0 && (len(sets) != 1 || sets[0] != "public_key="+alice.HexString()+"\nendpoint="+expected+"\n") {
+			t.Errorf("Expected endpoint change to %s, got %q", expected, sets)
+		}
+		sets = nil
+	}
+
+	expectSet("")
+
+	dns["vpn.example"] = []string{"192.0.2.2", "192.0.2.1"}
+	expectSet("")
+
+	dns["vpn.example"] = []string{"198.51.100.1", "2001:db8::1"}
+	expectSet("198.51.100.1:51820")
+
+	r.preference = func() conf.FamilyPreference { return conf.PreferIPv6 }
+	expectSet("")
+
+	// Sending without receiving anything back, with no recent handshake.
+	runtime.Peers[0].TxBytes = 148
+	expectSet("[2001:db8::1]:51820")
+
+	// A recent handshake means the endpoint works.
+	runtime.Peers[0].TxBytes = 296
+	runtime.Peers[0].LastHandshakeTime = conf.HandshakeTime(time.Duration(now.Add(-time.Minute).UnixNano()))
+	expectSet("")
+
+	delete(dns, "vpn.example")
+	expectSet("")
+
+	dns["vpn.example"] = []string{"198.51.100.2"}
+	r.setUAPI = func(string) error { return errors.New("device closed") }
+	r.refresh()
+	if r.peers[0].current.String() != "[2001:db8::1]:51820" {
+		t.Errorf("Endpoint recorded as %s despite failing to set it", r.peers[0].current.String())
+	}
+}
+
+// blockingResolver looks names up until its context is done, saying when it
+// has started.
+type blockingResolver chan struct{}
+
+func (b blockingResolver) LookupHost(ctx context.Context, name string) ([]net.IPAddr, error) {
+	b <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestEndpointRefresherDestroy(t *testing.T) {
+	alice, _ := conf.NewPrivateKeyFromString("xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=")
+	config := &conf.Config{Peers: []conf.Peer{{PublicKey: *alice, Endpoint: conf.Endpoint{Host: "vpn.example", Port: 51820}}}}
+	resolved := &conf.Config{Peers: []conf.Peer{{PublicKey: *alice, Endpoint: conf.Endpoint{Host: "192.0.2.1", Port: 51820}}}}
+	lookups := make(blockingResolver)
+	r := newEndpointRefresher(config, resolved, &conf.EndpointResolver{Resolver: lookups})
+	r.setUAPI = func(string) error {
+		t.Error("Endpoints set after the refresher was destroyed")
+		return nil
+	}
+	r.start(time.Millisecond)
+	<-lookups
+	destroyed := make(chan struct{})
+	go func() {
+		r.Destroy()
+		close(destroyed)
+	}()
+	select {
+	case <-destroyed:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Destroy did not cancel the lookup in progress")
+	}
+}
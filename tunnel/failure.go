@@ -0,0 +1,53 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+	"golang.zx2c4.com/wireguard/windows/services"
+)
+
+// failureConfig returns the lines of the configuration that a failure with
+// serviceError and err most likely concerns, leaving out the private key.
+func failureConfig(config *conf.Config, serviceError services.Error, err error) string {
+	if config == nil {
+		return ""
+	}
+	var lines []string
+	add := func(key string, value string) {
+		lines = append(lines, fmt.Sprintf("%s = %s", key, value))
+	}
+	switch serviceError {
+	case services.ErrorDNSLookup:
+		var lookupErr *conf.EndpointLookupError
+		if errors.As(err, &lookupErr) {
+			lines = append(lines, "[Peer]")
+			add("PublicKey", lookupErr.PublicKey.String())
+			add("Endpoint", lookupErr.Endpoint.String())
+		} else if len(config.Interface.Resolver) > 0 {
+			add("# Resolver", config.Interface.Resolver)
+		}
+	case services.ErrorRunScript:
+		for _, command := range config.Interface.PreUp {
+			add("PreUp", command)
+		}
+		for _, command := range config.Interface.PostUp {
+			add("PostUp", command)
+		}
+	case services.ErrorSetNetConfig, services.ErrorFirewall:
+		for i := range config.Interface.Addresses {
+			add("Address", config.Interface.Addresses[i].String())
+		}
+		for _, dns := range config.Interface.DNS {
+			add("DNS", dns.String())
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,60 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+	"golang.zx2c4.com/wireguard/windows/services"
+)
+
+func TestFailureConfig(t *testing.T) {
+	config, err := conf.FromWgQuick(`[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Address = 10.192.122.1/24
+DNS = 10.192.122.53
+PreUp = net stop dnscache
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+Endpoint = vpn.example.com:51820
+AllowedIPs = 0.0.0.0/0
+`, "failure")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookupErr := &conf.EndpointLookupError{PublicKey: config.Peers[0].PublicKey, Endpoint: config.Peers[0].Endpoint, Err: &net.DNSError{Err: "host not found", Name: "vpn.example.com"}}
+	wrapped := fmt.Errorf("starting: %w", lookupErr)
+	fragment := failureConfig(config, services.ErrorDNSLookup, wrapped)
+	expected := "[Peer]\nPublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=\nEndpoint = vpn.example.com:51820"
+	if fragment != expected {
+		t.Errorf("DNS failure should concern the peer's endpoint, not:\n%s", fragment)
+	}
+
+	fragment = failureConfig(config, services.ErrorSetNetConfig, errors.New("The object already exists."))
+	if fragment != "Address = 10.192.122.1/24\nDNS = 10.192.122.53" {
+		t.Errorf("Network configuration failure should concern the addresses, not:\n%s", fragment)
+	}
+	if fragment = failureConfig(config, services.ErrorRunScript, nil); fragment != "PreUp = net stop dnscache" {
+		t.Errorf("Script failure should concern the scripts, not:\n%s", fragment)
+	}
+	for _, fragment := range []string{failureConfig(config, services.ErrorCreateWintun, nil), failureConfig(nil, services.ErrorLoadConfiguration, nil)} {
+		if len(fragment) > 0 {
+			t.Errorf("Unexpected configuration for a failure unrelated to it:\n%s", fragment)
+		}
+	}
+
+	failure := services.NewFailure("Resolving DNS names", services.ErrorDNSLookup, wrapped, fragment)
+	if len(failure.Chain) != 3 || failure.Chain[1] != "DNS lookup of vpn.example.com failed: host not found" || !strings.Contains(failure.Chain[2], "host not found") {
+		t.Errorf("Failure should keep every wrapped error, not %q", failure.Chain)
+	}
+}
@@ -0,0 +1,49 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+)
+
+// peerNameWriter adds the configured names of peers to the abbreviated peer
+// identifiers that the device logger prints, such as "peer(xTIB…p8Dg)".
+type peerNameWriter struct {
+	writer   io.Writer
+	replacer *strings.Replacer
+}
+
+func abbreviatedPeer(key *conf.Key) string {
+	b64 := key.String()
+	return fmt.Sprintf("peer(%s…%s)", b64[0:4], b64[39:43])
+}
+
+func newPeerNameWriter(writer io.Writer, peers []conf.Peer) io.Writer {
+	var replacements []string
+	for i := range peers {
+		if len(peers[i].Name) == 0 {
+			continue
+		}
+		abbreviated := abbreviatedPeer(&peers[i].PublicKey)
+		replacements = append(replacements, abbreviated, fmt.Sprintf("%s %q)", abbreviated[:len(abbreviated)-1], peers[i].Name))
+	}
+	if len(replacements) == 0 {
+		return writer
+	}
+	return &peerNameWriter{writer, strings.NewReplacer(replacements...)}
+}
+
+func (w *peerNameWriter) Write(p []byte) (int, error) {
+	_, err := io.WriteString(w.writer, w.replacer.Replace(string(p)))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,26 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+)
+
+func TestPeerNameWriter(t *testing.T) {
+	alice, _ := conf.NewPrivateKeyFromString("xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=")
+	bob, _ := conf.NewPrivateKeyFromString("TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=")
+	var buf bytes.Buffer
+	w := newPeerNameWriter(&buf, []conf.Peer{{PublicKey: *alice, Name: "Alice"}, {PublicKey: *bob}})
+	w.Write([]byte("peer(xTIB…p8Dg) - Sending handshake initiation\n"))
+	w.Write([]byte("peer(TrMv…WXX0) - Sending handshake initiation\n"))
+	expected := "peer(xTIB…p8Dg \"Alice\") - Sending handshake initiation\npeer(TrMv…WXX0) - Sending handshake initiation\n"
+	if buf.String() != expected {
+		t.Errorf("Wrong output:\n%q\nexpected\n%q", buf.String(), expected)
+	}
+}
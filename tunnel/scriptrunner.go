@@ -0,0 +1,136 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+)
+
+const scriptExecutionPolicy = "DangerousScriptExecution"
+
+type hookExecutor interface {
+	execute(command string, env []string) error
+}
+
+type cmdExecutor struct{}
+
+func (cmdExecutor) execute(command string, env []string) error {
+	comspec := os.Getenv("COMSPEC")
+	if len(comspec) == 0 {
+		system32, err := windows.GetSystemDirectory()
+		if err != nil {
+			return err
+		}
+		comspec = filepath.Join(system32, "cmd.exe")
+	}
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(comspec)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		HideWindow: true,
+		CmdLine:    fmt.Sprintf("cmd /c %s", command),
+	}
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+	err = cmd.Start()
+	writer.Close()
+	if err != nil {
+		reader.Close()
+		return err
+	}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		log.Printf("cmd> %s", scanner.Text())
+	}
+	reader.Close()
+	return cmd.Wait()
+}
+
+// hookRunner executes the wg-quick style PreUp, PostUp, PreDown, and PostDown
+// commands of an interface. The down hooks only run once the up hooks have
+// all succeeded, mirroring wg-quick's behavior of not running them when the
+// interface fails to come up.
+type hookRunner struct {
+	executor      hookExecutor
+	interfaceName string
+	config        *conf.Interface
+	enabled       bool
+	isUp          bool
+}
+
+func newHookRunner(interfaceName string, config *conf.Interface) *hookRunner {
+	return &hookRunner{
+		executor:      cmdExecutor{},
+		interfaceName: interfaceName,
+		config:        config,
+		enabled:       conf.AdminBool(scriptExecutionPolicy),
+	}
+}
+
+// run executes the commands of hook in order, stopping at the first that
+// fails. Commands are referred to by their position rather than quoted in the
+// log, as they often hold tokens or keys.
+func (hr *hookRunner) run(hook string, commands []string) error {
+	for i, command := range commands {
+		command = strings.Replace(command, "%i", hr.interfaceName, -1)
+		if !hr.enabled {
+			log.Printf("Skipping %s command %d of %d, because dangerous script execution is disabled by policy", hook, i+1, len(commands))
+			continue
+		}
+		log.Printf("Executing %s command %d of %d", hook, i+1, len(commands))
+		err := hr.executor.execute(command, []string{"WIREGUARD_TUNNEL_NAME=" + hr.interfaceName})
+		if err != nil {
+			return fmt.Errorf("%s command %d of %d failed: %v", hook, i+1, len(commands), err)
+		}
+		log.Printf("%s command %d of %d exited successfully", hook, i+1, len(commands))
+	}
+	return nil
+}
+
+func (hr *hookRunner) preUp() error {
+	return hr.run("PreUp", hr.config.PreUp)
+}
+
+func (hr *hookRunner) postUp() error {
+	err := hr.run("PostUp", hr.config.PostUp)
+	if err == nil {
+		hr.isUp = true
+	}
+	return err
+}
+
+func (hr *hookRunner) preDown() {
+	if !hr.isUp {
+		return
+	}
+	if err := hr.run("PreDown", hr.config.PreDown); err != nil {
+		log.Println(err)
+	}
+}
+
+func (hr *hookRunner) postDown() {
+	if !hr.isUp {
+		return
+	}
+	if err := hr.run("PostDown", hr.config.PostDown); err != nil {
+		log.Println(err)
+	}
+	hr.isUp = false
+}
@@ -0,0 +1,102 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package tunnel
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+)
+
+type fakeExecutor struct {
+	commands []string
+	failOn   string
+}
+
+func (fe *fakeExecutor) execute(command string, env []string) error {
+	fe.commands = append(fe.commands, command)
+	if command == fe.failOn {
+		return errors.New("exit status 1")
+	}
+	return nil
+}
+
+func newFakeHookRunner(config *conf.Interface, enabled bool) (*hookRunner, *fakeExecutor) {
+	fe := &fakeExecutor{}
+	return &hookRunner{
+		executor:      fe,
+		interfaceName: "wg0",
+		config:        config,
+		enabled:       enabled,
+	}, fe
+}
+
+func TestHookRunnerOrder(t *testing.T) {
+	config := &conf.Interface{
+		PreUp:    []string{"echo pre-up 1", "echo pre-up 2"},
+		PostUp:   []string{"route add %i"},
+		PreDown:  []string{"echo pre-down"},
+		PostDown: []string{"route delete %i"},
+	}
+	hr, fe := newFakeHookRunner(config, true)
+	if err := hr.preUp(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hr.postUp(); err != nil {
+		t.Fatal(err)
+	}
+	hr.preDown()
+	hr.postDown()
+	expected := []string{"echo pre-up 1", "echo pre-up 2", "route add wg0", "echo pre-down", "route delete wg0"}
+	if !reflect.DeepEqual(fe.commands, expected) {
+		t.Errorf("Wrong commands executed\nactual   %#v\nexpected %#v", fe.commands, expected)
+	}
+}
+
+func TestHookRunnerFailure(t *testing.T) {
+	config := &conf.Interface{
+		PostUp:   []string{"curl -H \"Authorization: Bearer s3cret\" false", "echo never"},
+		PreDown:  []string{"echo pre-down"},
+		PostDown: []string{"echo post-down"},
+	}
+	hr, fe := newFakeHookRunner(config, true)
+	fe.failOn = config.PostUp[0]
+	if err := hr.preUp(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hr.postUp(); err == nil {
+		t.Error("Error was expected")
+	} else if err.Error() != "PostUp command 1 of 2 failed: exit status 1" {
+		t.Errorf("Error should name the hook, not the command: %v", err)
+	}
+	hr.preDown()
+	hr.postDown()
+	expected := []string{config.PostUp[0]}
+	if !reflect.DeepEqual(fe.commands, expected) {
+		t.Errorf("Down hooks should not run after failed bring-up\nactual   %#v\nexpected %#v", fe.commands, expected)
+	}
+}
+
+func TestHookRunnerDisabledByPolicy(t *testing.T) {
+	config := &conf.Interface{
+		PreUp:    []string{"echo pre-up"},
+		PostDown: []string{"echo post-down"},
+	}
+	hr, fe := newFakeHookRunner(config, false)
+	if err := hr.preUp(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hr.postUp(); err != nil {
+		t.Fatal(err)
+	}
+	hr.preDown()
+	hr.postDown()
+	if len(fe.commands) != 0 {
+		t.Errorf("No commands should be executed without policy opt-in, but got %#v", fe.commands)
+	}
+}
@@ -8,6 +8,7 @@ package tunnel
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"net"
@@ -27,11 +28,13 @@ import (
 	"golang.zx2c4.com/wireguard/windows/elevate"
 	"golang.zx2c4.com/wireguard/windows/ringlogger"
 	"golang.zx2c4.com/wireguard/windows/services"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
 	"golang.zx2c4.com/wireguard/windows/version"
 )
 
 type tunnelService struct {
-	Path string
+	Path  string
+	store conf.Store
 }
 
 func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (svcSpecificEC bool, exitCode uint32) {
@@ -41,6 +44,11 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 	var uapi net.Listener
 	var watcher *interfaceWatcher
 	var nativeTun *tun.NativeTun
+	var hooks *hookRunner
+	var refresher *endpointRefresher
+	var config *conf.Config
+	var startupComplete bool
+	var phase string
 	var err error
 	serviceError := services.ErrorSuccess
 
@@ -49,6 +57,14 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		logErr := services.CombineErrors(err, serviceError)
 		if logErr != nil {
 			log.Println(logErr)
+			tunnelName, _ := conf.NameFromPath(service.Path)
+			if config != nil {
+				tunnelName = config.Name
+			}
+			failure := services.NewFailure(phase, serviceError, err, failureConfig(config, serviceError, err))
+			if writeErr := services.WriteFailure(tunnelName, failure); writeErr != nil {
+				log.Printf("Unable to record failure: %v", writeErr)
+			}
 		}
 		changes <- svc.Status{State: svc.StopPending}
 
@@ -84,6 +100,15 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 			}
 		}()
 
+		if hooks != nil {
+			hooks.preDown()
+		}
+		if refresher != nil {
+			refresher.Destroy()
+		}
+		if startupComplete && config.Interface.SaveConfig {
+			saveRuntimeConfig(dev, config, service.Path, service.store)
+		}
 		if watcher != nil {
 			watcher.Destroy()
 		}
@@ -93,15 +118,25 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		if dev != nil {
 			dev.Close()
 		}
+		if hooks != nil {
+			hooks.postDown()
+		}
 		stopIt <- true
 		log.Println("Shutting down")
 	}()
 
+	step := func(name string) {
+		phase = name
+		log.Println(name)
+	}
+
+	phase = "Opening log file"
 	err = ringlogger.InitGlobalLogger("TUN")
 	if err != nil {
 		serviceError = services.ErrorRingloggerOpen
 		return
 	}
+	ringlogger.Global.SetFilter(conf.RedactKeys)
 	defer func() {
 		if x := recover(); x != nil {
 			for _, line := range append([]string{fmt.Sprint(x)}, strings.Split(string(debug.Stack()), "\n")...) {
@@ -113,7 +148,16 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		}
 	}()
 
-	conf, err := conf.LoadFromPath(service.Path)
+	phase = "Loading configuration"
+	if conf.PathIsEncrypted(service.Path) {
+		var name string
+		name, err = conf.NameFromPath(service.Path)
+		if err == nil {
+			config, err = service.store.Load(name)
+		}
+	} else {
+		config, err = conf.LoadFromPath(service.Path)
+	}
 	if err != nil {
 		serviceError = services.ErrorLoadConfiguration
 		return
@@ -124,7 +168,7 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		return
 	}
 
-	logPrefix := fmt.Sprintf("[%s] ", conf.Name)
+	logPrefix := fmt.Sprintf("[%s] ", config.Name)
 	log.SetPrefix(logPrefix)
 
 	log.Println("Starting", version.UserAgent())
@@ -142,49 +186,68 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		m.Disconnect()
 	}
 
-	log.Println("Watching network interfaces")
+	step("Watching network interfaces")
 	watcher, err = watchInterface()
 	if err != nil {
 		serviceError = services.ErrorSetNetConfig
 		return
 	}
 
-	log.Println("Resolving DNS names")
-	uapiConf, err := conf.ToUAPI()
+	step("Resolving DNS names")
+	lookup, err := conf.NewResolver(config.Interface.Resolver)
+	if err != nil {
+		serviceError = services.ErrorDNSLookup
+		return
+	}
+	resolver := &conf.EndpointResolver{Resolver: lookup, Preference: preferredEndpointFamily(0)}
+	resolvedConfig, err := resolver.ResolveConfig(context.Background(), config)
 	if err != nil {
 		serviceError = services.ErrorDNSLookup
 		return
 	}
+	uapiConf, err := resolvedConfig.ToUAPI()
+	if err != nil {
+		serviceError = services.ErrorDNSLookup
+		return
+	}
+
+	hooks = newHookRunner(config.Name, &config.Interface)
+	phase = "Running PreUp commands"
+	err = hooks.preUp()
+	if err != nil {
+		serviceError = services.ErrorRunScript
+		return
+	}
 
-	log.Println("Creating Wintun device")
-	wintun, err := tun.CreateTUNWithRequestedGUID(conf.Name, deterministicGUID(conf))
+	step("Creating Wintun device")
+	wintun, err := tun.CreateTUNWithRequestedGUID(config.Name, deterministicGUID(config))
 	if err != nil {
 		serviceError = services.ErrorCreateWintun
 		return
 	}
 	nativeTun = wintun.(*tun.NativeTun)
 
-	log.Println("Enabling firewall rules")
-	err = enableFirewall(conf, nativeTun)
+	step("Enabling firewall rules")
+	err = enableFirewall(config, nativeTun)
 	if err != nil {
 		serviceError = services.ErrorFirewall
 		return
 	}
 
-	log.Println("Dropping privileges")
+	step("Dropping privileges")
 	err = elevate.DropAllPrivileges(true)
 	if err != nil {
 		serviceError = services.ErrorDropPrivileges
 		return
 	}
 
-	log.Println("Creating interface instance")
-	logOutput := log.New(ringlogger.Global, logPrefix, 0)
+	step("Creating interface instance")
+	logOutput := log.New(newPeerNameWriter(ringlogger.Global, config.Peers), logPrefix, 0)
 	logger := &device.Logger{logOutput, logOutput, logOutput}
 	dev = device.NewDevice(wintun, logger)
 
-	log.Println("Setting interface configuration")
-	uapi, err = ipc.UAPIListen(conf.Name)
+	step("Setting interface configuration")
+	uapi, err = ipc.UAPIListen(config.Name)
 	if err != nil {
 		serviceError = services.ErrorUAPIListen
 		return
@@ -196,10 +259,31 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		return
 	}
 
-	log.Println("Bringing peers up")
+	step("Bringing peers up")
 	dev.Up()
 
-	watcher.Configure(dev, conf, nativeTun)
+	watcher.Configure(dev, config, nativeTun)
+
+	refresher = newEndpointRefresher(config, resolvedConfig, resolver)
+	if refresher != nil {
+		ourLUID := winipcfg.LUID(nativeTun.LUID())
+		refresher.preference = func() conf.FamilyPreference { return preferredEndpointFamily(ourLUID) }
+		refresher.runtime = func() (*conf.Config, error) { return runtimeConfig(dev, config) }
+		refresher.setUAPI = func(uapiConf string) error {
+			if ipcErr := dev.IpcSetOperation(bufio.NewReader(strings.NewReader(uapiConf))); ipcErr != nil {
+				return ipcErr
+			}
+			return nil
+		}
+		refresher.Start()
+	}
+
+	phase = "Running PostUp commands"
+	err = hooks.postUp()
+	if err != nil {
+		serviceError = services.ErrorRunScript
+		return
+	}
 
 	log.Println("Listening for UAPI requests")
 	go func() {
@@ -214,6 +298,7 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 
 	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
 	log.Println("Startup complete")
+	startupComplete = true
 
 	for {
 		select {
@@ -229,13 +314,47 @@ func (service *tunnelService) Execute(args []string, r <-chan svc.ChangeRequest,
 		case <-dev.Wait():
 			return
 		case e := <-watcher.errors:
+			phase = "Adapting to network changes"
 			serviceError, err = e.serviceError, e.err
 			return
 		}
 	}
 }
 
-func Run(confPath string) error {
+func saveRuntimeConfig(dev *device.Device, config *conf.Config, path string, store conf.Store) {
+	if !conf.PathIsEncrypted(path) {
+		log.Println("Not saving runtime configuration, because SaveConfig is only supported for managed tunnels")
+		return
+	}
+	log.Println("Saving runtime configuration")
+	runtimeConfig, err := runtimeConfig(dev, config)
+	if err != nil {
+		log.Printf("Unable to get runtime configuration: %v", err)
+		return
+	}
+	err = store.Save(runtimeConfig, "")
+	if err != nil {
+		log.Printf("Unable to save runtime configuration: %v", err)
+	}
+}
+
+// runtimeConfig reads the device's current configuration, including its
+// transfer counters and handshake times.
+func runtimeConfig(dev *device.Device, config *conf.Config) (*conf.Config, error) {
+	var uapiConf bytes.Buffer
+	writer := bufio.NewWriter(&uapiConf)
+	ipcErr := dev.IpcGetOperation(writer)
+	if ipcErr != nil {
+		return nil, ipcErr
+	}
+	writer.Flush()
+	return conf.FromUAPI(uapiConf.String(), config)
+}
+
+// Run runs the tunnel service for the configuration at confPath. Managed
+// tunnels, whose configurations are encrypted, are loaded from and saved to
+// store, which must be the store the manager uses.
+func Run(confPath string, store conf.Store) error {
 	name, err := conf.NameFromPath(confPath)
 	if err != nil {
 		return err
@@ -244,5 +363,5 @@ func Run(confPath string) error {
 	if err != nil {
 		return err
 	}
-	return svc.Run(serviceName, &tunnelService{confPath})
+	return svc.Run(serviceName, &tunnelService{confPath, store})
 }
@@ -8,8 +8,10 @@ package winipcfg
 import (
 	"fmt"
 	"net"
+	"strings"
 
 	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
 )
 
 // LUID represents a network interface.
@@ -426,3 +428,34 @@ func (luid LUID) SetDNSForFamily(family AddressFamily, dnses []net.IP) error {
 	}
 	return runNetsh(cmds)
 }
+
+// SetDNSSearchList method sets the connection-specific DNS suffix search list of the adapter, or clears it if domains is empty.
+// There's no API for this, so like the network control panel, it writes the SearchList value of the interface's TCP/IP parameters.
+func (luid LUID) SetDNSSearchList(domains []string) error {
+	guid, err := luid.GUID()
+	if err != nil {
+		return err
+	}
+	for _, stack := range []string{"Tcpip", "Tcpip6"} {
+		key, err := registry.OpenKey(registry.LOCAL_MACHINE, fmt.Sprintf(`SYSTEM\CurrentControlSet\Services\%s\Parameters\Interfaces\%v`, stack, guid), registry.SET_VALUE)
+		if err == windows.ERROR_FILE_NOT_FOUND {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(domains) > 0 {
+			err = key.SetStringValue("SearchList", strings.Join(domains, ","))
+		} else {
+			err = key.DeleteValue("SearchList")
+			if err == windows.ERROR_FILE_NOT_FOUND {
+				err = nil
+			}
+		}
+		key.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
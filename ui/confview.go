@@ -7,6 +7,7 @@ package ui
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -61,23 +62,34 @@ type interfaceView struct {
 }
 
 type peerView struct {
+	name                *labelTextLine
 	publicKey           *labelTextLine
 	presharedKey        *labelTextLine
 	allowedIPs          *labelTextLine
 	endpoint            *labelTextLine
 	persistentKeepalive *labelTextLine
+	metadata            *labelTextLine
 	latestHandshake     *labelTextLine
 	transfer            *labelTextLine
 	lines               []widgetsLine
+
+	lastHandshakeTime conf.HandshakeTime
 }
 
+// statsInterval is how often the manager sends the stats of the peers of the
+// tunnel shown.
+const statsInterval = time.Second
+
 type ConfView struct {
 	*walk.ScrollView
 	name            *walk.GroupBox
 	interfaze       *interfaceView
 	peers           map[conf.Key]*peerView
 	tunnelChangedCB *manager.TunnelChangeCallback
+	peerStatsCB     *manager.PeerStatsCallback
 	tunnel          *manager.Tunnel
+	state           manager.TunnelState
+	statsTunnel     *manager.Tunnel
 	updateTicker    *time.Ticker
 }
 
@@ -211,10 +223,6 @@ func (tal *toggleActiveLine) widgets() (walk.Widget, walk.Widget) {
 	return nil, tal.composite
 }
 
-func (tal *toggleActiveLine) updateGlobal(globalState manager.TunnelState) {
-	tal.button.SetEnabled(globalState == manager.TunnelStarted || globalState == manager.TunnelStopped)
-}
-
 func (tal *toggleActiveLine) update(state manager.TunnelState) {
 	var text string
 
@@ -231,6 +239,7 @@ func (tal *toggleActiveLine) update(state manager.TunnelState) {
 
 	tal.button.SetText(text)
 	tal.button.SetVisible(state != manager.TunnelUnknown)
+	tal.button.SetEnabled(state == manager.TunnelStarted || state == manager.TunnelStopped)
 }
 
 func (tal *toggleActiveLine) Dispose() {
@@ -332,11 +341,13 @@ func newPeerView(parent walk.Container) (*peerView, error) {
 	pv := new(peerView)
 
 	items := []labelTextLineItem{
+		{"Name", &pv.name},
 		{"Public key", &pv.publicKey},
 		{"Preshared key", &pv.presharedKey},
 		{"Allowed IPs", &pv.allowedIPs},
 		{"Endpoint", &pv.endpoint},
 		{"Persistent keepalive", &pv.persistentKeepalive},
+		{"Metadata", &pv.metadata},
 		{"Latest handshake", &pv.latestHandshake},
 		{"Transfer", &pv.transfer},
 	}
@@ -392,11 +403,12 @@ func (iv *interfaceView) apply(c *conf.Interface) {
 		iv.addresses.hide()
 	}
 
-	if len(c.DNS) > 0 {
-		addrStrings := make([]string, len(c.DNS))
+	if len(c.DNS) > 0 || len(c.DNSSearch) > 0 {
+		addrStrings := make([]string, len(c.DNS), len(c.DNS)+len(c.DNSSearch))
 		for i, address := range c.DNS {
 			addrStrings[i] = address.String()
 		}
+		addrStrings = append(addrStrings, c.DNSSearch...)
 		iv.dns.show(strings.Join(addrStrings[:], ", "))
 	} else {
 		iv.dns.hide()
@@ -408,6 +420,12 @@ func (pv *peerView) widgetsLines() []widgetsLine {
 }
 
 func (pv *peerView) apply(c *conf.Peer) {
+	if len(c.Name) > 0 {
+		pv.name.show(c.Name)
+	} else {
+		pv.name.hide()
+	}
+
 	pv.publicKey.show(c.PublicKey.String())
 
 	if !c.PresharedKey.IsZero() {
@@ -438,14 +456,50 @@ func (pv *peerView) apply(c *conf.Peer) {
 		pv.persistentKeepalive.hide()
 	}
 
-	if !c.LastHandshakeTime.IsEmpty() {
-		pv.latestHandshake.show(c.LastHandshakeTime.String())
+	if len(c.Metadata) > 0 {
+		keys := make([]string, 0, len(c.Metadata))
+		for key := range c.Metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, len(keys))
+		for i, key := range keys {
+			pairs[i] = fmt.Sprintf("%s: %s", key, c.Metadata[key])
+		}
+		pv.metadata.show(strings.Join(pairs, ", "))
+	} else {
+		pv.metadata.hide()
+	}
+
+	pv.lastHandshakeTime = c.LastHandshakeTime
+	pv.updateLatestHandshake()
+	pv.updateTransfer(c.RxBytes, c.TxBytes)
+}
+
+// applyStats shows the stats the manager sent for the peer while the tunnel
+// runs.
+func (pv *peerView) applyStats(stats *conf.PeerStats) {
+	if !stats.Endpoint.IsEmpty() {
+		pv.endpoint.show(stats.Endpoint.String())
+	}
+	pv.lastHandshakeTime = stats.LastHandshakeTime
+	pv.updateLatestHandshake()
+	pv.updateTransfer(stats.RxBytes, stats.TxBytes)
+}
+
+// updateLatestHandshake shows how long ago the latest handshake was, which
+// changes as time passes.
+func (pv *peerView) updateLatestHandshake() {
+	if !pv.lastHandshakeTime.IsEmpty() {
+		pv.latestHandshake.show(pv.lastHandshakeTime.String())
 	} else {
 		pv.latestHandshake.hide()
 	}
+}
 
-	if c.RxBytes > 0 || c.TxBytes > 0 {
-		pv.transfer.show(fmt.Sprintf("%s received, %s sent", c.RxBytes.String(), c.TxBytes.String()))
+func (pv *peerView) updateTransfer(rx, tx conf.Bytes) {
+	if rx > 0 || tx > 0 {
+		pv.transfer.show(fmt.Sprintf("%s received, %s sent", rx.String(), tx.String()))
 	} else {
 		pv.transfer.hide()
 	}
@@ -498,12 +552,8 @@ func NewConfView(parent walk.Container) (*ConfView, error) {
 	cv.interfaze.toggleActive.button.Clicked().Attach(cv.onToggleActiveClicked)
 	cv.peers = make(map[conf.Key]*peerView)
 	cv.tunnelChangedCB = manager.IPCClientRegisterTunnelChange(cv.onTunnelChanged)
+	cv.peerStatsCB = manager.IPCClientRegisterPeerStats(cv.onPeerStats)
 	cv.SetTunnel(nil)
-	globalState, err := manager.IPCClientGlobalState()
-	if err != nil {
-		return nil, err
-	}
-	cv.interfaze.toggleActive.updateGlobal(globalState)
 
 	if err := walk.InitWrapperWindow(cv); err != nil {
 		return nil, err
@@ -512,23 +562,15 @@ func NewConfView(parent walk.Container) (*ConfView, error) {
 	cv.updateTicker = time.NewTicker(time.Second)
 	go func() {
 		for range cv.updateTicker.C {
-			if !cv.Visible() || !cv.Form().Visible() || win.IsIconic(cv.Form().Handle()) {
-				continue
-			}
-			if cv.tunnel != nil {
-				tunnel := cv.tunnel
-				var state manager.TunnelState
-				var config conf.Config
-				if state, _ = tunnel.State(); state == manager.TunnelStarted {
-					config, _ = tunnel.RuntimeConfig()
+			cv.Synchronize(func() {
+				cv.updateStatsSubscription()
+				if cv.statsTunnel == nil {
+					return
 				}
-				if config.Name == "" {
-					config, _ = tunnel.StoredConfig()
+				for _, pv := range cv.peers {
+					pv.updateLatestHandshake()
 				}
-				cv.Synchronize(func() {
-					cv.setTunnel(tunnel, &config, state)
-				})
-			}
+			})
 		}
 	}()
 
@@ -542,6 +584,14 @@ func (cv *ConfView) Dispose() {
 		cv.tunnelChangedCB.Unregister()
 		cv.tunnelChangedCB = nil
 	}
+	if cv.peerStatsCB != nil {
+		cv.peerStatsCB.Unregister()
+		cv.peerStatsCB = nil
+	}
+	if cv.statsTunnel != nil {
+		go cv.statsTunnel.UnsubscribeStats()
+		cv.statsTunnel = nil
+	}
 	if cv.updateTicker != nil {
 		cv.updateTicker.Stop()
 		cv.updateTicker = nil
@@ -569,7 +619,6 @@ func (cv *ConfView) onToggleActiveClicked() {
 
 func (cv *ConfView) onTunnelChanged(tunnel *manager.Tunnel, state manager.TunnelState, globalState manager.TunnelState, err error) {
 	cv.Synchronize(func() {
-		cv.interfaze.toggleActive.updateGlobal(globalState)
 		if cv.tunnel != nil && cv.tunnel.Name == tunnel.Name {
 			cv.interfaze.status.update(state)
 			cv.interfaze.toggleActive.update(state)
@@ -589,6 +638,41 @@ func (cv *ConfView) onTunnelChanged(tunnel *manager.Tunnel, state manager.Tunnel
 	}
 }
 
+// updateStatsSubscription has the manager send the stats of the peers of the
+// tunnel shown while it runs and can be seen, instead of polling it.
+func (cv *ConfView) updateStatsSubscription() {
+	var want *manager.Tunnel
+	if cv.tunnel != nil && cv.state == manager.TunnelStarted && cv.Visible() && cv.Form().Visible() && !win.IsIconic(cv.Form().Handle()) {
+		want = cv.tunnel
+	}
+	if want == cv.statsTunnel || want != nil && cv.statsTunnel != nil && want.Name == cv.statsTunnel.Name {
+		return
+	}
+	if previous := cv.statsTunnel; previous != nil {
+		go previous.UnsubscribeStats()
+	}
+	cv.statsTunnel = want
+	if want != nil {
+		go want.SubscribeStats(statsInterval)
+	}
+}
+
+func (cv *ConfView) onPeerStats(tunnel *manager.Tunnel, stats []conf.PeerStats) {
+	cv.Synchronize(func() {
+		if cv.statsTunnel == nil || cv.statsTunnel.Name != tunnel.Name {
+			return
+		}
+		for key, pv := range cv.peers {
+			fingerprint := key.Fingerprint()
+			for i := range stats {
+				if stats[i].Fingerprint == fingerprint && !stats[i].Removed {
+					pv.applyStats(&stats[i])
+				}
+			}
+		}
+	})
+}
+
 func (cv *ConfView) SetTunnel(tunnel *manager.Tunnel) {
 	cv.tunnel = tunnel //XXX: This races with the read in the updateTicker, but it's pointer-sized!
 
@@ -624,6 +708,7 @@ func (cv *ConfView) setTunnel(tunnel *manager.Tunnel, config *conf.Config, state
 	}
 	cv.name.SetVisible(tunnel != nil)
 
+	cv.state = state
 	cv.interfaze.apply(&config.Interface)
 	cv.interfaze.status.update(state)
 	cv.interfaze.toggleActive.update(state)
@@ -684,4 +769,5 @@ func (cv *ConfView) setTunnel(tunnel *manager.Tunnel, config *conf.Config, state
 		groupBox.Parent().Children().Remove(groupBox)
 		groupBox.Dispose()
 	}
+	cv.updateStatsSubscription()
 }
@@ -7,6 +7,7 @@ package ui
 
 import (
 	"fmt"
+	"net"
 	"strings"
 
 	"github.com/lxn/walk"
@@ -167,94 +168,36 @@ func (dlg *EditDialog) onBlockUntunneledTrafficCBCheckedChanged() {
 	if dlg.blockUntunneledTraficCheckGuard {
 		return
 	}
-	var (
-		v40 = [4]byte{}
-		v60 = [16]byte{}
-		v48 = [4]byte{0x80}
-		v68 = [16]byte{0x80}
-	)
 
+	// The firewall blocks untunneled traffic when an allowed IP covers a
+	// whole address family in one network, so blocking joins the two halves
+	// of the address space into that network and unblocking splits it again.
 	block := dlg.blockUntunneledTrafficCB.Checked()
 	cfg, err := conf.FromWgQuick(dlg.syntaxEdit.Text(), "temporary")
-	var newAllowedIPs []conf.IPCidr
-
-	if err != nil {
-		goto err
-	}
-	if len(cfg.Peers) != 1 {
-		goto err
-	}
-
-	newAllowedIPs = make([]conf.IPCidr, 0, len(cfg.Peers[0].AllowedIPs))
-	if block {
-		var (
-			foundV401    bool
-			foundV41281  bool
-			foundV600001 bool
-			foundV680001 bool
-		)
-		for _, allowedip := range cfg.Peers[0].AllowedIPs {
-			if allowedip.Cidr == 1 && len(allowedip.IP) == 16 && allowedip.IP.Equal(v60[:]) {
-				foundV600001 = true
-			} else if allowedip.Cidr == 1 && len(allowedip.IP) == 16 && allowedip.IP.Equal(v68[:]) {
-				foundV680001 = true
-			} else if allowedip.Cidr == 1 && len(allowedip.IP) == 4 && allowedip.IP.Equal(v40[:]) {
-				foundV401 = true
-			} else if allowedip.Cidr == 1 && len(allowedip.IP) == 4 && allowedip.IP.Equal(v48[:]) {
-				foundV41281 = true
-			} else {
+	if err == nil && len(cfg.Peers) == 1 {
+		var newAllowedIPs []conf.IPCidr
+		foundDefault := false
+		for _, allowedip := range conf.MinimizeIPCidrs(cfg.Peers[0].AllowedIPs) {
+			if allowedip.Cidr != 0 {
 				newAllowedIPs = append(newAllowedIPs, allowedip)
+				continue
 			}
-		}
-		if !((foundV401 && foundV41281) || (foundV600001 && foundV680001)) {
-			goto err
-		}
-		if foundV401 && foundV41281 {
-			newAllowedIPs = append(newAllowedIPs, conf.IPCidr{v40[:], 0})
-		} else if foundV401 {
-			newAllowedIPs = append(newAllowedIPs, conf.IPCidr{v40[:], 1})
-		} else if foundV41281 {
-			newAllowedIPs = append(newAllowedIPs, conf.IPCidr{v48[:], 1})
-		}
-		if foundV600001 && foundV680001 {
-			newAllowedIPs = append(newAllowedIPs, conf.IPCidr{v60[:], 0})
-		} else if foundV600001 {
-			newAllowedIPs = append(newAllowedIPs, conf.IPCidr{v60[:], 1})
-		} else if foundV680001 {
-			newAllowedIPs = append(newAllowedIPs, conf.IPCidr{v68[:], 1})
-		}
-		cfg.Peers[0].AllowedIPs = newAllowedIPs
-	} else {
-		var (
-			foundV400 bool
-			foundV600 bool
-		)
-		for _, allowedip := range cfg.Peers[0].AllowedIPs {
-			if allowedip.Cidr == 0 && len(allowedip.IP) == 16 && allowedip.IP.Equal(v60[:]) {
-				foundV600 = true
-			} else if allowedip.Cidr == 0 && len(allowedip.IP) == 4 && allowedip.IP.Equal(v40[:]) {
-				foundV400 = true
-			} else {
+			foundDefault = true
+			if block {
 				newAllowedIPs = append(newAllowedIPs, allowedip)
+				continue
 			}
+			upper := conf.IPCidr{IP: make(net.IP, len(allowedip.IP)), Cidr: 1}
+			upper.IP[0] = 0x80
+			newAllowedIPs = append(newAllowedIPs, conf.IPCidr{IP: allowedip.IP, Cidr: 1}, upper)
 		}
-		if !(foundV400 || foundV600) {
-			goto err
-		}
-		if foundV400 {
-			newAllowedIPs = append(newAllowedIPs, conf.IPCidr{v40[:], 1})
-			newAllowedIPs = append(newAllowedIPs, conf.IPCidr{v48[:], 1})
-		}
-		if foundV600 {
-			newAllowedIPs = append(newAllowedIPs, conf.IPCidr{v60[:], 1})
-			newAllowedIPs = append(newAllowedIPs, conf.IPCidr{v68[:], 1})
+		if foundDefault {
+			cfg.Peers[0].AllowedIPs = newAllowedIPs
+			dlg.syntaxEdit.SetText(cfg.ToWgQuick())
+			return
 		}
-		cfg.Peers[0].AllowedIPs = newAllowedIPs
 	}
-	dlg.syntaxEdit.SetText(cfg.ToWgQuick())
-	return
 
-err:
 	text := dlg.syntaxEdit.Text()
 	dlg.syntaxEdit.SetText("")
 	dlg.syntaxEdit.SetText(text)
@@ -314,9 +257,28 @@ func (dlg *EditDialog) onSaveButtonClicked() {
 		}
 	}
 
-	cfg, err := conf.FromWgQuick(dlg.syntaxEdit.Text(), newName)
-	if err != nil {
-		showErrorCustom(dlg, "Unable to create new configuration", err.Error())
+	cfg, diagnostics := conf.FromWgQuickWithDiagnostics(dlg.syntaxEdit.Text(), newName)
+	for i := range diagnostics {
+		if diagnostics[i].Severity == conf.SeverityError {
+			showErrorCustom(dlg, "Unable to create new configuration", diagnostics[i].Error())
+			return
+		}
+	}
+
+	// Informational findings are not worth interrupting a save for.
+	var problems strings.Builder
+	findings := conf.Lint(cfg)
+	for i := range findings {
+		if findings[i].Severity != conf.SeverityInfo {
+			problems.WriteString(fmt.Sprintf("%s\n", findings[i].Message))
+		}
+	}
+	if conf.HasErrorFindings(findings) {
+		showErrorCustom(dlg, "Unable to create new configuration", problems.String())
+		return
+	}
+	if problems.Len() > 0 && walk.DlgCmdNo == walk.MsgBox(dlg, "Configuration may not work as expected", fmt.Sprintf(`%s
+Do you want to save it anyway?`, problems.String()), walk.MsgBoxYesNo|walk.MsgBoxDefButton2|walk.MsgBoxIconWarning) {
 		return
 	}
 
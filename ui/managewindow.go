@@ -6,6 +6,7 @@
 package ui
 
 import (
+	"fmt"
 	"unsafe"
 
 	"github.com/lxn/walk"
@@ -13,6 +14,7 @@ import (
 	"golang.org/x/sys/windows"
 
 	"golang.zx2c4.com/wireguard/windows/manager"
+	"golang.zx2c4.com/wireguard/windows/services"
 )
 
 type ManageTunnelsWindow struct {
@@ -163,6 +165,12 @@ func (mtw *ManageTunnelsWindow) onTunnelChange(tunnel *manager.Tunnel, state man
 			if len(errMsg) > 0 && errMsg[len(errMsg)-1] != '.' {
 				errMsg += "."
 			}
+			if failure, ok := err.(*services.Failure); ok {
+				errMsg += fmt.Sprintf("\n\nStep: %s\nTime: %s", failure.Phase, failure.Time.Format("2006-01-02 15:04:05"))
+				if len(failure.Config) > 0 {
+					errMsg += "\n\n" + failure.Config
+				}
+			}
 			showWarningCustom(mtw, "Tunnel Error", errMsg+"\n\nPlease consult the log for more information.")
 		}
 	})
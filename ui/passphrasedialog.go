@@ -0,0 +1,142 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package ui
+
+import (
+	"github.com/lxn/walk"
+)
+
+type PassphraseDialog struct {
+	*walk.Dialog
+	passphraseEdit *walk.LineEdit
+	confirmEdit    *walk.LineEdit
+	passphrase     string
+}
+
+// runPassphraseDialog asks for the passphrase of a bundle. When confirm is
+// set, as for a new bundle, the passphrase must be entered twice.
+func runPassphraseDialog(owner walk.Form, title string, prompt string, confirm bool) (string, bool) {
+	dlg, err := newPassphraseDialog(owner, title, prompt, confirm)
+	if showError(err, owner) {
+		return "", false
+	}
+
+	if dlg.Run() == walk.DlgCmdOK {
+		return dlg.passphrase, true
+	}
+
+	return "", false
+}
+
+func newPassphraseDialog(owner walk.Form, title string, prompt string, confirm bool) (*PassphraseDialog, error) {
+	var err error
+	var disposables walk.Disposables
+	defer disposables.Treat()
+
+	dlg := new(PassphraseDialog)
+
+	layout := walk.NewGridLayout()
+	layout.SetSpacing(6)
+	layout.SetMargins(walk.Margins{10, 10, 10, 10})
+	layout.SetColumnStretchFactor(1, 3)
+
+	if dlg.Dialog, err = walk.NewDialog(owner); err != nil {
+		return nil, err
+	}
+	disposables.Add(dlg)
+	dlg.SetIcon(owner.Icon())
+	dlg.SetTitle(title)
+	dlg.SetLayout(layout)
+	dlg.SetMinMaxSize(walk.Size{400, 0}, walk.Size{0, 0})
+
+	promptLabel, err := walk.NewTextLabel(dlg)
+	if err != nil {
+		return nil, err
+	}
+	layout.SetRange(promptLabel, walk.Rectangle{0, 0, 2, 1})
+	promptLabel.SetText(prompt)
+
+	passphraseLabel, err := walk.NewTextLabel(dlg)
+	if err != nil {
+		return nil, err
+	}
+	layout.SetRange(passphraseLabel, walk.Rectangle{0, 1, 1, 1})
+	passphraseLabel.SetTextAlignment(walk.AlignHFarVCenter)
+	passphraseLabel.SetText("&Passphrase:")
+
+	if dlg.passphraseEdit, err = walk.NewLineEdit(dlg); err != nil {
+		return nil, err
+	}
+	layout.SetRange(dlg.passphraseEdit, walk.Rectangle{1, 1, 1, 1})
+	dlg.passphraseEdit.SetPasswordMode(true)
+
+	row := 2
+	if confirm {
+		confirmLabel, err := walk.NewTextLabel(dlg)
+		if err != nil {
+			return nil, err
+		}
+		layout.SetRange(confirmLabel, walk.Rectangle{0, row, 1, 1})
+		confirmLabel.SetTextAlignment(walk.AlignHFarVCenter)
+		confirmLabel.SetText("&Confirm:")
+
+		if dlg.confirmEdit, err = walk.NewLineEdit(dlg); err != nil {
+			return nil, err
+		}
+		layout.SetRange(dlg.confirmEdit, walk.Rectangle{1, row, 1, 1})
+		dlg.confirmEdit.SetPasswordMode(true)
+		row++
+	}
+
+	buttonsContainer, err := walk.NewComposite(dlg)
+	if err != nil {
+		return nil, err
+	}
+	layout.SetRange(buttonsContainer, walk.Rectangle{0, row, 2, 1})
+	buttonsContainer.SetLayout(walk.NewHBoxLayout())
+	buttonsContainer.Layout().SetMargins(walk.Margins{})
+
+	walk.NewHSpacer(buttonsContainer)
+
+	okButton, err := walk.NewPushButton(buttonsContainer)
+	if err != nil {
+		return nil, err
+	}
+	okButton.SetText("OK")
+	okButton.Clicked().Attach(dlg.onOKButtonClicked)
+
+	cancelButton, err := walk.NewPushButton(buttonsContainer)
+	if err != nil {
+		return nil, err
+	}
+	cancelButton.SetText("Cancel")
+	cancelButton.Clicked().Attach(dlg.Cancel)
+
+	dlg.SetCancelButton(cancelButton)
+	dlg.SetDefaultButton(okButton)
+
+	dlg.Starting().Attach(func() {
+		dlg.passphraseEdit.SetFocus()
+	})
+
+	disposables.Spare()
+
+	return dlg, nil
+}
+
+func (dlg *PassphraseDialog) onOKButtonClicked() {
+	passphrase := dlg.passphraseEdit.Text()
+	if passphrase == "" {
+		showWarningCustom(dlg, "Invalid passphrase", "A passphrase is required.")
+		return
+	}
+	if dlg.confirmEdit != nil && dlg.confirmEdit.Text() != passphrase {
+		showWarningCustom(dlg, "Passphrases do not match", "Please enter the same passphrase twice.")
+		return
+	}
+	dlg.passphrase = passphrase
+	dlg.Accept()
+}
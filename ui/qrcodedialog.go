@@ -0,0 +1,132 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package ui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lxn/walk"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+	"golang.zx2c4.com/wireguard/windows/qrcode"
+)
+
+// qrCodeImageSize is roughly how many pixels wide the image of a code is,
+// including its quiet zone.
+const qrCodeImageSize = 400
+
+type QRCodeDialog struct {
+	*walk.Dialog
+	config *conf.Config
+	code   *qrcode.Code
+	scale  int
+}
+
+func runQRCodeDialog(owner walk.Form, config *conf.Config) {
+	dlg, err := newQRCodeDialog(owner, config)
+	if showError(err, owner) {
+		return
+	}
+	dlg.Run()
+}
+
+func newQRCodeDialog(owner walk.Form, config *conf.Config) (*QRCodeDialog, error) {
+	var err error
+	var disposables walk.Disposables
+	defer disposables.Treat()
+
+	dlg := &QRCodeDialog{config: config}
+	if dlg.code, err = config.QRCode(); err != nil {
+		return nil, err
+	}
+	dlg.scale = qrCodeImageSize / (dlg.code.Size + 2*qrcode.QuietZone)
+	if dlg.scale < 1 {
+		dlg.scale = 1
+	}
+
+	vbl := walk.NewVBoxLayout()
+	vbl.SetMargins(walk.Margins{10, 10, 10, 10})
+	vbl.SetSpacing(10)
+
+	if dlg.Dialog, err = walk.NewDialogWithFixedSize(owner); err != nil {
+		return nil, err
+	}
+	disposables.Add(dlg)
+	dlg.SetIcon(owner.Icon())
+	dlg.SetTitle(fmt.Sprintf("QR code for ‘%s’", config.Name))
+	dlg.SetLayout(vbl)
+
+	bitmap, err := walk.NewBitmapFromImage(dlg.code.Image(dlg.scale))
+	if err != nil {
+		return nil, err
+	}
+	dlg.AddDisposable(bitmap)
+
+	imageView, err := walk.NewImageView(dlg)
+	if err != nil {
+		return nil, err
+	}
+	imageView.SetMode(walk.ImageViewModeIdeal)
+	imageView.SetImage(bitmap)
+
+	label, err := walk.NewTextLabel(dlg)
+	if err != nil {
+		return nil, err
+	}
+	label.SetText("Scan this code with the WireGuard app on a phone to import the tunnel. The code contains the private key of the tunnel, so keep it to yourself.")
+
+	buttonsContainer, err := walk.NewComposite(dlg)
+	if err != nil {
+		return nil, err
+	}
+	buttonsContainer.SetLayout(walk.NewHBoxLayout())
+	buttonsContainer.Layout().SetMargins(walk.Margins{})
+
+	saveButton, err := walk.NewPushButton(buttonsContainer)
+	if err != nil {
+		return nil, err
+	}
+	saveButton.SetText("&Save as PNG...")
+	saveButton.Clicked().Attach(dlg.onSaveButtonClicked)
+
+	walk.NewHSpacer(buttonsContainer)
+
+	closeButton, err := walk.NewPushButton(buttonsContainer)
+	if err != nil {
+		return nil, err
+	}
+	closeButton.SetText("Close")
+	closeButton.Clicked().Attach(dlg.Cancel)
+
+	dlg.SetCancelButton(closeButton)
+	dlg.SetDefaultButton(closeButton)
+
+	disposables.Spare()
+
+	return dlg, nil
+}
+
+func (dlg *QRCodeDialog) onSaveButtonClicked() {
+	fileDlg := walk.FileDialog{
+		Filter:   "PNG Images (*.png)|*.png",
+		FilePath: dlg.config.Name + ".png",
+		Title:    "Save QR code as PNG...",
+	}
+
+	if ok, _ := fileDlg.ShowSave(dlg); !ok {
+		return
+	}
+
+	if !strings.HasSuffix(strings.ToLower(fileDlg.FilePath), ".png") {
+		fileDlg.FilePath += ".png"
+	}
+
+	writeFileWithOverwriteHandling(dlg, fileDlg.FilePath, func(file *os.File) error {
+		return dlg.code.WritePNG(file, dlg.scale)
+	})
+}
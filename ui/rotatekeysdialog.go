@@ -0,0 +1,208 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2019 WireGuard LLC. All Rights Reserved.
+ */
+
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lxn/walk"
+
+	"golang.zx2c4.com/wireguard/windows/conf"
+	"golang.zx2c4.com/wireguard/windows/manager"
+)
+
+type RotateKeysDialog struct {
+	*walk.Dialog
+	tunnel          *manager.Tunnel
+	privateKeyCB    *walk.CheckBox
+	presharedKeysCB *walk.CheckBox
+	liveCB          *walk.CheckBox
+	purgeCB         *walk.CheckBox
+	rotated         conf.RotatedKeys
+}
+
+// runRotateKeysDialog asks which keys of tunnel to rotate and rotates them,
+// then shows what the peers need to be told.
+func runRotateKeysDialog(owner walk.Form, tunnel *manager.Tunnel) {
+	state, err := tunnel.State()
+	if showError(err, owner) {
+		return
+	}
+	dlg, err := newRotateKeysDialog(owner, tunnel, state == manager.TunnelStarted)
+	if showError(err, owner) {
+		return
+	}
+	if dlg.Run() != walk.DlgCmdOK {
+		return
+	}
+	runKeySnippetDialog(owner, tunnel.Name, dlg.rotated.RemoteSnippet())
+}
+
+func newRotateKeysDialog(owner walk.Form, tunnel *manager.Tunnel, running bool) (*RotateKeysDialog, error) {
+	var err error
+	var disposables walk.Disposables
+	defer disposables.Treat()
+
+	dlg := &RotateKeysDialog{tunnel: tunnel}
+
+	vbl := walk.NewVBoxLayout()
+	vbl.SetMargins(walk.Margins{10, 10, 10, 10})
+	vbl.SetSpacing(6)
+
+	if dlg.Dialog, err = walk.NewDialog(owner); err != nil {
+		return nil, err
+	}
+	disposables.Add(dlg)
+	dlg.SetIcon(owner.Icon())
+	dlg.SetTitle(fmt.Sprintf("Rotate keys of ‘%s’", tunnel.Name))
+	dlg.SetLayout(vbl)
+	dlg.SetMinMaxSize(walk.Size{400, 0}, walk.Size{0, 0})
+
+	label, err := walk.NewTextLabel(dlg)
+	if err != nil {
+		return nil, err
+	}
+	label.SetText("New keys are saved as a new revision of the configuration. Every peer must then be given the new public key and preshared keys.")
+
+	if dlg.privateKeyCB, err = walk.NewCheckBox(dlg); err != nil {
+		return nil, err
+	}
+	dlg.privateKeyCB.SetText("Generate a new &private key")
+	dlg.privateKeyCB.SetChecked(true)
+
+	if dlg.presharedKeysCB, err = walk.NewCheckBox(dlg); err != nil {
+		return nil, err
+	}
+	dlg.presharedKeysCB.SetText("Generate new pre&shared keys for all peers")
+
+	if dlg.liveCB, err = walk.NewCheckBox(dlg); err != nil {
+		return nil, err
+	}
+	dlg.liveCB.SetText("&Apply the new keys to the running tunnel now")
+	dlg.liveCB.SetChecked(running)
+	dlg.liveCB.SetEnabled(running)
+
+	if dlg.purgeCB, err = walk.NewCheckBox(dlg); err != nil {
+		return nil, err
+	}
+	dlg.purgeCB.SetText("&Forget earlier revisions, which hold the replaced keys")
+	dlg.purgeCB.SetChecked(true)
+
+	buttonsContainer, err := walk.NewComposite(dlg)
+	if err != nil {
+		return nil, err
+	}
+	buttonsContainer.SetLayout(walk.NewHBoxLayout())
+	buttonsContainer.Layout().SetMargins(walk.Margins{})
+
+	walk.NewHSpacer(buttonsContainer)
+
+	rotateButton, err := walk.NewPushButton(buttonsContainer)
+	if err != nil {
+		return nil, err
+	}
+	rotateButton.SetText("&Rotate")
+	rotateButton.Clicked().Attach(dlg.onRotateButtonClicked)
+
+	cancelButton, err := walk.NewPushButton(buttonsContainer)
+	if err != nil {
+		return nil, err
+	}
+	cancelButton.SetText("Cancel")
+	cancelButton.Clicked().Attach(dlg.Cancel)
+
+	dlg.SetCancelButton(cancelButton)
+	dlg.SetDefaultButton(rotateButton)
+
+	disposables.Spare()
+
+	return dlg, nil
+}
+
+func (dlg *RotateKeysDialog) onRotateButtonClicked() {
+	rotation := conf.KeyRotation{
+		PrivateKey:    dlg.privateKeyCB.Checked(),
+		PresharedKeys: dlg.presharedKeysCB.Checked(),
+	}
+	if !rotation.PrivateKey && !rotation.PresharedKeys {
+		showWarningCustom(dlg, "Nothing to rotate", "Please choose which keys to rotate.")
+		return
+	}
+	rotated, err := dlg.tunnel.RotateKeys(rotation, dlg.liveCB.Checked(), dlg.purgeCB.Checked())
+	if err != nil {
+		showErrorCustom(dlg, "Unable to rotate keys", err.Error())
+		return
+	}
+	dlg.rotated = rotated
+	dlg.Accept()
+}
+
+// runKeySnippetDialog shows the configuration that the peers of a tunnel need
+// after its keys were rotated, so that it can be copied.
+func runKeySnippetDialog(owner walk.Form, name string, snippet string) {
+	var disposables walk.Disposables
+	defer disposables.Treat()
+
+	vbl := walk.NewVBoxLayout()
+	vbl.SetMargins(walk.Margins{10, 10, 10, 10})
+	vbl.SetSpacing(6)
+
+	dlg, err := walk.NewDialog(owner)
+	if showError(err, owner) {
+		return
+	}
+	disposables.Add(dlg)
+	dlg.SetIcon(owner.Icon())
+	dlg.SetTitle(fmt.Sprintf("New keys of ‘%s’", name))
+	dlg.SetLayout(vbl)
+	dlg.SetMinMaxSize(walk.Size{500, 300}, walk.Size{0, 0})
+
+	label, err := walk.NewTextLabel(dlg)
+	if showError(err, owner) {
+		return
+	}
+	label.SetText("Update the configuration of each peer as follows. Until then, the peers will not be able to connect.")
+
+	text, err := walk.NewTextEdit(dlg)
+	if showError(err, owner) {
+		return
+	}
+	text.SetReadOnly(true)
+	text.SetText(strings.ReplaceAll(snippet, "\n", "\r\n"))
+
+	buttonsContainer, err := walk.NewComposite(dlg)
+	if showError(err, owner) {
+		return
+	}
+	buttonsContainer.SetLayout(walk.NewHBoxLayout())
+	buttonsContainer.Layout().SetMargins(walk.Margins{})
+
+	copyButton, err := walk.NewPushButton(buttonsContainer)
+	if showError(err, owner) {
+		return
+	}
+	copyButton.SetText("&Copy")
+	copyButton.Clicked().Attach(func() {
+		walk.Clipboard().SetText(snippet)
+	})
+
+	walk.NewHSpacer(buttonsContainer)
+
+	closeButton, err := walk.NewPushButton(buttonsContainer)
+	if showError(err, owner) {
+		return
+	}
+	closeButton.SetText("Close")
+	closeButton.Clicked().Attach(dlg.Cancel)
+
+	dlg.SetCancelButton(closeButton)
+	dlg.SetDefaultButton(closeButton)
+
+	disposables.Spare()
+
+	dlg.Run()
+}
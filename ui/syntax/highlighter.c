@@ -215,10 +215,7 @@ static bool is_valid_table(string_span_t s)
 		return true;
 	if (is_same(s, "off"))
 		return true;
-	/* This pretty much invalidates the other checks, but rt_names.c's
-	 * fread_id_name does no validation aside from this. */
-	if (s.len < 512)
-		return true;
+	/* Windows has no rt_tables, so unlike Linux, names are not accepted. */
 	return is_valid_uint(s, false, 0, 4294967295);
 }
 
@@ -226,6 +223,7 @@ static bool is_valid_saveconfig(string_span_t s)
 {
 	return is_same(s, "true") || is_same(s, "false");
 }
+#endif
 
 static bool is_valid_prepostupdown(string_span_t s)
 {
@@ -233,7 +231,6 @@ static bool is_valid_prepostupdown(string_span_t s)
 	 * So instead we just demand non-zero length. */
 	return s.len;
 }
-#endif
 
 static bool is_valid_scope(string_span_t s)
 {
@@ -328,10 +325,10 @@ enum field {
 	Address,
 	DNS,
 	MTU,
+	PreUp, PostUp, PreDown, PostDown,
 #ifndef MOBILE_WGQUICK_SUBSET
 	FwMark,
 	Table,
-	PreUp, PostUp, PreDown, PostDown,
 	SaveConfig,
 #endif
 
@@ -367,13 +364,13 @@ static enum field get_field(string_span_t s)
 	check_enum(AllowedIPs);
 	check_enum(Endpoint);
 	check_enum(PersistentKeepalive);
-#ifndef MOBILE_WGQUICK_SUBSET
-	check_enum(FwMark);
-	check_enum(Table);
 	check_enum(PreUp);
 	check_enum(PostUp);
 	check_enum(PreDown);
 	check_enum(PostDown);
+#ifndef MOBILE_WGQUICK_SUBSET
+	check_enum(FwMark);
+	check_enum(Table);
 	check_enum(SaveConfig);
 #endif
 	return Invalid;
@@ -430,7 +427,12 @@ static void highlight_multivalue_value(struct highlight_span_array *ret, const s
 {
 	switch (section) {
 	case DNS:
-		append_highlight_span(ret, parent.s, s, is_valid_dns(s) ? HighlightIP : HighlightError);
+		if (is_valid_dns(s))
+			append_highlight_span(ret, parent.s, s, HighlightIP);
+		else if (is_valid_hostname(s))
+			append_highlight_span(ret, parent.s, s, HighlightHost);
+		else
+			append_highlight_span(ret, parent.s, s, HighlightError);
 		break;
 	case Address:
 	case AllowedIPs: {
@@ -511,13 +513,13 @@ static void highlight_value(struct highlight_span_array *ret, const string_span_
 	case Table:
 		append_highlight_span(ret, parent.s, s, is_valid_table(s) ? HighlightTable : HighlightError);
 		break;
+#endif
 	case PreUp:
 	case PostUp:
 	case PreDown:
 	case PostDown:
 		append_highlight_span(ret, parent.s, s, is_valid_prepostupdown(s) ? HighlightCmd : HighlightError);
 		break;
-#endif
 	case ListenPort:
 		append_highlight_span(ret, parent.s, s, is_valid_port(s) ? HighlightPort : HighlightError);
 		break;
@@ -5,8 +5,6 @@
 
 #include <sys/types.h>
 
-#define MOBILE_WGQUICK_SUBSET
-
 enum highlight_type {
 	HighlightSection,
 	HighlightField,
@@ -21,11 +19,11 @@ enum highlight_type {
 	HighlightKeepalive,
 	HighlightComment,
 	HighlightDelimiter,
+	HighlightCmd,
 #ifndef MOBILE_WGQUICK_SUBSET
 	HighlightTable,
 	HighlightFwMark,
 	HighlightSaveConfig,
-	HighlightCmd,
 #endif
 	HighlightError,
 	HighlightEnd
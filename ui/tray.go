@@ -13,6 +13,7 @@ import (
 
 	"golang.zx2c4.com/wireguard/windows/conf"
 	"golang.zx2c4.com/wireguard/windows/manager"
+	"golang.zx2c4.com/wireguard/windows/services"
 
 	"github.com/lxn/walk"
 )
@@ -26,6 +27,10 @@ type Tray struct {
 	// Current known tunnels by name
 	tunnels map[string]*walk.Action
 
+	// Addresses of the active tunnels by name, and the state of them all
+	activeAddresses map[string]string
+	globalState     manager.TunnelState
+
 	mtw *ManageTunnelsWindow
 
 	tunnelChangedCB  *manager.TunnelChangeCallback
@@ -38,8 +43,9 @@ func NewTray(mtw *ManageTunnelsWindow) (*Tray, error) {
 	var err error
 
 	tray := &Tray{
-		mtw:     mtw,
-		tunnels: make(map[string]*walk.Action),
+		mtw:             mtw,
+		tunnels:         make(map[string]*walk.Action),
+		activeAddresses: make(map[string]string),
 	}
 
 	tray.NotifyIcon, err = walk.NewNotifyIcon(mtw)
@@ -206,52 +212,64 @@ func (tray *Tray) addTunnelAction(tunnel *manager.Tunnel) {
 func (tray *Tray) removeTunnelAction(tunnelName string) {
 	tray.ContextMenu().Actions().Remove(tray.tunnels[tunnelName])
 	delete(tray.tunnels, tunnelName)
+	if _, ok := tray.activeAddresses[tunnelName]; ok {
+		delete(tray.activeAddresses, tunnelName)
+		tray.updateActiveTunnels()
+	}
 }
 
 func (tray *Tray) onTunnelChange(tunnel *manager.Tunnel, state manager.TunnelState, globalState manager.TunnelState, err error) {
 	tray.mtw.Synchronize(func() {
-		tray.updateGlobalState(globalState)
 		tray.SetTunnelState(tunnel, state, err == nil)
+		tray.updateGlobalState(globalState)
 		if !tray.mtw.Visible() && err != nil {
-			tray.ShowError("WireGuard Tunnel Error", err.Error())
+			title := "WireGuard Tunnel Error"
+			if failure, ok := err.(*services.Failure); ok {
+				title = failure.Code.Error()
+			}
+			tray.ShowError(title, err.Error())
 		}
 	})
 }
 
 func (tray *Tray) updateGlobalState(globalState manager.TunnelState) {
+	tray.globalState = globalState
 	if icon, err := iconWithOverlayForState(globalState, 16); err == nil {
 		tray.SetIcon(icon)
 	}
 
-	actions := tray.ContextMenu().Actions()
-	statusAction := actions.At(0)
-	activeCIDRsAction := actions.At(1)
-
-	setTunnelActionsEnabled := func(enabled bool) {
-		for i := 0; i < len(tray.tunnels); i++ {
-			action := actions.At(trayTunnelActionsOffset + i)
-			action.SetEnabled(enabled)
-		}
-	}
-
-	tray.SetToolTip(fmt.Sprintf("WireGuard: %s", textForState(globalState, true)))
+	statusAction := tray.ContextMenu().Actions().At(0)
 	statusAction.SetText(fmt.Sprintf("Status: %s", textForState(globalState, false)))
 
-	switch globalState {
-	case manager.TunnelStarting:
-		setTunnelActionsEnabled(false)
+	tray.updateActiveTunnels()
+}
 
-	case manager.TunnelStarted:
-		activeCIDRsAction.SetVisible(true)
-		setTunnelActionsEnabled(true)
+// updateActiveTunnels shows which tunnels are active, and their addresses.
+func (tray *Tray) updateActiveTunnels() {
+	activeCIDRsAction := tray.ContextMenu().Actions().At(1)
 
-	case manager.TunnelStopping:
-		setTunnelActionsEnabled(false)
+	var names []string
+	for name := range tray.activeAddresses {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return conf.TunnelNameIsLess(names[i], names[j])
+	})
 
-	case manager.TunnelStopped:
-		activeCIDRsAction.SetVisible(false)
-		setTunnelActionsEnabled(true)
+	var addresses []string
+	for _, name := range names {
+		if len(tray.activeAddresses[name]) > 0 {
+			addresses = append(addresses, tray.activeAddresses[name])
+		}
+	}
+	activeCIDRsAction.SetText(fmt.Sprintf("Addresses: %s", strings.Join(addresses, ", ")))
+	activeCIDRsAction.SetVisible(len(addresses) > 0)
+
+	toolTip := fmt.Sprintf("WireGuard: %s", textForState(tray.globalState, true))
+	if tray.globalState == manager.TunnelStarted && len(names) > 0 {
+		toolTip = fmt.Sprintf("WireGuard: %s (%s)", textForState(tray.globalState, true), strings.Join(names, ", "))
 	}
+	tray.SetToolTip(toolTip)
 }
 
 func (tray *Tray) SetTunnelState(tunnel *manager.Tunnel, state manager.TunnelState, showNotifications bool) {
@@ -260,14 +278,18 @@ func (tray *Tray) SetTunnelState(tunnel *manager.Tunnel, state manager.TunnelSta
 		return
 	}
 
-	actions := tray.ContextMenu().Actions()
-	activeCIDRsAction := actions.At(1)
-
 	wasChecked := tunnelAction.Checked()
 
+	// Each tunnel can be toggled unless it is itself on its way up or down.
+	tunnelAction.SetEnabled(state == manager.TunnelStarted || state == manager.TunnelStopped)
+
 	switch state {
 	case manager.TunnelStarted:
-		activeCIDRsAction.SetText("")
+		if _, ok := tray.activeAddresses[tunnel.Name]; !ok {
+			tray.activeAddresses[tunnel.Name] = ""
+			tray.updateActiveTunnels()
+		}
+		name := tunnel.Name
 		go func() {
 			config, err := tunnel.RuntimeConfig()
 			if err == nil {
@@ -280,11 +302,13 @@ func (tray *Tray) SetTunnelState(tunnel *manager.Tunnel, state manager.TunnelSta
 					sb.WriteString(addr.String())
 				}
 				tray.mtw.Synchronize(func() {
-					activeCIDRsAction.SetText(fmt.Sprintf("Addresses: %s", sb.String()))
+					if _, ok := tray.activeAddresses[name]; ok {
+						tray.activeAddresses[name] = sb.String()
+						tray.updateActiveTunnels()
+					}
 				})
 			}
 		}()
-		tunnelAction.SetEnabled(true)
 		tunnelAction.SetChecked(true)
 		if !wasChecked && showNotifications {
 			icon, _ := iconWithOverlayForState(state, 128)
@@ -292,6 +316,10 @@ func (tray *Tray) SetTunnelState(tunnel *manager.Tunnel, state manager.TunnelSta
 		}
 
 	case manager.TunnelStopped:
+		if _, ok := tray.activeAddresses[tunnel.Name]; ok {
+			delete(tray.activeAddresses, tunnel.Name)
+			tray.updateActiveTunnels()
+		}
 		tunnelAction.SetChecked(false)
 		if wasChecked && showNotifications {
 			icon, _ := loadSystemIcon("imageres", 26, 128) // TODO: this icon isn't very good...
@@ -6,7 +6,6 @@
 package ui
 
 import (
-	"archive/zip"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -216,6 +215,18 @@ func (tp *TunnelsPage) CreateToolbar() error {
 	exportAction2.SetText("Export all tunnels to &zip...")
 	exportAction2.Triggered().Attach(tp.onExportTunnels)
 	contextMenu.Actions().Add(exportAction2)
+	exportSelectedAction := walk.NewAction()
+	exportSelectedAction.SetText("E&xport selected tunnel(s)...")
+	exportSelectedAction.Triggered().Attach(tp.onExportSelectedTunnels)
+	contextMenu.Actions().Add(exportSelectedAction)
+	qrCodeAction := walk.NewAction()
+	qrCodeAction.SetText("Show &QR code...")
+	qrCodeAction.Triggered().Attach(tp.onShowQRCode)
+	contextMenu.Actions().Add(qrCodeAction)
+	rotateKeysAction := walk.NewAction()
+	rotateKeysAction.SetText("Rotate &keys...")
+	rotateKeysAction.Triggered().Attach(tp.onRotateKeys)
+	contextMenu.Actions().Add(rotateKeysAction)
 	contextMenu.Actions().Add(walk.NewSeparatorAction())
 	editAction := walk.NewAction()
 	editAction.SetText("Edit &selected tunnel...")
@@ -246,6 +257,9 @@ func (tp *TunnelsPage) CreateToolbar() error {
 		toggleAction.SetEnabled(selected == 1)
 		selectAllAction.SetEnabled(selected < all)
 		editAction.SetEnabled(selected == 1)
+		exportSelectedAction.SetEnabled(selected > 0)
+		qrCodeAction.SetEnabled(selected == 1)
+		rotateKeysAction.SetEnabled(selected == 1)
 	}
 	tp.listView.SelectedIndexesChanged().Attach(setSelectionOrientedOptions)
 	setSelectionOrientedOptions()
@@ -274,132 +288,192 @@ func (tp *TunnelsPage) importFiles(paths []string) {
 				walk.MsgBox(tp.Form(), title, message, flags)
 			})
 		}
-		type unparsedConfig struct {
-			Name   string
-			Config string
+
+		syncedPassphrase := func(path string, again bool) (passphrase string, ok bool) {
+			prompt := fmt.Sprintf("Enter the passphrase of ‘%s’.", filepath.Base(path))
+			if again {
+				prompt = fmt.Sprintf("The passphrase is incorrect. Enter the passphrase of ‘%s’ again.", filepath.Base(path))
+			}
+			done := make(chan struct{})
+			tp.Synchronize(func() {
+				passphrase, ok = runPassphraseDialog(tp.Form(), "Import encrypted bundle", prompt, false)
+				close(done)
+			})
+			<-done
+			return
+		}
+
+		// syncedConflictPolicy asks what to do with the count configurations
+		// that were skipped for having the names of existing tunnels.
+		syncedConflictPolicy := func(count int) (policy conf.ConflictPolicy, ok bool) {
+			done := make(chan struct{})
+			tp.Synchronize(func() {
+				switch walk.MsgBox(tp.Form(), "Tunnels already exist", fmt.Sprintf(`%d of the imported configurations have the same names as existing tunnels.
+
+Do you want to replace the existing tunnels? Choose No to import them under new names instead, or Cancel to leave them out.`, count), walk.MsgBoxYesNoCancel|walk.MsgBoxIconQuestion) {
+				case walk.DlgCmdYes:
+					policy, ok = conf.ConflictOverwrite, true
+				case walk.DlgCmdNo:
+					policy, ok = conf.ConflictRename, true
+				}
+				close(done)
+			})
+			<-done
+			return
 		}
 
 		var (
-			unparsedConfigs []unparsedConfig
-			lastErr         error
+			files   []conf.ImportFile
+			bundles []conf.ImportFile
+			opened  []conf.ImportFile
+			results []conf.ImportResult
 		)
-
 		for _, path := range paths {
-			switch strings.ToLower(filepath.Ext(path)) {
-			case ".conf":
-				textConfig, err := ioutil.ReadFile(path)
-				if err != nil {
-					lastErr = err
-					continue
+			contents, err := ioutil.ReadFile(path)
+			if err != nil {
+				results = append(results, conf.ImportResult{Source: path, Status: conf.ImportFailed, Error: err.Error()})
+				continue
+			}
+			if strings.EqualFold(filepath.Ext(path), conf.BundleFileExtension) {
+				bundles = append(bundles, conf.ImportFile{Path: path, Contents: contents})
+			} else {
+				files = append(files, conf.ImportFile{Path: path, Contents: contents})
+			}
+		}
+
+		tp.listView.SetSuspendTunnelsUpdate(true)
+		defer tp.listView.SetSuspendTunnelsUpdate(false)
+		if len(files) > 0 {
+			imported, err := manager.IPCClientImport(files, conf.ConflictSkip)
+			if err != nil {
+				syncedMsgBox("Error", fmt.Sprintf("Could not import selected configuration: %v", err), walk.MsgBoxIconWarning)
+				return
+			}
+			results = append(results, imported...)
+		}
+		for _, bundle := range bundles {
+			for again := false; ; again = true {
+				var ok bool
+				bundle.Passphrase, ok = syncedPassphrase(bundle.Path, again)
+				if !ok {
+					break
 				}
-				unparsedConfigs = append(unparsedConfigs, unparsedConfig{Name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), Config: string(textConfig)})
-			case ".zip":
-				// 1 .conf + 1 error .zip edge case?
-				r, err := zip.OpenReader(path)
+				imported, err := manager.IPCClientImport([]conf.ImportFile{bundle}, conf.ConflictSkip)
 				if err != nil {
-					lastErr = err
+					syncedMsgBox("Error", fmt.Sprintf("Could not import selected configuration: %v", err), walk.MsgBoxIconWarning)
+					return
+				}
+				if len(imported) == 1 && imported[0].Status == conf.ImportWrongPassphrase {
 					continue
 				}
+				opened = append(opened, bundle)
+				results = append(results, imported...)
+				break
+			}
+		}
 
-				for _, f := range r.File {
-					if strings.ToLower(filepath.Ext(f.Name)) != ".conf" {
-						continue
-					}
-
-					rc, err := f.Open()
-					if err != nil {
-						lastErr = err
-						continue
-					}
-					textConfig, err := ioutil.ReadAll(rc)
-					rc.Close()
-					if err != nil {
-						lastErr = err
-						continue
+		if skipped := conf.SkippedDuplicates(append(files, opened...), results); len(skipped) > 0 {
+			count := 0
+			for i := range skipped {
+				count += len(skipped[i].Only)
+			}
+			if policy, ok := syncedConflictPolicy(count); ok {
+				imported, err := manager.IPCClientImport(skipped, policy)
+				if err != nil {
+					syncedMsgBox("Error", fmt.Sprintf("Could not import selected configuration: %v", err), walk.MsgBoxIconWarning)
+					return
+				}
+				kept := results[:0]
+				for _, result := range results {
+					if result.Status != conf.ImportSkippedDuplicate {
+						kept = append(kept, result)
 					}
-					unparsedConfigs = append(unparsedConfigs, unparsedConfig{Name: strings.TrimSuffix(filepath.Base(f.Name), filepath.Ext(f.Name)), Config: string(textConfig)})
 				}
-
-				r.Close()
+				results = append(kept, imported...)
 			}
 		}
 
-		if lastErr != nil || unparsedConfigs == nil {
-			syncedMsgBox("Error", fmt.Sprintf("Could not import selected configuration: %v", lastErr), walk.MsgBoxIconWarning)
-			return
-		}
-
-		// Add in reverse order so that the first one is selected.
-		sort.Slice(unparsedConfigs, func(i, j int) bool {
-			return conf.TunnelNameIsLess(unparsedConfigs[j].Name, unparsedConfigs[i].Name)
-		})
-
-		existingTunnelList, err := manager.IPCClientTunnels()
-		if err != nil {
-			syncedMsgBox("Error", fmt.Sprintf("Could not enumerate existing tunnels: %v", lastErr), walk.MsgBoxIconWarning)
-			return
-		}
-		existingLowerTunnels := make(map[string]bool, len(existingTunnelList))
-		for _, tunnel := range existingTunnelList {
-			existingLowerTunnels[strings.ToLower(tunnel.Name)] = true
-		}
-
-		configCount := 0
-		tp.listView.SetSuspendTunnelsUpdate(true)
-		for _, unparsedConfig := range unparsedConfigs {
-			if existingLowerTunnels[strings.ToLower(unparsedConfig.Name)] {
-				lastErr = fmt.Errorf("Another tunnel already exists with the name ‘%s’", unparsedConfig.Name)
-				continue
-			}
-			config, err := conf.FromWgQuickWithUnknownEncoding(unparsedConfig.Config, unparsedConfig.Name)
-			if err != nil {
-				lastErr = err
-				continue
-			}
-			_, err = manager.IPCClientNewTunnel(config)
-			if err != nil {
-				lastErr = err
-				continue
+		var failures []string
+		for i := range results {
+			if results[i].Status != conf.ImportSucceeded && results[i].Status != conf.ImportOverwrote {
+				failures = append(failures, results[i].String())
 			}
-			configCount++
 		}
-		tp.listView.SetSuspendTunnelsUpdate(false)
-
-		m, n := configCount, len(unparsedConfigs)
+		m, n := len(results)-len(failures), len(results)
 		switch {
+		case n == 0:
+			syncedMsgBox("Error", "Could not import selected configuration: no configurations were found", walk.MsgBoxIconWarning)
 		case n == 1 && m != n:
-			syncedMsgBox("Error", fmt.Sprintf("Unable to import configuration: %v", lastErr), walk.MsgBoxIconWarning)
+			syncedMsgBox("Error", fmt.Sprintf("Unable to import configuration: %s", failures[0]), walk.MsgBoxIconWarning)
 		case n == 1 && m == n:
 			// nothing
 		case m == n:
 			syncedMsgBox("Imported tunnels", fmt.Sprintf("Imported %d tunnels", m), walk.MsgBoxIconInformation)
 		case m != n:
-			syncedMsgBox("Imported tunnels", fmt.Sprintf("Imported %d of %d tunnels", m, n), walk.MsgBoxIconWarning)
+			syncedMsgBox("Imported tunnels", fmt.Sprintf("Imported %d of %d tunnels:\n\n%s", m, n, strings.Join(failures, "\n")), walk.MsgBoxIconWarning)
 		}
 	}()
 }
 
-func (tp *TunnelsPage) exportTunnels(filePath string) {
+func (tp *TunnelsPage) exportTunnels(filePath string, tunnels []manager.Tunnel) {
+	if strings.HasSuffix(strings.ToLower(filePath), ".json") {
+		tp.exportTunnelsJSON(filePath, tunnels)
+		return
+	}
+	var passphrase string
+	if strings.HasSuffix(strings.ToLower(filePath), conf.BundleFileExtension) {
+		var ok bool
+		passphrase, ok = runPassphraseDialog(tp.Form(), "Export encrypted bundle", "Choose a passphrase for the bundle. It will be needed to import the tunnels again.", true)
+		if !ok {
+			return
+		}
+	}
 	writeFileWithOverwriteHandling(tp.Form(), filePath, func(file *os.File) error {
-		writer := zip.NewWriter(file)
+		configs, err := storedConfigs(tunnels)
+		if err != nil {
+			return err
+		}
 
-		for _, tunnel := range tp.listView.model.tunnels {
-			cfg, err := tunnel.StoredConfig()
+		bytes, err := conf.ZipConfigs(configs)
+		if err != nil {
+			return fmt.Errorf("onExportTunnels: conf.ZipConfigs failed: %v", err)
+		}
+		if len(passphrase) > 0 {
+			bytes, err = conf.EncryptBundle(bytes, passphrase)
 			if err != nil {
-				return fmt.Errorf("onExportTunnels: tunnel.StoredConfig failed: %v", err)
+				return fmt.Errorf("onExportTunnels: conf.EncryptBundle failed: %v", err)
 			}
+		}
+		_, err = file.Write(bytes)
+		return err
+	})
+}
 
-			w, err := writer.Create(tunnel.Name + ".conf")
-			if err != nil {
-				return fmt.Errorf("onExportTunnels: writer.Create failed: %v", err)
-			}
+func storedConfigs(tunnels []manager.Tunnel) ([]*conf.Config, error) {
+	configs := make([]*conf.Config, 0, len(tunnels))
+	for _, tunnel := range tunnels {
+		cfg, err := tunnel.StoredConfig()
+		if err != nil {
+			return nil, fmt.Errorf("onExportTunnels: tunnel.StoredConfig failed: %v", err)
+		}
+		configs = append(configs, &cfg)
+	}
+	return configs, nil
+}
 
-			if _, err := w.Write(([]byte)(cfg.ToWgQuick())); err != nil {
-				return fmt.Errorf("onExportTunnels: cfg.ToWgQuick failed: %v", err)
-			}
+func (tp *TunnelsPage) exportTunnelsJSON(filePath string, tunnels []manager.Tunnel) {
+	writeFileWithOverwriteHandling(tp.Form(), filePath, func(file *os.File) error {
+		configs, err := storedConfigs(tunnels)
+		if err != nil {
+			return err
 		}
 
-		return writer.Close()
+		bytes, err := conf.ConfigsToJSON(configs)
+		if err != nil {
+			return fmt.Errorf("onExportTunnels: conf.ConfigsToJSON failed: %v", err)
+		}
+		_, err = file.Write(bytes)
+		return err
 	})
 }
 
@@ -414,12 +488,12 @@ func (tp *TunnelsPage) addTunnel(config *conf.Config) {
 // Handlers
 
 func (tp *TunnelsPage) onTunnelsViewItemActivated() {
+	tunnel := tp.listView.CurrentTunnel()
+	if tunnel == nil {
+		return
+	}
 	go func() {
-		globalState, err := manager.IPCClientGlobalState()
-		if err != nil || (globalState != manager.TunnelStarted && globalState != manager.TunnelStopped) {
-			return
-		}
-		oldState, err := tp.listView.CurrentTunnel().Toggle()
+		oldState, err := tunnel.Toggle()
 		if err != nil {
 			tp.Synchronize(func() {
 				if oldState == manager.TunnelUnknown {
@@ -443,12 +517,21 @@ func (tp *TunnelsPage) onEditTunnel() {
 
 	if config := runEditDialog(tp.Form(), tunnel); config != nil {
 		go func() {
-			priorState, err := tunnel.State()
-			tunnel.Delete()
-			tunnel.WaitForStop()
-			tunnel, err2 := manager.IPCClientNewTunnel(config)
-			if err == nil && err2 == nil && (priorState == manager.TunnelStarting || priorState == manager.TunnelStarted) {
-				tunnel.Start()
+			if config.Name != tunnel.Name {
+				renamed, err := tunnel.Rename(config.Name)
+				if err != nil {
+					tp.Synchronize(func() {
+						showErrorCustom(tp.Form(), "Unable to rename tunnel", err.Error())
+					})
+					return
+				}
+				tunnel = &renamed
+			}
+			err := tunnel.Save(config)
+			if err != nil {
+				tp.Synchronize(func() {
+					showErrorCustom(tp.Form(), "Unable to save tunnel", err.Error())
+				})
 			}
 		}()
 	}
@@ -531,7 +614,7 @@ func (tp *TunnelsPage) onSelectAll() {
 
 func (tp *TunnelsPage) onImport() {
 	dlg := walk.FileDialog{
-		Filter: "Configuration Files (*.zip, *.conf)|*.zip;*.conf|All Files (*.*)|*.*",
+		Filter: "Configuration Files (*.zip, *.conf, *.json, *.wgbundle)|*.zip;*.conf;*.json;*.wgbundle|QR Code Images (*.png, *.jpg, *.jpeg, *.gif)|*.png;*.jpg;*.jpeg;*.gif|All Files (*.*)|*.*",
 		Title:  "Import tunnel(s) from file...",
 	}
 
@@ -543,8 +626,44 @@ func (tp *TunnelsPage) onImport() {
 }
 
 func (tp *TunnelsPage) onExportTunnels() {
+	tp.runExportDialog(tp.listView.model.tunnels)
+}
+
+func (tp *TunnelsPage) onExportSelectedTunnels() {
+	indices := tp.listView.SelectedIndexes()
+	if len(indices) == 0 {
+		return
+	}
+	tunnels := make([]manager.Tunnel, len(indices))
+	for i, index := range indices {
+		tunnels[i] = tp.listView.model.tunnels[index]
+	}
+	tp.runExportDialog(tunnels)
+}
+
+func (tp *TunnelsPage) onShowQRCode() {
+	tunnel := tp.listView.CurrentTunnel()
+	if tunnel == nil {
+		return
+	}
+	config, err := tunnel.StoredConfig()
+	if showError(err, tp.Form()) {
+		return
+	}
+	runQRCodeDialog(tp.Form(), &config)
+}
+
+func (tp *TunnelsPage) onRotateKeys() {
+	tunnel := tp.listView.CurrentTunnel()
+	if tunnel == nil {
+		return
+	}
+	runRotateKeysDialog(tp.Form(), tunnel)
+}
+
+func (tp *TunnelsPage) runExportDialog(tunnels []manager.Tunnel) {
 	dlg := walk.FileDialog{
-		Filter: "Configuration ZIP Files (*.zip)|*.zip",
+		Filter: "Configuration ZIP Files (*.zip)|*.zip|JSON Files (*.json)|*.json|Encrypted Bundles (*.wgbundle)|*.wgbundle",
 		Title:  "Export tunnels to zip...",
 	}
 
@@ -552,11 +671,19 @@ func (tp *TunnelsPage) onExportTunnels() {
 		return
 	}
 
-	if !strings.HasSuffix(dlg.FilePath, ".zip") {
+	if dlg.FilterIndex == 2 {
+		if !strings.HasSuffix(dlg.FilePath, ".json") {
+			dlg.FilePath += ".json"
+		}
+	} else if dlg.FilterIndex == 3 {
+		if !strings.HasSuffix(dlg.FilePath, conf.BundleFileExtension) {
+			dlg.FilePath += conf.BundleFileExtension
+		}
+	} else if !strings.HasSuffix(dlg.FilePath, ".zip") {
 		dlg.FilePath += ".zip"
 	}
 
-	tp.exportTunnels(dlg.FilePath)
+	tp.exportTunnels(dlg.FilePath, tunnels)
 }
 
 func (tp *TunnelsPage) swapFiller(enabled bool) bool {